@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"pica-comic-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// collectionError 根据错误类型返回对应的状态码
+func collectionError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrCollectionNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidCollection):
+		status = http.StatusBadRequest
+	case errors.Is(err, services.ErrSmartCollection):
+		status = http.StatusConflict
+	default:
+		log.Printf("[Collection] 操作失败: %v", err)
+	}
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
+}
+
+// ListCollections 获取所有收藏夹
+func ListCollections(c *gin.Context) {
+	collections, err := services.GetDownloadManager().ListCollections()
+	if err != nil {
+		collectionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"collections": collections,
+	})
+}
+
+// CreateCollection 创建收藏夹，请求中包含 query 时创建智能收藏夹
+func CreateCollection(c *gin.Context) {
+	var input services.CollectionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	collection, err := services.GetDownloadManager().CreateCollection(input)
+	if err != nil {
+		collectionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, collection)
+}
+
+// GetCollection 获取收藏夹及其中的漫画，支持 page、page_size 分页
+func GetCollection(c *gin.Context) {
+	page, pageSize, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, err := services.GetDownloadManager().GetCollectionComics(c.Param("id"), page, pageSize)
+	if err != nil {
+		collectionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// UpdateCollection 重命名收藏夹或修改智能收藏夹的查询条件
+func UpdateCollection(c *gin.Context) {
+	var input services.CollectionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	collection, err := services.GetDownloadManager().UpdateCollection(c.Param("id"), input)
+	if err != nil {
+		collectionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, collection)
+}
+
+// DeleteCollection 删除收藏夹
+func DeleteCollection(c *gin.Context) {
+	if err := services.GetDownloadManager().DeleteCollection(c.Param("id")); err != nil {
+		collectionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "删除成功",
+	})
+}
+
+// AddCollectionComics 向收藏夹添加漫画
+func AddCollectionComics(c *gin.Context) {
+	var req struct {
+		ComicIDs []string `json:"comic_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	added, err := services.GetDownloadManager().AddCollectionComics(c.Param("id"), req.ComicIDs)
+	if err != nil {
+		collectionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "添加成功",
+		"added":   added,
+	})
+}
+
+// RemoveCollectionComic 从收藏夹移除漫画
+func RemoveCollectionComic(c *gin.Context) {
+	if err := services.GetDownloadManager().RemoveCollectionComic(c.Param("id"), c.Param("comic_id")); err != nil {
+		collectionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "移除成功",
+	})
+}
+
+// ReorderCollection 调整收藏夹中漫画的顺序
+func ReorderCollection(c *gin.Context) {
+	var req struct {
+		ComicIDs []string `json:"comic_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if err := services.GetDownloadManager().ReorderCollection(c.Param("id"), req.ComicIDs); err != nil {
+		collectionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "排序已更新",
+	})
+}
@@ -1,18 +1,260 @@
 package handlers
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"mime"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
+	"pica-comic-server/models"
 	"pica-comic-server/services"
 
 	"github.com/gin-gonic/gin"
 )
 
-// GetComics 获取所有已下载的漫画
+// GetComics 获取已下载的漫画，支持搜索、过滤、排序和分页
+// 不带分页参数时返回全部结果
 func GetComics(c *gin.Context) {
-	comics, err := services.GetDownloadManager().GetAllComics()
+	query, err := parseLibraryQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, err := services.GetDownloadManager().QueryLibrary(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// SearchComics 全文搜索漫画库，按相关度排序并返回高亮片段
+func SearchComics(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "缺少搜索关键词",
+		})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的页码",
+		})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if err != nil || pageSize < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的每页数量",
+		})
+		return
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	result, err := services.GetDownloadManager().SearchComics(q, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetTags 列出所有标签及漫画数量，可用 namespace 参数只列出某个命名空间（空值表示无命名空间的标签）
+func GetTags(c *gin.Context) {
+	var namespace *string
+	if ns, ok := c.GetQuery("namespace"); ok {
+		namespace = &ns
+	}
+
+	dm := services.GetDownloadManager()
+	tags, err := dm.ListTags(namespace)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	namespaces, err := dm.ListTagNamespaces()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tags":       tags,
+		"total":      len(tags),
+		"namespaces": namespaces,
+	})
+}
+
+// GetTagComics 列出带有指定标签的漫画（tag 支持 "namespace:tag"），其余参数同 GetComics
+func GetTagComics(c *gin.Context) {
+	if c.Query("tag") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "缺少标签参数",
+		})
+		return
+	}
+	GetComics(c)
+}
+
+// maxPageSize 单页最多返回的漫画数量
+const maxPageSize = 200
+
+// parseLibraryQuery 解析漫画库查询参数
+func parseLibraryQuery(c *gin.Context) (services.LibraryQuery, error) {
+	q := services.LibraryQuery{
+		Keyword:    strings.TrimSpace(c.Query("q")),
+		Author:     strings.TrimSpace(c.Query("author")),
+		Tags:       splitQueryList(c.QueryArray("tag")),
+		Categories: splitQueryList(c.QueryArray("category")),
+		Type:       c.Query("type"),
+		Sort:       c.DefaultQuery("sort", "time"),
+		Order:      strings.ToLower(c.DefaultQuery("order", "desc")),
+	}
+
+	if !services.IsValidLibrarySort(q.Sort) {
+		return q, fmt.Errorf("无效的排序字段: %s", q.Sort)
+	}
+	if q.Order != "asc" && q.Order != "desc" {
+		return q, fmt.Errorf("无效的排序方向: %s", q.Order)
+	}
+
+	var err error
+	if unread := c.Query("unread"); unread != "" {
+		if q.Unread, err = strconv.ParseBool(unread); err != nil {
+			return q, fmt.Errorf("无效的 unread 参数: %s", unread)
+		}
+	}
+	if q.MinSize, err = parseInt64Param(c, "min_size"); err != nil {
+		return q, err
+	}
+	if q.MaxSize, err = parseInt64Param(c, "max_size"); err != nil {
+		return q, err
+	}
+	if q.AddedAfter, err = parseTimeParam(c, "added_after"); err != nil {
+		return q, err
+	}
+	if q.AddedBefore, err = parseTimeParam(c, "added_before"); err != nil {
+		return q, err
+	}
+
+	q.Page, q.PageSize, err = parsePagination(c)
+	return q, err
+}
+
+// parsePagination 解析分页参数，未提供 page 和 page_size 时返回 (0, 0)，表示不分页
+func parsePagination(c *gin.Context) (page, pageSize int, err error) {
+	pageStr, pageSizeStr := c.Query("page"), c.Query("page_size")
+	if pageStr == "" && pageSizeStr == "" {
+		return 0, 0, nil
+	}
+
+	page = 1
+	if pageStr != "" {
+		if page, err = strconv.Atoi(pageStr); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("无效的页码: %s", pageStr)
+		}
+	}
+	pageSize = 50
+	if pageSizeStr != "" {
+		if pageSize, err = strconv.Atoi(pageSizeStr); err != nil || pageSize < 1 {
+			return 0, 0, fmt.Errorf("无效的每页数量: %s", pageSizeStr)
+		}
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return page, pageSize, nil
+}
+
+// splitQueryList 合并重复参数与逗号分隔的值
+func splitQueryList(values []string) []string {
+	var result []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// parseInt64Param 解析整数查询参数，未提供时返回 0
+func parseInt64Param(c *gin.Context, name string) (int64, error) {
+	s := c.Query(name)
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("无效的参数 %s: %s", name, s)
+	}
+	return v, nil
+}
+
+// parseTimeParam 解析时间查询参数（Unix时间戳、RFC3339 或 2006-01-02），返回 Unix 时间戳
+func parseTimeParam(c *gin.Context, name string) (int64, error) {
+	s := c.Query(name)
+	if s == "" {
+		return 0, nil
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return v, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Unix(), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.Unix(), nil
+	}
+	return 0, fmt.Errorf("无效的时间参数 %s: %s", name, s)
+}
+
+// RescanLibrary 立即重新扫描下载目录，更新扫描文件夹的索引
+func RescanLibrary(c *gin.Context) {
+	result, err := services.GetDownloadManager().ReconcileLibrary()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "扫描完成",
+		"result":  result,
+	})
+}
+
+// GetDedupStats 获取内容寻址存储节省的空间
+func GetDedupStats(c *gin.Context) {
+	stats, err := services.GetDownloadManager().GetDedupStats()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -20,12 +262,77 @@ func GetComics(c *gin.Context) {
 		return
 	}
 
+	c.JSON(http.StatusOK, stats)
+}
+
+// DedupLibrary 对整个库执行一次页面去重（?dry_run=true 只统计可以节省的空间）
+func DedupLibrary(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+	result, err := services.GetDownloadManager().DedupLibrary(dryRun)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrPageStoreUnsupported) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"comics": comics,
-		"total":  len(comics),
+		"message": "去重完成",
+		"result":  result,
 	})
 }
 
+// CheckLibrary 检查漫画库的完整性（?comic_id= 只检查一部漫画，?repair=recount,orphans,requeue 执行修复）
+func CheckLibrary(c *gin.Context) {
+	repairs, err := services.ParseRepairs(c.Query("repair"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	report, err := services.GetDownloadManager().CheckLibrary(services.IntegrityOptions{
+		ComicID:        c.Query("comic_id"),
+		Repairs:        repairs,
+		StartDownloads: true,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "漫画不存在",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "检查完成",
+		"result":  report,
+	})
+}
+
+// GetIntegrityReport 获取最近一次完整性检查的报告
+func GetIntegrityReport(c *gin.Context) {
+	report := services.GetDownloadManager().LastIntegrityReport()
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "还没有执行过完整性检查",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
 // GetComicDetail 获取漫画详情
 func GetComicDetail(c *gin.Context) {
 	id := c.Param("id")
@@ -45,11 +352,28 @@ func GetComicDetail(c *gin.Context) {
 }
 
 // GetComicCover 获取漫画封面
+// 可选参数 size=small|medium 返回统一尺寸的缩略图，不指定则返回原图
 func GetComicCover(c *gin.Context) {
 	id := c.Param("id")
-	log.Printf("[GetComicCover] 请求漫画封面，ID: %s", id)
+	size := c.Query("size")
+	log.Printf("[GetComicCover] 请求漫画封面，ID: %s, size: %s", id, size)
+
+	dm := services.GetDownloadManager()
+
+	var cover services.PageRef
+	var err error
+	if size == "" || size == "original" {
+		cover, err = dm.GetCover(id)
+	} else {
+		if !services.IsValidCoverSize(size) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "无效的封面尺寸",
+			})
+			return
+		}
+		cover, err = dm.GetCoverThumbnail(id, size)
+	}
 
-	coverPath, err := services.GetDownloadManager().GetCoverPath(id)
 	if err != nil {
 		log.Printf("[GetComicCover] 封面不存在，ID: %s, 错误: %v", id, err)
 		c.JSON(http.StatusNotFound, gin.H{
@@ -58,8 +382,144 @@ func GetComicCover(c *gin.Context) {
 		return
 	}
 
-	log.Printf("[GetComicCover] 封面路径: %s", coverPath)
-	c.File(coverPath)
+	log.Printf("[GetComicCover] 封面路径: %s", cover)
+	servePage(c, cover, "")
+}
+
+// servePage 返回页面图片，压缩包中的页面直接从压缩包读取
+// 远程存储中的页面重定向到存储的限时地址（开启重定向时），否则由服务器转发
+// cacheControl 只在成功读取页面后设置，错误响应不会被缓存
+func servePage(c *gin.Context, page services.PageRef, cacheControl string) {
+	setCache := func() {
+		if cacheControl != "" {
+			c.Header("Cache-Control", cacheControl)
+		}
+	}
+	if url, ok := services.GetDownloadManager().PageURL(page); ok {
+		// 限时地址不能被长期缓存
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+	if page.InStorage() {
+		rc, size, err := page.Open()
+		if err != nil {
+			log.Printf("[servePage] 读取存储中的页面失败: %s, 错误: %v", page, err)
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "图片不存在",
+			})
+			return
+		}
+		defer rc.Close()
+		setCache()
+		contentType := mime.TypeByExtension(path.Ext(page.Name()))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		c.DataFromReader(http.StatusOK, size, contentType, rc, nil)
+		return
+	}
+	if !page.InArchive() {
+		if _, _, err := page.Stat(); err != nil {
+			log.Printf("[servePage] 读取页面失败: %s, 错误: %v", page, err)
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "图片不存在",
+			})
+			return
+		}
+		setCache()
+		c.File(page.Path)
+		return
+	}
+
+	data, err := page.ReadAll()
+	if err != nil {
+		log.Printf("[servePage] 读取压缩包页面失败: %s, 错误: %v", page, err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "图片不存在",
+		})
+		return
+	}
+	_, modTime, _ := page.Stat()
+	setCache()
+	http.ServeContent(c.Writer, c.Request, page.Name(), modTime, bytes.NewReader(data))
+}
+
+// SetComicCover 将指定页面设为漫画封面
+func SetComicCover(c *gin.Context) {
+	id := c.Param("id")
+
+	var req struct {
+		Ep   int `json:"ep"`
+		Page int `json:"page"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if err := services.GetDownloadManager().SetCoverFromPage(id, req.Ep, req.Page); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "漫画不存在",
+			})
+		case errors.Is(err, services.ErrEpisodeNotFound), errors.Is(err, services.ErrPageNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrArchiveCover):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		default:
+			log.Printf("[SetComicCover] 设置封面失败，ID: %s, ep: %d, page: %d, 错误: %v", id, req.Ep, req.Page, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "设置封面失败: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "封面已更新",
+	})
+}
+
+// UpdateComic 修改漫画元数据（只修改请求中给出的字段），可选按新标题重命名目录
+func UpdateComic(c *gin.Context) {
+	id := c.Param("id")
+
+	var req models.ComicUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	comic, err := services.GetDownloadManager().UpdateComic(id, req)
+	if err != nil {
+		log.Printf("[UpdateComic] 修改漫画失败，ID: %s, 错误: %v", id, err)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "漫画不存在",
+			})
+			return
+		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidComicUpdate) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, comic)
 }
 
 // GetEpisodeInfo 获取章节信息（页面数量）
@@ -89,6 +549,9 @@ func GetEpisodeInfo(c *gin.Context) {
 	})
 }
 
+// pageCacheControl 页面图片的缓存头（页面内容不会变化）
+const pageCacheControl = "public, max-age=31536000"
+
 // GetComicPage 获取漫画页面
 func GetComicPage(c *gin.Context) {
 	id := c.Param("id")
@@ -113,7 +576,15 @@ func GetComicPage(c *gin.Context) {
 		return
 	}
 
-	imagePath, err := services.GetDownloadManager().GetImagePath(id, ep, page)
+	opts, resize, err := parseResizeOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	pageRef, err := services.GetDownloadManager().GetPage(id, ep, page)
 	if err != nil {
 		log.Printf("[GetComicPage] 图片不存在，ID: %s, ep: %d, page: %d, 错误: %v", id, ep, page, err)
 		c.JSON(http.StatusNotFound, gin.H{
@@ -122,23 +593,168 @@ func GetComicPage(c *gin.Context) {
 		return
 	}
 
-	// 设置缓存头
-	c.Header("Cache-Control", "public, max-age=31536000")
-	c.File(imagePath)
+	services.GetDownloadManager().TouchReadActivity(id)
+	// 带会话ID的请求记入阅读记录
+	if sessionID := readingSessionID(c); sessionID != "" {
+		services.GetDownloadManager().RecordPageView(sessionID, id, ep, page)
+	}
+
+	if !resize {
+		servePage(c, pageRef, pageCacheControl)
+		return
+	}
+
+	resized, key, err := services.GetDownloadManager().GetResizedImage(pageRef, opts)
+	if err != nil {
+		// 无法处理的图片（如文件损坏）回退为原图
+		log.Printf("[GetComicPage] 缩放图片失败，返回原图，ID: %s, ep: %d, page: %d, 错误: %v", id, ep, page, err)
+		servePage(c, pageRef, pageCacheControl)
+		return
+	}
+
+	defer resized.Close()
+
+	// 从已打开的文件输出，缓存淘汰不会影响本次响应；
+	// ServeContent 按响应中的 ETag 精确处理 If-None-Match（逗号分隔列表、W/ 弱标签和 *）
+	var modTime time.Time
+	if info, err := resized.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+	c.Header("Cache-Control", pageCacheControl)
+	c.Header("ETag", `"`+key+`"`)
+	http.ServeContent(c.Writer, c.Request, resized.Name(), modTime, resized)
+}
+
+// parseResizeOptions 解析页面缩放参数（w、h、format、q），未指定任何参数时返回 false
+func parseResizeOptions(c *gin.Context) (services.ResizeOptions, bool, error) {
+	opts := services.ResizeOptions{Format: "jpeg", Quality: 80}
+	wStr, hStr := c.Query("w"), c.Query("h")
+	format, qStr := strings.ToLower(c.Query("format")), c.Query("q")
+
+	if wStr == "" && hStr == "" && format == "" && qStr == "" {
+		return opts, false, nil
+	}
+
+	parseDim := func(s, name string) (int, error) {
+		if s == "" {
+			return 0, nil
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 || v > 8192 {
+			return 0, fmt.Errorf("无效的%s: %s", name, s)
+		}
+		return v, nil
+	}
+
+	var err error
+	if opts.MaxWidth, err = parseDim(wStr, "宽度"); err != nil {
+		return opts, false, err
+	}
+	if opts.MaxHeight, err = parseDim(hStr, "高度"); err != nil {
+		return opts, false, err
+	}
+
+	switch format {
+	case "", "jpeg", "jpg":
+		opts.Format = "jpeg"
+	case "png":
+		opts.Format = "png"
+	default:
+		return opts, false, fmt.Errorf("不支持的输出格式: %s", format)
+	}
+
+	if qStr != "" {
+		q, err := strconv.Atoi(qStr)
+		if err != nil || q < 1 || q > 100 {
+			return opts, false, fmt.Errorf("无效的图片质量: %s", qStr)
+		}
+		opts.Quality = q
+	}
+	if opts.Format == "png" {
+		// PNG 为无损格式，质量参数无效，统一取值以共享缓存
+		opts.Quality = 0
+	}
+
+	return opts, true, nil
+}
+
+// DeleteEpisode 删除漫画的单个章节
+func DeleteEpisode(c *gin.Context) {
+	id := c.Param("id")
+
+	ep, err := strconv.Atoi(c.Param("ep"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的章节号",
+		})
+		return
+	}
+
+	comic, err := services.GetDownloadManager().DeleteEpisode(id, ep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "漫画不存在",
+			})
+		case errors.Is(err, services.ErrEpisodeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		case errors.Is(err, services.ErrRootArchiveEpisode):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		default:
+			log.Printf("[DeleteEpisode] 删除章节失败，ID: %s, ep: %d, 错误: %v", id, ep, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "删除成功",
+		"comic":   comic,
+	})
 }
 
-// DeleteComic 删除漫画
+// DeleteComic 删除漫画（移入回收站）
 func DeleteComic(c *gin.Context) {
 	id := c.Param("id")
+	dm := services.GetDownloadManager()
 
-	if err := services.GetDownloadManager().DeleteComic(id); err != nil {
+	entry, err := dm.TrashComic(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "漫画不存在",
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
+	// permanent=true 时跳过回收站直接彻底删除
+	if c.Query("permanent") == "true" {
+		if err := dm.PurgeTrash(entry.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "删除成功",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "删除成功",
+		"message":  "已移入回收站",
+		"trash_id": entry.ID,
 	})
 }
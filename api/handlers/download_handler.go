@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -195,6 +196,7 @@ type DirectDownloadRequest struct {
 	DetailURL   string              `json:"detail_url"` // 详情页链接
 	Tags        map[string][]string `json:"tags"`
 	Episodes    []DirectEpisode     `json:"episodes"`
+	OnDuplicate string              `json:"on_duplicate"` // 与已有漫画重复时的处理策略：skip、replace、merge、keep（默认）
 }
 
 type DirectEpisode struct {
@@ -252,9 +254,15 @@ func SubmitDirectDownload(c *gin.Context) {
 		})
 		return
 	}
+	if _, err := services.ParseDuplicatePolicy(req.OnDuplicate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
 	// 调用下载管理器直接下载
-	taskID, err := services.GetDownloadManager().SubmitDirectDownload(&req)
+	taskID, report, err := services.GetDownloadManager().SubmitDirectDownload(&req)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "提交下载任务失败: " + err.Error(),
@@ -262,16 +270,93 @@ func SubmitDirectDownload(c *gin.Context) {
 		return
 	}
 
+	if report != nil && report.Action == services.DuplicateActionSkipped {
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "漫画已存在，已跳过下载",
+			"comic_id":  report.ComicID,
+			"duplicate": report,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "下载任务已提交（直接下载模式）",
+		"task_id":   taskID,
+		"duplicate": report,
+	})
+}
+
+// importMetadata 读取导入请求的元数据字段（表单字段或查询参数）
+func importMetadata(c *gin.Context) services.ImportMetadata {
+	field := func(key string) string {
+		if v := c.PostForm(key); v != "" {
+			return v
+		}
+		return c.Query(key)
+	}
+	return services.ImportMetadata{
+		ComicID:      field("comic_id"),
+		Title:        field("title"),
+		Type:         field("type"),
+		Author:       field("author"),
+		Description:  field("description"),
+		Cover:        field("cover"),
+		Eps:          field("eps"),
+		Tags:         field("tags"),
+		DownloadTime: field("download_time"),
+		OnDuplicate:  field("on_duplicate"),
+	}
+}
+
+// importArchive 导入单个压缩包（ZIP/CBZ/tar）
+func importArchive(c *gin.Context, src io.Reader, size int64, meta services.ImportMetadata) {
+	result, err := services.GetDownloadManager().ImportComicArchive(src, size, meta)
+	if err != nil {
+		log.Printf("[导入API] 导入压缩包失败: %v\n", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidImportArchive) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, services.ErrMergeTargetArchive) {
+			status = http.StatusConflict
+		} else if errors.Is(err, services.ErrImportTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		} else if errors.Is(err, services.ErrInsufficientDiskSpace) {
+			status = http.StatusInsufficientStorage
+		}
+		c.JSON(status, gin.H{"error": "导入失败: " + err.Error()})
+		return
+	}
+
+	log.Printf("[导入API] ✅ 漫画 '%s' 导入成功（%s，%d 个章节，%d 页，%s）\n", meta.Title, result.Layout, result.EpsCount, result.PagesCount, result.Duplicate.Action)
 	c.JSON(http.StatusOK, gin.H{
-		"message": "下载任务已提交（直接下载模式）",
-		"task_id": taskID,
+		"message":  "导入成功",
+		"comic_id": result.ComicID,
+		"result":   result,
 	})
 }
 
 // ImportComic 导入已下载的漫画（从客户端上传）
+// 支持三种方式：逐页上传（files 字段）、上传单个压缩包（archive 字段），
+// 或直接以 ZIP/CBZ/tar 作为请求体（元数据放在查询参数中）
 func ImportComic(c *gin.Context) {
 	log.Println("[导入API] 收到漫画导入请求")
 
+	// 请求体即压缩包：边接收边解压，不经过表单解析
+	if c.ContentType() != "multipart/form-data" {
+		meta := importMetadata(c)
+		if meta.ComicID == "" || meta.Title == "" || meta.Type == "" {
+			log.Println("[导入API] 缺少必要字段")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "缺少必要字段"})
+			return
+		}
+		if _, err := services.ParseDuplicatePolicy(meta.OnDuplicate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		importArchive(c, c.Request.Body, -1, meta)
+		return
+	}
+
 	// 1. 解析表单数据
 	err := c.Request.ParseMultipartForm(100 << 20) // 100MB max
 	if err != nil {
@@ -281,20 +366,32 @@ func ImportComic(c *gin.Context) {
 	}
 
 	// 2. 获取元数据
-	comicID := c.PostForm("comic_id")
-	title := c.PostForm("title")
-	comicType := c.PostForm("type")
-	author := c.PostForm("author")
-	description := c.PostForm("description")
-	cover := c.PostForm("cover")
+	meta := importMetadata(c)
 
-	log.Printf("[导入API] 元数据: ID=%s, 标题=%s, 类型=%s, 作者=%s\n", comicID, title, comicType, author)
+	log.Printf("[导入API] 元数据: ID=%s, 标题=%s, 类型=%s, 作者=%s\n", meta.ComicID, meta.Title, meta.Type, meta.Author)
 
-	if comicID == "" || title == "" || comicType == "" {
+	if meta.ComicID == "" || meta.Title == "" || meta.Type == "" {
 		log.Println("[导入API] 缺少必要字段")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少必要字段"})
 		return
 	}
+	if _, err := services.ParseDuplicatePolicy(meta.OnDuplicate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 上传的是单个压缩包
+	if archives := c.Request.MultipartForm.File["archive"]; len(archives) > 0 {
+		file, err := archives[0].Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败: " + err.Error()})
+			return
+		}
+		defer file.Close()
+		log.Printf("[导入API] 收到压缩包: %s (%.2f MB)\n", archives[0].Filename, float64(archives[0].Size)/(1024*1024))
+		importArchive(c, file, archives[0].Size, meta)
+		return
+	}
 
 	// 记录上传的文件数量
 	if c.Request.MultipartForm != nil && c.Request.MultipartForm.File != nil {
@@ -316,16 +413,21 @@ func ImportComic(c *gin.Context) {
 
 	// 3. 调用服务层导入漫画
 	dm := services.GetDownloadManager()
-	err = dm.ImportComicFromClient(c.Request, comicID, title, comicType, author, description, cover)
+	result, err := dm.ImportComicFromClient(c.Request, meta)
 	if err != nil {
 		log.Printf("[导入API] 导入失败: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入失败: " + err.Error()})
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrMergeTargetArchive) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": "导入失败: " + err.Error()})
 		return
 	}
 
-	log.Printf("[导入API] ✅ 漫画 '%s' 导入成功\n", title)
+	log.Printf("[导入API] ✅ 漫画 '%s' 导入成功（%s）\n", meta.Title, result.Duplicate.Action)
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "导入成功",
-		"comic_id": comicID,
+		"comic_id": result.ComicID,
+		"result":   result,
 	})
 }
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pica-comic-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportError 根据错误类型返回对应的状态码
+func exportError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "漫画不存在",
+		})
+	case errors.Is(err, services.ErrEpisodeNotFound), errors.Is(err, services.ErrEmptyExport):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	}
+}
+
+// maxExportRange 单个章节范围最多包含的章节数
+const maxExportRange = 10000
+
+// parseEpisodeList 解析章节列表，支持逗号分隔的章节号和范围（如 1-5,8），为空时返回 nil
+func parseEpisodeList(s string) ([]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var eps []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("无效的章节号: %s", part)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(strings.TrimSpace(to))
+			if err != nil || end < start || end-start >= maxExportRange {
+				return nil, fmt.Errorf("无效的章节范围: %s", part)
+			}
+		}
+		for ep := start; ep <= end; ep++ {
+			eps = append(eps, ep)
+		}
+	}
+	return eps, nil
+}
+
+// prepareExport 根据路由参数准备导出整部漫画、选定章节（?eps=1-5,8）或单个章节
+func prepareExport(c *gin.Context) (*services.ComicExport, bool) {
+	id := c.Param("id")
+	dm := services.GetDownloadManager()
+
+	var export *services.ComicExport
+	var err error
+	if epStr := c.Param("ep"); epStr != "" {
+		ep, convErr := strconv.Atoi(epStr)
+		if convErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "无效的章节号",
+			})
+			return nil, false
+		}
+		export, err = dm.ExportEpisode(id, ep)
+	} else {
+		eps, parseErr := parseEpisodeList(c.Query("eps"))
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": parseErr.Error(),
+			})
+			return nil, false
+		}
+		export, err = dm.ExportEpisodes(id, eps)
+	}
+	if err != nil {
+		exportError(c, err)
+		return nil, false
+	}
+	return export, true
+}
+
+// streamExport 以附件形式边生成边输出导出文件
+// 开始写入后出错只能中断连接，客户端会收到不完整的文件
+func streamExport(c *gin.Context, export *services.ComicExport, ext, contentType string, write func(io.Writer) error) {
+	filename := export.FileName() + ext
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Status(http.StatusOK)
+
+	if err := write(c.Writer); err != nil {
+		log.Printf("[导出] 导出失败: %s, 错误: %v", filename, err)
+		c.Abort()
+		return
+	}
+	log.Printf("[导出] 已导出: %s (%d 页)", filename, export.PageCount())
+}
+
+// ExportCBZ 以 CBZ 格式导出整部漫画或单个章节
+func ExportCBZ(c *gin.Context) {
+	export, ok := prepareExport(c)
+	if !ok {
+		return
+	}
+	streamExport(c, export, ".cbz", "application/vnd.comicbook+zip", export.WriteCBZ)
+}
+
+// ExportEPUB 以 EPUB 3 固定版式导出整部漫画或选定章节
+// 可选参数 w、h、q 缩放页面以适配电子墨水屏，rtl=true 从右往左翻页
+func ExportEPUB(c *gin.Context) {
+	opts, resize, err := parseResizeOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	epubOpts := services.EPUBOptions{RTL: c.Query("rtl") == "true"}
+	if resize {
+		epubOpts.Resize = &opts
+	}
+
+	export, ok := prepareExport(c)
+	if !ok {
+		return
+	}
+	streamExport(c, export, ".epub", "application/epub+zip", func(w io.Writer) error {
+		return export.WriteEPUB(w, epubOpts)
+	})
+}
+
+// ExportPDF 以 PDF 格式导出整部漫画或选定章节
+func ExportPDF(c *gin.Context) {
+	export, ok := prepareExport(c)
+	if !ok {
+		return
+	}
+	streamExport(c, export, ".pdf", "application/pdf", export.WritePDF)
+}
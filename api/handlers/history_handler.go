@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pica-comic-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readingSessionID 获取页面请求中的阅读会话ID（查询参数 session 或请求头 X-Reading-Session）
+func readingSessionID(c *gin.Context) string {
+	if id := strings.TrimSpace(c.Query("session")); id != "" {
+		return id
+	}
+	return strings.TrimSpace(c.GetHeader("X-Reading-Session"))
+}
+
+// GetReadingHistory 获取阅读记录时间线
+// 参数：comic_id、from、to（开始时间范围）、page、page_size（默认第1页，每页50条）
+func GetReadingHistory(c *gin.Context) {
+	q := services.HistoryQuery{ComicID: c.Query("comic_id")}
+
+	var err error
+	if q.From, err = parseTimeParam(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.To, err = parseTimeParam(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.Page, q.PageSize, err = parsePagination(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.PageSize == 0 {
+		q.Page, q.PageSize = 1, 50
+	}
+
+	result, err := services.GetDownloadManager().GetReadingHistory(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// AddReadingSession 客户端上报一条阅读记录
+func AddReadingSession(c *gin.Context) {
+	var input services.SessionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	session, err := services.GetDownloadManager().AddReadingSession(input)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidSession) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, session)
+}
+
+// DeleteReadingHistory 删除阅读记录，指定 comic_id 时只删除该漫画的记录
+func DeleteReadingHistory(c *gin.Context) {
+	deleted, err := services.GetDownloadManager().DeleteReadingHistory(c.Query("comic_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已删除",
+		"deleted": deleted,
+	})
+}
+
+// GetReadingStats 获取阅读统计
+// 参数：days 统计天数（默认 30，最大 3650），top 排行数量（默认 10）
+func GetReadingStats(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 3650 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("无效的 days 参数: %s", c.Query("days")),
+		})
+		return
+	}
+	top, err := strconv.Atoi(c.DefaultQuery("top", "10"))
+	if err != nil || top < 1 || top > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("无效的 top 参数: %s", c.Query("top")),
+		})
+		return
+	}
+
+	stats, err := services.GetDownloadManager().GetReadingStats(days, top)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"pica-comic-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetReadingProgress 获取漫画的阅读进度
+func GetReadingProgress(c *gin.Context) {
+	id := c.Param("id")
+
+	progress, err := services.GetDownloadManager().GetReadingProgress(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if progress == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "没有阅读进度",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// SaveReadingProgress 保存阅读进度
+// 服务器上已有更新的进度时不会覆盖，响应中 applied 为 false，progress 为服务器上的最新进度
+func SaveReadingProgress(c *gin.Context) {
+	id := c.Param("id")
+
+	var update services.ProgressUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	progress, applied, err := services.GetDownloadManager().SaveReadingProgress(id, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "漫画不存在",
+			})
+		case errors.Is(err, services.ErrInvalidProgress):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		default:
+			log.Printf("[SaveReadingProgress] 保存失败，ID: %s, 错误: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"applied":  applied,
+		"progress": progress,
+	})
+}
+
+// DeleteReadingProgress 清除漫画的阅读进度
+func DeleteReadingProgress(c *gin.Context) {
+	if err := services.GetDownloadManager().DeleteReadingProgress(c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已清除",
+	})
+}
+
+// ContinueReading 按最近阅读时间列出有阅读进度的漫画
+// 参数：limit（默认 20，0 表示全部），finished=true 时包含已读完的漫画
+func ContinueReading(c *gin.Context) {
+	limit := 20
+	if s := c.Query("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("无效的 limit 参数: %s", s),
+			})
+			return
+		}
+	}
+	includeFinished := c.Query("finished") == "true"
+
+	items, err := services.GetDownloadManager().ContinueReading(limit, includeFinished)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": items,
+	})
+}
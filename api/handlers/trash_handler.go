@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"pica-comic-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trashError 根据错误类型返回对应的状态码
+func trashError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrTrashNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrTrashConflict):
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
+}
+
+// ListTrash 获取回收站中的漫画
+func ListTrash(c *gin.Context) {
+	entries, err := services.GetDownloadManager().ListTrash()
+	if err != nil {
+		trashError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": entries,
+	})
+}
+
+// RestoreTrash 从回收站恢复漫画
+func RestoreTrash(c *gin.Context) {
+	comic, err := services.GetDownloadManager().RestoreTrash(c.Param("id"))
+	if err != nil {
+		trashError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "恢复成功",
+		"comic":   comic,
+	})
+}
+
+// PurgeTrash 彻底删除回收站中的漫画
+func PurgeTrash(c *gin.Context) {
+	if err := services.GetDownloadManager().PurgeTrash(c.Param("id")); err != nil {
+		trashError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已彻底删除",
+	})
+}
+
+// EmptyTrash 清空回收站
+func EmptyTrash(c *gin.Context) {
+	purged, err := services.GetDownloadManager().EmptyTrash()
+	if err != nil {
+		trashError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "回收站已清空",
+		"purged":  purged,
+	})
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pica-comic-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tus 1.0 协议相关的响应头
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+)
+
+// uploadLocation 上传会话的地址
+func uploadLocation(id string) string {
+	return "/api/download/uploads/" + id
+}
+
+// SetTusHeaders 写入 tus 协议的服务端能力（用于 OPTIONS 请求）
+func SetTusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(services.MaxUploadSize, 10))
+}
+
+// checkTusVersion 客户端声明了 tus 版本时检查是否支持，并在响应中带上 Tus-Resumable
+func checkTusVersion(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if v := c.GetHeader("Tus-Resumable"); v != "" && v != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// setUploadHeaders 写入上传进度相关的响应头
+func setUploadHeaders(c *gin.Context, session *services.UploadSession) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Length, 10))
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "no-store")
+}
+
+// uploadError 根据错误类型返回对应的状态码
+func uploadError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrUploadOffsetMismatch), errors.Is(err, services.ErrUploadBusy),
+		errors.Is(err, services.ErrUploadIncomplete):
+		status = http.StatusConflict
+	case errors.Is(err, services.ErrUploadOutOfRange), errors.Is(err, services.ErrImportTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrInsufficientDiskSpace):
+		status = http.StatusInsufficientStorage
+	case errors.Is(err, services.ErrInvalidUploadLength), errors.Is(err, services.ErrMissingImportMetadata),
+		errors.Is(err, services.ErrInvalidImportArchive), errors.Is(err, services.ErrInvalidDuplicatePolicy):
+		status = http.StatusBadRequest
+	case errors.Is(err, services.ErrMergeTargetArchive):
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
+}
+
+// parseTusMetadata 解析 Upload-Metadata 头："key base64值,key2 base64值"
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("无效的 Upload-Metadata: %s", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// CreateUpload 创建可续传上传会话
+// 文件大小由 Upload-Length 头或 length 参数指定；导入元数据可以放在 Upload-Metadata 头（tus）或查询参数中
+func CreateUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+
+	lengthStr := c.GetHeader("Upload-Length")
+	if lengthStr == "" {
+		lengthStr = c.Query("length")
+	}
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "缺少或无效的文件大小（Upload-Length）",
+		})
+		return
+	}
+	if length > services.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": "文件过大",
+		})
+		return
+	}
+
+	metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	for key, values := range c.Request.URL.Query() {
+		if key != "length" && len(values) > 0 && metadata[key] == "" {
+			metadata[key] = values[0]
+		}
+	}
+
+	session, err := services.GetDownloadManager().CreateUpload(length, metadata)
+	if err != nil {
+		uploadError(c, err)
+		return
+	}
+
+	c.Header("Location", uploadLocation(session.ID))
+	setUploadHeaders(c, session)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "上传会话已创建",
+		"upload":  session,
+	})
+}
+
+// HeadUpload 查询上传进度（tus）：Upload-Offset 为已连续接收的字节数
+func HeadUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	session, err := services.GetDownloadManager().GetUpload(c.Param("id"))
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.Status(http.StatusNotFound)
+		return
+	}
+	setUploadHeaders(c, session)
+	c.Status(http.StatusOK)
+}
+
+// GetUpload 获取上传会话状态，包括已接收的字节区间
+func GetUpload(c *gin.Context) {
+	session, err := services.GetDownloadManager().GetUpload(c.Param("id"))
+	if err != nil {
+		uploadError(c, err)
+		return
+	}
+	setUploadHeaders(c, session)
+	c.JSON(http.StatusOK, gin.H{
+		"upload": session,
+	})
+}
+
+// PatchUpload 按 tus 协议追加数据：Upload-Offset 必须等于已接收的字节数
+func PatchUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "Content-Type 必须为 application/offset+octet-stream",
+		})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "缺少或无效的 Upload-Offset",
+		})
+		return
+	}
+
+	session, err := services.GetDownloadManager().WriteUploadChunk(c.Param("id"), offset, c.Request.Body, true)
+	if session != nil {
+		setUploadHeaders(c, session)
+	}
+	if err != nil {
+		log.Printf("[上传API] 写入分块失败: %s, 错误: %v", c.Param("id"), err)
+		uploadError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// parseContentRange 解析 Content-Range 头（bytes start-end/total），返回起始偏移
+func parseContentRange(header string) (int64, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("无效的 Content-Range: %s", header)
+	}
+	start, _, _ := strings.Cut(spec, "-")
+	return strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+}
+
+// PutUploadChunk 按任意顺序上传分块：偏移由 offset 参数或 Content-Range 头指定
+func PutUploadChunk(c *gin.Context) {
+	var offset int64
+	var err error
+	if s := c.Query("offset"); s != "" {
+		offset, err = strconv.ParseInt(s, 10, 64)
+	} else if h := c.GetHeader("Content-Range"); h != "" {
+		offset, err = parseContentRange(h)
+	} else {
+		err = errors.New("缺少 offset 参数或 Content-Range 头")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的分块偏移: " + err.Error(),
+		})
+		return
+	}
+
+	session, err := services.GetDownloadManager().WriteUploadChunk(c.Param("id"), offset, c.Request.Body, false)
+	if err != nil {
+		log.Printf("[上传API] 写入分块失败: %s, 错误: %v", c.Param("id"), err)
+		uploadError(c, err)
+		return
+	}
+	setUploadHeaders(c, session)
+	c.JSON(http.StatusOK, gin.H{
+		"upload": session,
+	})
+}
+
+// DeleteUpload 取消上传（tus termination）
+func DeleteUpload(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	if err := services.GetDownloadManager().DeleteUpload(c.Param("id")); err != nil {
+		uploadError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// FinalizeUpload 上传完成后导入漫画，元数据字段与导入接口相同，未提供的字段使用创建会话时的元数据
+func FinalizeUpload(c *gin.Context) {
+	id := c.Param("id")
+	meta := importMetadata(c)
+	result, err := services.GetDownloadManager().FinalizeUpload(id, meta)
+	if err != nil {
+		log.Printf("[上传API] 导入上传文件失败: %s, 错误: %v", id, err)
+		uploadError(c, err)
+		return
+	}
+
+	log.Printf("[上传API] ✅ 上传 %s 导入成功（%s，%d 个章节，%d 页）", id, result.Layout, result.EpsCount, result.PagesCount)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "导入成功",
+		"comic_id": result.ComicID,
+		"result":   result,
+	})
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"strings"
+
 	"pica-comic-server/api/handlers"
 
 	"github.com/gin-gonic/gin"
@@ -17,11 +19,68 @@ func SetupRoutes(r *gin.Engine) {
 		comics := api.Group("/comics")
 		{
 			comics.GET("", handlers.GetComics)
+			comics.GET("/search", handlers.SearchComics)      // 全文搜索
+			comics.POST("/rescan", handlers.RescanLibrary)    // 重新扫描下载目录
+			comics.GET("/dedup", handlers.GetDedupStats)      // 页面去重节省的空间
+			comics.POST("/dedup", handlers.DedupLibrary)      // 对整个库执行页面去重
+			comics.GET("/check", handlers.GetIntegrityReport) // 最近一次完整性检查的报告
+			comics.POST("/check", handlers.CheckLibrary)      // 检查漫画库的完整性，可选修复
 			comics.GET("/:id", handlers.GetComicDetail)
+			comics.PATCH("/:id", handlers.UpdateComic) // 修改漫画信息
 			comics.GET("/:id/cover", handlers.GetComicCover)
+			comics.PUT("/:id/cover", handlers.SetComicCover) // 将指定页面设为封面
+			comics.GET("/:id/progress", handlers.GetReadingProgress)
+			comics.PUT("/:id/progress", handlers.SaveReadingProgress)
+			comics.DELETE("/:id/progress", handlers.DeleteReadingProgress)
+			comics.GET("/:id/export.cbz", handlers.ExportCBZ)    // 导出为 CBZ
+			comics.GET("/:id/export.epub", handlers.ExportEPUB)  // 导出为 EPUB
+			comics.GET("/:id/export.pdf", handlers.ExportPDF)    // 导出为 PDF
 			comics.GET("/:id/:ep/info", handlers.GetEpisodeInfo) // 获取章节页面数量
+			comics.GET("/:id/:ep/export.cbz", handlers.ExportCBZ)
+			comics.GET("/:id/:ep/export.epub", handlers.ExportEPUB)
+			comics.GET("/:id/:ep/export.pdf", handlers.ExportPDF)
 			comics.GET("/:id/:ep/:page", handlers.GetComicPage)
 			comics.DELETE("/:id", handlers.DeleteComic)
+			comics.DELETE("/:id/:ep", handlers.DeleteEpisode) // 删除单个章节
+		}
+
+		// 标签浏览
+		tags := api.Group("/tags")
+		{
+			tags.GET("", handlers.GetTags)
+			tags.GET("/comics", handlers.GetTagComics)
+		}
+
+		// 阅读记录
+		reading := api.Group("/reading")
+		{
+			reading.GET("/continue", handlers.ContinueReading) // 继续阅读
+			reading.GET("/history", handlers.GetReadingHistory)
+			reading.POST("/history", handlers.AddReadingSession)
+			reading.DELETE("/history", handlers.DeleteReadingHistory)
+			reading.GET("/stats", handlers.GetReadingStats)
+		}
+
+		// 收藏夹
+		collections := api.Group("/collections")
+		{
+			collections.GET("", handlers.ListCollections)
+			collections.POST("", handlers.CreateCollection)
+			collections.GET("/:id", handlers.GetCollection)
+			collections.PATCH("/:id", handlers.UpdateCollection)
+			collections.DELETE("/:id", handlers.DeleteCollection)
+			collections.POST("/:id/comics", handlers.AddCollectionComics)
+			collections.DELETE("/:id/comics/:comic_id", handlers.RemoveCollectionComic)
+			collections.PUT("/:id/order", handlers.ReorderCollection)
+		}
+
+		// 回收站
+		trash := api.Group("/trash")
+		{
+			trash.GET("", handlers.ListTrash)
+			trash.DELETE("", handlers.EmptyTrash)
+			trash.POST("/:id/restore", handlers.RestoreTrash)
+			trash.DELETE("/:id", handlers.PurgeTrash)
 		}
 
 		// 下载管理
@@ -34,6 +93,15 @@ func SetupRoutes(r *gin.Engine) {
 			download.POST("/start", handlers.StartDownload)
 			download.POST("/pause", handlers.PauseDownload)
 			download.DELETE("/:id", handlers.CancelDownload)
+
+			// 可续传上传（兼容 tus 1.0 协议）
+			download.POST("/uploads", handlers.CreateUpload)
+			download.HEAD("/uploads/:id", handlers.HeadUpload)
+			download.GET("/uploads/:id", handlers.GetUpload)
+			download.PATCH("/uploads/:id", handlers.PatchUpload)  // tus：按顺序追加
+			download.PUT("/uploads/:id", handlers.PutUploadChunk) // 按偏移上传分块
+			download.DELETE("/uploads/:id", handlers.DeleteUpload)
+			download.POST("/uploads/:id/finalize", handlers.FinalizeUpload)
 		}
 
 		// PicaComic API
@@ -58,10 +126,15 @@ func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Content-Range, Upload-Length, Upload-Offset, Upload-Metadata, Tus-Resumable")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH, HEAD")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Upload-Expires, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size")
 
 		if c.Request.Method == "OPTIONS" {
+			// tus 客户端通过 OPTIONS 获取服务端支持的协议版本和扩展
+			if strings.HasPrefix(c.Request.URL.Path, "/api/download/uploads") {
+				handlers.SetTusHeaders(c)
+			}
 			c.AbortWithStatus(204)
 			return
 		}
@@ -37,18 +37,18 @@ echo ""
 
 # 构建 macOS 版本
 echo "🍎 构建 macOS (darwin/amd64)..."
-GOOS=darwin GOARCH=amd64 go build -ldflags="-s -w" -o $OUTPUT_DIR/pica-server-darwin-amd64 main.go
+GOOS=darwin GOARCH=amd64 go build -tags sqlite_fts5 -ldflags="-s -w" -o $OUTPUT_DIR/pica-server-darwin-amd64 main.go
 echo "✅ macOS (amd64) 构建完成"
 echo ""
 
 echo "🍎 构建 macOS (darwin/arm64)..."
-GOOS=darwin GOARCH=arm64 go build -ldflags="-s -w" -o $OUTPUT_DIR/pica-server-darwin-arm64 main.go
+GOOS=darwin GOARCH=arm64 go build -tags sqlite_fts5 -ldflags="-s -w" -o $OUTPUT_DIR/pica-server-darwin-arm64 main.go
 echo "✅ macOS (arm64) 构建完成"
 echo ""
 
 # 构建 Linux 版本
 echo "🐧 构建 Linux (linux/amd64)..."
-GOOS=linux GOARCH=amd64 go build -ldflags="-s -w" -o $OUTPUT_DIR/pica-server-linux-amd64 main.go
+GOOS=linux GOARCH=amd64 go build -tags sqlite_fts5 -ldflags="-s -w" -o $OUTPUT_DIR/pica-server-linux-amd64 main.go
 echo "✅ Linux (amd64) 构建完成"
 echo ""
 
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"pica-comic-server/services"
+)
+
+// issueLabels 完整性检查问题类型的说明
+var issueLabels = map[string]string{
+	services.IssueEmptyPage:        "空页面",
+	services.IssueBrokenPage:       "损坏的页面",
+	services.IssueTruncatedPage:    "不完整的页面",
+	services.IssueMissingPage:      "缺少的页面",
+	services.IssueMissingEpisode:   "缺少的章节",
+	services.IssueUntrackedEpisode: "未记录的章节",
+	services.IssuePagesCount:       "页数不一致",
+	services.IssueOrphanRow:        "孤立的记录",
+	services.IssueOrphanFolder:     "孤立的目录",
+}
+
+// runIntegrityCheck 命令行模式：检查漫画库的完整性并打印报告，返回进程退出码（有未修复的问题时为 1）
+func runIntegrityCheck(downloadPath string, storage storageOptions, repair, comicID string) int {
+	repairs, err := services.ParseRepairs(repair)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	if _, _, err := openLibrary(downloadPath, storage); err != nil {
+		fmt.Printf("初始化失败: %v\n", err)
+		return 2
+	}
+
+	report, err := services.GetDownloadManager().CheckLibrary(services.IntegrityOptions{
+		ComicID: comicID,
+		Repairs: repairs,
+	})
+	if err != nil {
+		fmt.Printf("检查失败: %v\n", err)
+		return 2
+	}
+
+	for _, issue := range report.Issues {
+		where := issue.Directory
+		if issue.Title != "" {
+			where = issue.Title
+		}
+		if issue.Ep > 0 {
+			where += fmt.Sprintf(" 第 %d 章", issue.Ep)
+		}
+		if issue.Page > 0 {
+			where += fmt.Sprintf(" 第 %d 页", issue.Page)
+		}
+		if issue.File != "" {
+			where += fmt.Sprintf("（%s）", issue.File)
+		}
+		line := fmt.Sprintf("[%s] %s: %s", issueLabels[issue.Type], where, issue.Detail)
+		switch {
+		case issue.Repair != "":
+			line += " -> 已修复（" + issue.Repair + "）"
+		case issue.Requeueable:
+			line += " -> 可重新下载（-repair requeue）"
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Println()
+	fmt.Printf("检查了 %d 部漫画、%d 页，耗时 %s\n", report.Comics, report.Pages, report.Duration)
+	if len(report.Issues) == 0 {
+		fmt.Println("没有发现问题")
+		return 0
+	}
+	types := make([]string, 0, len(report.Summary))
+	for typ := range report.Summary {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		fmt.Printf("  %s: %d\n", issueLabels[typ], report.Summary[typ])
+	}
+	fmt.Printf("共 %d 个问题，已修复 %d 个\n", len(report.Issues), report.Repaired)
+	if len(report.Tasks) > 0 {
+		fmt.Printf("已创建 %d 个重新下载任务，启动服务器后通过 POST /api/download/start 执行\n", len(report.Tasks))
+	}
+	for _, e := range report.Errors {
+		fmt.Printf("[修复失败] %s\n", e)
+	}
+	if report.Repaired < len(report.Issues) {
+		return 1
+	}
+	return 0
+}
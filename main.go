@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"pica-comic-server/api"
 	"pica-comic-server/services"
@@ -18,15 +24,36 @@ func main() {
 	port := flag.String("port", "8080", "服务器端口")
 	host := flag.String("host", "0.0.0.0", "服务器地址")
 	downloadPath := flag.String("download-path", "", "下载目录路径")
+	imageCacheMB := flag.Int64("image-cache-size", 512, "缩放图片缓存大小上限（MB）")
+	scanInterval := flag.Duration("scan-interval", 10*time.Minute, "后台扫描下载目录的间隔（0 表示只在启动和手动触发时扫描）")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "回收站保留期，超过后自动彻底删除（0 表示不自动清理）")
+	uploadExpiry := flag.Duration("upload-expiry", services.DefaultUploadExpiry, "未完成的上传会话在最后一次写入后保留的时长")
+	dedupPages := flag.Bool("dedup-pages", false, "下载、导入完成后把页面存入内容寻址存储，相同的图片只保存一份（需要本地存储）")
+	var storage storageOptions
+	flag.StringVar(&storage.backend, "storage", "local", "漫画文件的存储后端：local（下载目录）或 s3（S3 兼容对象存储）")
+	flag.StringVar(&storage.s3.Endpoint, "s3-endpoint", "", "S3 服务地址，如 https://s3.amazonaws.com 或 http://127.0.0.1:9000")
+	flag.StringVar(&storage.s3.Region, "s3-region", "us-east-1", "S3 区域")
+	flag.StringVar(&storage.s3.Bucket, "s3-bucket", "", "S3 存储桶")
+	flag.StringVar(&storage.s3.Prefix, "s3-prefix", "", "存储桶中的根目录（可选）")
+	flag.BoolVar(&storage.s3.PathStyle, "s3-path-style", true, "使用路径风格地址（MinIO 等自建服务需要开启，AWS 可关闭）")
+	flag.BoolVar(&storage.redirect, "s3-redirect", true, "页面请求重定向到 S3 预签名地址（关闭时由服务器转发）")
+	flag.DurationVar(&storage.urlExpiry, "s3-url-expiry", 15*time.Minute, "S3 预签名地址的有效期")
+	check := flag.Bool("check", false, "检查漫画库的完整性，输出报告后退出（不启动服务器）")
+	repair := flag.String("repair", "", "与 -check 一起使用，执行修复：recount、orphans、requeue（逗号分隔）")
+	checkComic := flag.String("check-comic", "", "与 -check 一起使用，只检查指定ID的漫画")
 	flag.Parse()
 
+	if *check {
+		os.Exit(runIntegrityCheck(*downloadPath, storage, *repair, *checkComic))
+	}
+
 	fmt.Println("=================================")
 	fmt.Println("PicaComic 服务器")
 	fmt.Println("=================================")
 	fmt.Println()
 
 	// 初始化服务
-	if err := initServices(*downloadPath); err != nil {
+	if err := initServices(*downloadPath, *imageCacheMB, *scanInterval, *trashRetention, *uploadExpiry, storage, *dedupPages); err != nil {
 		log.Fatalf("初始化服务失败: %v", err)
 	}
 
@@ -42,18 +69,72 @@ func main() {
 	// 启动服务器
 	addr := fmt.Sprintf("%s:%s", *host, *port)
 	fmt.Printf("\n服务器启动成功！正在监听 %s\n", addr)
-	if err := r.Run(addr); err != nil {
-		log.Fatalf("服务器启动失败: %v", err)
+	srv := &http.Server{Addr: addr, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("服务器启动失败: %v", err)
+		}
+	}()
+
+	// 收到退出信号后等待进行中的请求完成，再写入尚未保存的数据
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	fmt.Println("\n正在关闭服务器...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("关闭服务器失败: %v", err)
+	}
+	if err := services.GetDownloadManager().Shutdown(); err != nil {
+		log.Printf("保存阅读记录失败: %v", err)
 	}
 }
 
-func initServices(downloadPath string) error {
-	fmt.Println("正在初始化服务...")
+// storageOptions 存储后端相关的命令行参数，S3 访问密钥从环境变量读取
+type storageOptions struct {
+	backend   string
+	s3        services.S3Config
+	redirect  bool
+	urlExpiry time.Duration
+}
 
+// setupStorage 按命令行参数设置漫画文件的存储后端
+func setupStorage(opts storageOptions) error {
+	switch opts.backend {
+	case "", "local":
+		return nil
+	case "s3":
+		opts.s3.AccessKey = firstEnv("S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID")
+		opts.s3.SecretKey = firstEnv("S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY")
+		storage, err := services.NewS3Storage(opts.s3)
+		if err != nil {
+			return err
+		}
+		services.GetDownloadManager().SetStorage(storage)
+		services.GetDownloadManager().SetStorageRedirect(opts.redirect, opts.urlExpiry)
+		fmt.Printf("存储后端: S3（%s/%s）\n", opts.s3.Endpoint, opts.s3.Bucket)
+		return nil
+	}
+	return fmt.Errorf("未知的存储后端: %s", opts.backend)
+}
+
+// firstEnv 返回第一个非空的环境变量
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// openLibrary 创建数据目录和下载目录，初始化下载管理器和存储后端，返回数据目录和下载目录
+func openLibrary(downloadPath string, storage storageOptions) (string, string, error) {
 	// 设置数据目录
 	dataDir := filepath.Join(".", "data")
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return fmt.Errorf("创建数据目录失败: %w", err)
+		return "", "", fmt.Errorf("创建数据目录失败: %w", err)
 	}
 
 	// 设置下载目录
@@ -61,14 +142,47 @@ func initServices(downloadPath string) error {
 		downloadPath = filepath.Join(dataDir, "download")
 	}
 	if err := os.MkdirAll(downloadPath, 0755); err != nil {
-		return fmt.Errorf("创建下载目录失败: %w", err)
+		return "", "", fmt.Errorf("创建下载目录失败: %w", err)
 	}
 
 	// 初始化下载管理器
 	if err := services.InitDownloadManager(downloadPath); err != nil {
-		return fmt.Errorf("初始化下载管理器失败: %w", err)
+		return "", "", fmt.Errorf("初始化下载管理器失败: %w", err)
 	}
 
+	// 设置存储后端
+	if err := setupStorage(storage); err != nil {
+		return "", "", fmt.Errorf("初始化存储失败: %w", err)
+	}
+	return dataDir, downloadPath, nil
+}
+
+func initServices(downloadPath string, imageCacheMB int64, scanInterval, trashRetention, uploadExpiry time.Duration, storage storageOptions, dedupPages bool) error {
+	fmt.Println("正在初始化服务...")
+
+	dataDir, downloadPath, err := openLibrary(downloadPath, storage)
+	if err != nil {
+		return err
+	}
+	services.GetDownloadManager().SetImageCacheSize(imageCacheMB * 1024 * 1024)
+
+	// 设置页面去重
+	if err := services.GetDownloadManager().SetPageDedup(dedupPages); err != nil {
+		return fmt.Errorf("开启页面去重失败: %w", err)
+	}
+
+	// 启动后台库索引器
+	services.GetDownloadManager().StartLibraryIndexer(scanInterval)
+
+	// 启动回收站自动清理
+	services.GetDownloadManager().StartTrashPurger(trashRetention)
+
+	// 启动过期上传会话清理
+	services.GetDownloadManager().StartUploadJanitor(uploadExpiry)
+
+	// 启动阅读记录的后台写入
+	services.GetDownloadManager().StartSessionFlusher()
+
 	fmt.Println("服务初始化完成！")
 	fmt.Printf("数据目录: %s\n", dataDir)
 	fmt.Printf("下载目录: %s\n", downloadPath)
@@ -82,17 +196,70 @@ func printAPIInfo(host, port string) {
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println("漫画管理:")
 	fmt.Println("  GET    /api/comics              - 获取所有已下载的漫画")
+	fmt.Println("  GET    /api/comics/search       - 全文搜索漫画")
+	fmt.Println("  POST   /api/comics/rescan       - 重新扫描下载目录")
+	fmt.Println("  GET    /api/comics/dedup        - 页面去重节省的空间")
+	fmt.Println("  POST   /api/comics/dedup        - 对整个库执行页面去重（?dry_run=true 只统计）")
+	fmt.Println("  GET    /api/comics/check        - 最近一次完整性检查的报告")
+	fmt.Println("  POST   /api/comics/check        - 检查漫画库的完整性（?repair=recount,orphans,requeue 修复）")
 	fmt.Println("  GET    /api/comics/:id          - 获取漫画详情")
-	fmt.Println("  GET    /api/comics/:id/cover    - 获取漫画封面")
-	fmt.Println("  GET    /api/comics/:id/:ep/:page - 获取漫画页面图片")
-	fmt.Println("  DELETE /api/comics/:id          - 删除漫画")
+	fmt.Println("  PATCH  /api/comics/:id          - 修改漫画信息（可重命名目录）")
+	fmt.Println("  GET    /api/comics/:id/cover    - 获取漫画封面（?size=small|medium 获取缩略图）")
+	fmt.Println("  PUT    /api/comics/:id/cover    - 将指定页面设为封面")
+	fmt.Println("  GET    /api/comics/:id/progress - 获取阅读进度")
+	fmt.Println("  PUT    /api/comics/:id/progress - 保存阅读进度（以最后更新的为准）")
+	fmt.Println("  DELETE /api/comics/:id/progress - 清除阅读进度")
+	fmt.Println("  GET    /api/comics/:id/:ep/:page - 获取漫画页面图片（?w=&h=&format=&q= 缩放）")
+	fmt.Println("  GET    /api/comics/:id/export.cbz - 导出为 CBZ（含 ComicInfo.xml，?eps=1-5,8 选择章节）")
+	fmt.Println("  GET    /api/comics/:id/:ep/export.cbz - 导出单个章节为 CBZ")
+	fmt.Println("  GET    /api/comics/:id/export.epub - 导出为 EPUB 固定版式（?eps=&w=&h=&q= 缩放，&rtl=true）")
+	fmt.Println("  GET    /api/comics/:id/:ep/export.epub - 导出单个章节为 EPUB")
+	fmt.Println("  GET    /api/comics/:id/export.pdf - 导出为 PDF（每章一个书签，?eps= 选择章节）")
+	fmt.Println("  GET    /api/comics/:id/:ep/export.pdf - 导出单个章节为 PDF")
+	fmt.Println("  DELETE /api/comics/:id          - 删除漫画（移入回收站，?permanent=true 彻底删除）")
+	fmt.Println("  DELETE /api/comics/:id/:ep      - 删除单个章节")
+	fmt.Println()
+	fmt.Println("标签浏览:")
+	fmt.Println("  GET    /api/tags                - 获取所有标签及数量（?namespace= 按命名空间）")
+	fmt.Println("  GET    /api/tags/comics         - 获取带有指定标签的漫画（?tag=female:glasses）")
+	fmt.Println()
+	fmt.Println("阅读记录:")
+	fmt.Println("  GET    /api/reading/continue    - 继续阅读（按最近阅读排序）")
+	fmt.Println("  GET    /api/reading/history     - 阅读记录时间线")
+	fmt.Println("  POST   /api/reading/history     - 上报阅读记录")
+	fmt.Println("  DELETE /api/reading/history     - 删除阅读记录（?comic_id= 只删除该漫画）")
+	fmt.Println("  GET    /api/reading/stats       - 阅读统计（?days=30）")
+	fmt.Println()
+	fmt.Println("收藏夹:")
+	fmt.Println("  GET    /api/collections         - 获取所有收藏夹")
+	fmt.Println("  POST   /api/collections         - 创建收藏夹（带 query 为智能收藏夹）")
+	fmt.Println("  GET    /api/collections/:id     - 获取收藏夹中的漫画")
+	fmt.Println("  PATCH  /api/collections/:id     - 重命名/修改查询条件")
+	fmt.Println("  DELETE /api/collections/:id     - 删除收藏夹")
+	fmt.Println("  POST   /api/collections/:id/comics - 添加漫画")
+	fmt.Println("  DELETE /api/collections/:id/comics/:comic_id - 移除漫画")
+	fmt.Println("  PUT    /api/collections/:id/order - 调整漫画顺序")
+	fmt.Println()
+	fmt.Println("回收站:")
+	fmt.Println("  GET    /api/trash               - 获取回收站中的漫画")
+	fmt.Println("  POST   /api/trash/:id/restore   - 恢复漫画")
+	fmt.Println("  DELETE /api/trash/:id           - 彻底删除")
+	fmt.Println("  DELETE /api/trash               - 清空回收站")
 	fmt.Println()
 	fmt.Println("下载管理:")
 	fmt.Println("  POST   /api/download            - 添加下载任务")
 	fmt.Println("  GET    /api/download/queue      - 获取下载队列")
 	fmt.Println("  POST   /api/download/start      - 开始/继续下载")
 	fmt.Println("  POST   /api/download/pause      - 暂停下载")
+	fmt.Println("  POST   /api/download/import     - 导入漫画（逐页上传，或单个 ZIP/CBZ/tar 压缩包）")
 	fmt.Println("  DELETE /api/download/:id        - 取消下载任务")
+	fmt.Println("  POST   /api/download/uploads    - 创建可续传上传（兼容 tus 1.0，Upload-Length 指定大小）")
+	fmt.Println("  HEAD   /api/download/uploads/:id - 查询已接收的字节数（Upload-Offset）")
+	fmt.Println("  GET    /api/download/uploads/:id - 获取上传状态及已接收的区间")
+	fmt.Println("  PATCH  /api/download/uploads/:id - 按顺序追加数据（tus）")
+	fmt.Println("  PUT    /api/download/uploads/:id - 按偏移上传分块（?offset= 或 Content-Range）")
+	fmt.Println("  DELETE /api/download/uploads/:id - 取消上传")
+	fmt.Println("  POST   /api/download/uploads/:id/finalize - 上传完成后导入漫画")
 	fmt.Println()
 	fmt.Println("PicaComic API:")
 	fmt.Println("  POST   /api/picacg/login        - 登录 PicaComic")
@@ -11,6 +11,7 @@ type Comic struct {
 	Cover       string    `json:"cover"`
 	Tags        []string  `json:"tags"`
 	Categories  []string  `json:"categories"`
+	TagGroups   TagGroups `json:"tag_groups"` // 带命名空间的标签，如 female: [glasses]
 	EpsCount    int       `json:"eps_count"`
 	PagesCount  int       `json:"pages_count"`
 	Type        string    `json:"type"` // picacg, ehentai, jm, etc.
@@ -19,6 +20,9 @@ type Comic struct {
 	DetailURL   string    `json:"detail_url"` // 详情页链接
 }
 
+// TagGroups 按命名空间分组的标签（命名空间 -> 标签列表）
+type TagGroups map[string][]string
+
 // ComicDetail 漫画详细信息
 type ComicDetail struct {
 	Comic
@@ -76,6 +80,19 @@ type DownloadRequest struct {
 	Extra       map[string]interface{} `json:"extra,omitempty"`
 }
 
+// ComicUpdateRequest 修改漫画元数据请求，未提供的字段保持不变
+type ComicUpdateRequest struct {
+	Title        *string   `json:"title"`
+	Author       *string   `json:"author"`
+	Description  *string   `json:"description"`
+	Tags         []string  `json:"tags"`       // 扁平标签列表（替换全部标签）
+	TagGroups    TagGroups `json:"tag_groups"` // 带命名空间的标签（优先于 tags）
+	Categories   []string  `json:"categories"`
+	Type         *string   `json:"type"`
+	DetailURL    *string   `json:"detail_url"`
+	RenameFolder bool      `json:"rename_folder"` // 按新标题重命名存储目录
+}
+
 // LoginRequest 登录请求
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required"`
@@ -33,7 +33,7 @@ echo "端口: $PORT"
 echo "监听地址: $HOST"
 
 # 构建命令
-CMD="go run main.go -port $PORT -host $HOST"
+CMD="go run -tags sqlite_fts5 main.go -port $PORT -host $HOST"
 if [ -n "$DOWNLOAD_PATH" ]; then
     CMD="$CMD -download-path $DOWNLOAD_PATH"
     echo "下载目录: $DOWNLOAD_PATH"
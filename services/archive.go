@@ -0,0 +1,93 @@
+package services
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// archiveExts 视为漫画压缩包的扩展名
+var archiveExts = map[string]bool{
+	".cbz": true,
+	".zip": true,
+}
+
+// isArchiveFile 判断文件名是否为漫画压缩包
+func isArchiveFile(name string) bool {
+	return archiveExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// archiveStem 去掉压缩包扩展名
+func archiveStem(name string) string {
+	if isArchiveFile(name) {
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+// isArchivePage 判断压缩包中的条目是否为页面图片（排除目录、隐藏文件和 macOS 生成的 __MACOSX 元数据）
+func isArchivePage(f *zip.File) bool {
+	if f.FileInfo().IsDir() {
+		return false
+	}
+	for _, part := range strings.Split(f.Name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return false
+		}
+	}
+	return isPageImage(path.Base(f.Name))
+}
+
+// listArchivePages 列出压缩包中的页面图片条目（按文件名自然排序）
+func listArchivePages(archivePath string) ([]string, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("打开压缩包失败: %w", err)
+	}
+	defer r.Close()
+
+	var names []string
+	for _, f := range r.File {
+		if isArchivePage(f) {
+			names = append(names, f.Name)
+		}
+	}
+	sortNatural(names)
+	return names, nil
+}
+
+// archiveEntryReader 读取压缩包条目，关闭时同时关闭压缩包
+type archiveEntryReader struct {
+	io.ReadCloser
+	archive *zip.ReadCloser
+}
+
+func (r *archiveEntryReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.archive.Close()
+	return err
+}
+
+// openArchiveEntry 打开压缩包中的指定条目，同时返回其解压后的大小
+func openArchiveEntry(archivePath, entry string) (io.ReadCloser, int64, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("打开压缩包失败: %w", err)
+	}
+	for _, f := range r.File {
+		if f.Name != entry {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			r.Close()
+			return nil, 0, err
+		}
+		return &archiveEntryReader{ReadCloser: rc, archive: r}, int64(f.UncompressedSize64), nil
+	}
+	r.Close()
+	return nil, 0, fmt.Errorf("压缩包中没有该文件: %s", entry)
+}
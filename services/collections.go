@@ -0,0 +1,435 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"pica-comic-server/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrCollectionNotFound 收藏夹不存在
+	ErrCollectionNotFound = errors.New("收藏夹不存在")
+	// ErrInvalidCollection 收藏夹参数无效
+	ErrInvalidCollection = errors.New("无效的收藏夹参数")
+	// ErrSmartCollection 智能收藏夹的内容由查询条件决定，不能手动修改
+	ErrSmartCollection = errors.New("智能收藏夹不能手动添加、移除或排序漫画")
+)
+
+// Collection 收藏夹：手动维护顺序的漫画列表，或由保存的查询条件动态生成的智能收藏夹
+type Collection struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Smart       bool          `json:"smart"`
+	Query       *LibraryQuery `json:"query,omitempty"` // 智能收藏夹的查询条件
+	ComicCount  int           `json:"comic_count"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// CollectionInput 创建或修改收藏夹的参数，未给出的字段保持不变
+type CollectionInput struct {
+	Name        *string       `json:"name"`
+	Description *string       `json:"description"`
+	Query       *LibraryQuery `json:"query"` // 给出时创建为智能收藏夹
+}
+
+// CollectionPage 收藏夹及其中的漫画（分页）
+type CollectionPage struct {
+	Collection *Collection `json:"collection"`
+	LibraryPage
+}
+
+// validateSmartQuery 检查智能收藏夹的查询条件
+func validateSmartQuery(q *LibraryQuery) error {
+	if q.Sort != "" && !IsValidLibrarySort(q.Sort) {
+		return fmt.Errorf("%w: 无效的排序字段 %s", ErrInvalidCollection, q.Sort)
+	}
+	if q.Order != "" && q.Order != "asc" && q.Order != "desc" {
+		return fmt.Errorf("%w: 无效的排序方向 %s", ErrInvalidCollection, q.Order)
+	}
+	return nil
+}
+
+// scanCollection 读取收藏夹记录并统计漫画数量
+func (dm *DownloadManager) scanCollection(row rowScanner) (*Collection, error) {
+	var c Collection
+	var description, smartQuery sql.NullString
+	var createdAt, updatedAt int64
+	if err := row.Scan(&c.ID, &c.Name, &description, &smartQuery, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	c.Description = description.String
+	c.CreatedAt = time.Unix(createdAt, 0)
+	c.UpdatedAt = time.Unix(updatedAt, 0)
+
+	if smartQuery.Valid && smartQuery.String != "" {
+		c.Smart = true
+		c.Query = &LibraryQuery{}
+		if err := json.Unmarshal([]byte(smartQuery.String), c.Query); err != nil {
+			return nil, fmt.Errorf("解析收藏夹查询条件失败: %w", err)
+		}
+	}
+	return &c, nil
+}
+
+// countCollection 统计收藏夹中的漫画数量（智能收藏夹即时计算）
+func (dm *DownloadManager) countCollection(c *Collection) (int, error) {
+	if c.Smart {
+		return dm.CountLibrary(*c.Query)
+	}
+	var count int
+	err := dm.db.QueryRow(libraryCTE+`
+		SELECT COUNT(*) FROM collection_items
+		JOIN library ON library.id = collection_items.comic_id
+		WHERE collection_items.collection_id = ?
+	`, c.ID).Scan(&count)
+	return count, err
+}
+
+const collectionColumns = "id, name, description, smart_query, created_at, updated_at"
+
+// ListCollections 获取所有收藏夹
+func (dm *DownloadManager) ListCollections() ([]Collection, error) {
+	rows, err := dm.db.Query("SELECT " + collectionColumns + " FROM collections ORDER BY created_at, name")
+	if err != nil {
+		return nil, err
+	}
+
+	collections := make([]Collection, 0)
+	for rows.Next() {
+		c, err := dm.scanCollection(rows)
+		if err != nil {
+			continue
+		}
+		collections = append(collections, *c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// 关闭结果集后再统计数量，避免占用多个连接
+	for i := range collections {
+		if collections[i].ComicCount, err = dm.countCollection(&collections[i]); err != nil {
+			return nil, err
+		}
+	}
+	return collections, nil
+}
+
+// GetCollection 获取收藏夹
+func (dm *DownloadManager) GetCollection(id string) (*Collection, error) {
+	c, err := dm.scanCollection(dm.db.QueryRow("SELECT "+collectionColumns+" FROM collections WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrCollectionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.ComicCount, err = dm.countCollection(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CreateCollection 创建收藏夹，给出查询条件时创建智能收藏夹
+func (dm *DownloadManager) CreateCollection(input CollectionInput) (*Collection, error) {
+	if input.Name == nil || strings.TrimSpace(*input.Name) == "" {
+		return nil, fmt.Errorf("%w: 名称不能为空", ErrInvalidCollection)
+	}
+
+	var smartQuery interface{}
+	if input.Query != nil {
+		if err := validateSmartQuery(input.Query); err != nil {
+			return nil, err
+		}
+		data, _ := json.Marshal(input.Query)
+		smartQuery = string(data)
+	}
+
+	description := ""
+	if input.Description != nil {
+		description = strings.TrimSpace(*input.Description)
+	}
+
+	id := uuid.New().String()
+	now := time.Now().Unix()
+	_, err := dm.db.Exec(`
+		INSERT INTO collections (id, name, description, smart_query, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, id, strings.TrimSpace(*input.Name), description, smartQuery, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("创建收藏夹失败: %w", err)
+	}
+	return dm.GetCollection(id)
+}
+
+// UpdateCollection 重命名收藏夹或修改智能收藏夹的查询条件
+func (dm *DownloadManager) UpdateCollection(id string, input CollectionInput) (*Collection, error) {
+	c, err := dm.GetCollection(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" {
+			return nil, fmt.Errorf("%w: 名称不能为空", ErrInvalidCollection)
+		}
+		c.Name = name
+	}
+	if input.Description != nil {
+		c.Description = strings.TrimSpace(*input.Description)
+	}
+
+	var smartQuery interface{}
+	if input.Query != nil {
+		if !c.Smart {
+			return nil, fmt.Errorf("%w: 普通收藏夹不能设置查询条件", ErrInvalidCollection)
+		}
+		if err := validateSmartQuery(input.Query); err != nil {
+			return nil, err
+		}
+		c.Query = input.Query
+	}
+	if c.Smart {
+		data, _ := json.Marshal(c.Query)
+		smartQuery = string(data)
+	}
+
+	_, err = dm.db.Exec(`
+		UPDATE collections SET name = ?, description = ?, smart_query = ?, updated_at = ?
+		WHERE id = ?
+	`, c.Name, c.Description, smartQuery, time.Now().Unix(), id)
+	if err != nil {
+		return nil, fmt.Errorf("修改收藏夹失败: %w", err)
+	}
+	return dm.GetCollection(id)
+}
+
+// DeleteCollection 删除收藏夹（不会删除其中的漫画）
+func (dm *DownloadManager) DeleteCollection(id string) error {
+	result, err := dm.db.Exec("DELETE FROM collections WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrCollectionNotFound
+	}
+	_, err = dm.db.Exec("DELETE FROM collection_items WHERE collection_id = ?", id)
+	return err
+}
+
+// manualCollection 获取收藏夹并确认不是智能收藏夹
+func (dm *DownloadManager) manualCollection(id string) (*Collection, error) {
+	c, err := dm.GetCollection(id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Smart {
+		return nil, ErrSmartCollection
+	}
+	return c, nil
+}
+
+// touchCollection 更新收藏夹的修改时间
+func (dm *DownloadManager) touchCollection(db sqlExecer, id string) error {
+	_, err := db.Exec("UPDATE collections SET updated_at = ? WHERE id = ?", time.Now().Unix(), id)
+	return err
+}
+
+// AddCollectionComics 将漫画追加到收藏夹末尾，已在收藏夹中的漫画会被跳过，返回实际添加的数量
+func (dm *DownloadManager) AddCollectionComics(id string, comicIDs []string) (int, error) {
+	if _, err := dm.manualCollection(id); err != nil {
+		return 0, err
+	}
+	for _, comicID := range comicIDs {
+		if _, err := dm.GetComic(comicID); err != nil {
+			return 0, fmt.Errorf("%w: 漫画不存在 %s", ErrInvalidCollection, comicID)
+		}
+	}
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var position int
+	if err := tx.QueryRow(
+		"SELECT COALESCE(MAX(position), -1) + 1 FROM collection_items WHERE collection_id = ?", id,
+	).Scan(&position); err != nil {
+		return 0, err
+	}
+
+	added := 0
+	now := time.Now().Unix()
+	for _, comicID := range comicIDs {
+		result, err := tx.Exec(`
+			INSERT OR IGNORE INTO collection_items (collection_id, comic_id, position, added_at)
+			VALUES (?, ?, ?, ?)
+		`, id, comicID, position, now)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			position++
+			added++
+		}
+	}
+
+	if err := dm.touchCollection(tx, id); err != nil {
+		return 0, err
+	}
+	return added, tx.Commit()
+}
+
+// RemoveCollectionComic 从收藏夹中移除漫画
+func (dm *DownloadManager) RemoveCollectionComic(id, comicID string) error {
+	if _, err := dm.manualCollection(id); err != nil {
+		return err
+	}
+	result, err := dm.db.Exec("DELETE FROM collection_items WHERE collection_id = ? AND comic_id = ?", id, comicID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: 漫画不在收藏夹中", ErrInvalidCollection)
+	}
+	return dm.touchCollection(dm.db, id)
+}
+
+// ReorderCollection 调整收藏夹中漫画的顺序
+// comicIDs 中列出的漫画按给定顺序排在前面，未列出的保持原有相对顺序排在后面
+func (dm *DownloadManager) ReorderCollection(id string, comicIDs []string) error {
+	if _, err := dm.manualCollection(id); err != nil {
+		return err
+	}
+
+	rows, err := dm.db.Query("SELECT comic_id FROM collection_items WHERE collection_id = ? ORDER BY position", id)
+	if err != nil {
+		return err
+	}
+	var current []string
+	for rows.Next() {
+		var comicID string
+		if rows.Scan(&comicID) == nil {
+			current = append(current, comicID)
+		}
+	}
+	rows.Close()
+
+	inCollection := make(map[string]bool, len(current))
+	for _, comicID := range current {
+		inCollection[comicID] = true
+	}
+
+	order := make([]string, 0, len(current))
+	listed := make(map[string]bool, len(comicIDs))
+	for _, comicID := range comicIDs {
+		if !inCollection[comicID] {
+			return fmt.Errorf("%w: 漫画不在收藏夹中 %s", ErrInvalidCollection, comicID)
+		}
+		if !listed[comicID] {
+			listed[comicID] = true
+			order = append(order, comicID)
+		}
+	}
+	for _, comicID := range current {
+		if !listed[comicID] {
+			order = append(order, comicID)
+		}
+	}
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for position, comicID := range order {
+		if _, err := tx.Exec(
+			"UPDATE collection_items SET position = ? WHERE collection_id = ? AND comic_id = ?",
+			position, id, comicID,
+		); err != nil {
+			return err
+		}
+	}
+	if err := dm.touchCollection(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetCollectionComics 获取收藏夹中的漫画：普通收藏夹按手动顺序，智能收藏夹即时执行保存的查询
+// pageSize 为 0 时返回全部
+func (dm *DownloadManager) GetCollectionComics(id string, page, pageSize int) (*CollectionPage, error) {
+	c, err := dm.GetCollection(id)
+	if err != nil {
+		return nil, err
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	if c.Smart {
+		q := *c.Query
+		q.Page, q.PageSize = page, pageSize
+		result, err := dm.QueryLibrary(q)
+		if err != nil {
+			return nil, err
+		}
+		return &CollectionPage{Collection: c, LibraryPage: *result}, nil
+	}
+
+	query := libraryCTE + " SELECT " + libraryColumns + `
+		FROM collection_items
+		JOIN library ON library.id = collection_items.comic_id
+		WHERE collection_items.collection_id = ?
+		ORDER BY collection_items.position`
+	args := []interface{}{id}
+	if pageSize > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, pageSize, (page-1)*pageSize)
+	}
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询收藏夹漫画失败: %w", err)
+	}
+	defer rows.Close()
+
+	comics := make([]models.ComicDetail, 0)
+	for rows.Next() {
+		comic, err := scanComicDetail(rows)
+		if err != nil {
+			continue
+		}
+		comics = append(comics, *comic)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &CollectionPage{
+		Collection: c,
+		LibraryPage: LibraryPage{
+			Comics:   comics,
+			Total:    c.ComicCount,
+			Page:     page,
+			PageSize: pageSize,
+		},
+	}
+	if pageSize > 0 {
+		result.HasMore = page*pageSize < c.ComicCount
+	}
+	return result, nil
+}
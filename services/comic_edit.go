@@ -0,0 +1,154 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pica-comic-server/models"
+)
+
+// ErrInvalidComicUpdate 修改请求的参数无效
+var ErrInvalidComicUpdate = errors.New("无效的修改请求")
+
+// isDBComic 判断漫画是否已有 comics 表记录（扫描文件夹的漫画没有）
+func (dm *DownloadManager) isDBComic(id string) (bool, error) {
+	var count int
+	if err := dm.db.QueryRow("SELECT COUNT(*) FROM comics WHERE id = ? AND deleted_at IS NULL", id).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// flattenTagGroups 将带命名空间的标签展开为扁平列表（去重，通用标签在前）
+func flattenTagGroups(groups models.TagGroups) []string {
+	namespaces := make([]string, 0, len(groups))
+	for ns := range groups {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	seen := make(map[string]bool)
+	tags := make([]string, 0)
+	for _, ns := range namespaces {
+		for _, tag := range groups[ns] {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// cleanStrings 去掉首尾空格和空值
+func cleanStrings(list []string) []string {
+	result := make([]string, 0, len(list))
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// UpdateComic 修改漫画元数据，可选按新标题重命名存储目录
+// 扫描文件夹的漫画修改后会转为 comics 表中的正式记录（保留原ID）
+func (dm *DownloadManager) UpdateComic(id string, req models.ComicUpdateRequest) (*models.ComicDetail, error) {
+	comic, err := dm.GetComic(id)
+	if err != nil {
+		return nil, err
+	}
+
+	inDB, err := dm.isDBComic(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if title == "" {
+			return nil, fmt.Errorf("%w: 标题不能为空", ErrInvalidComicUpdate)
+		}
+		comic.Title = title
+	}
+	if req.Author != nil {
+		comic.Author = strings.TrimSpace(*req.Author)
+	}
+	if req.Description != nil {
+		comic.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Type != nil {
+		comic.Type = strings.TrimSpace(*req.Type)
+	}
+	if req.DetailURL != nil {
+		comic.DetailURL = strings.TrimSpace(*req.DetailURL)
+	}
+
+	// 标签：tag_groups 优先，其次是扁平的 tags（归入空命名空间）
+	if req.TagGroups != nil {
+		groups := make(models.TagGroups)
+		for key, list := range req.TagGroups {
+			if list = cleanStrings(list); len(list) > 0 {
+				ns := normalizeTagNamespace(key)
+				groups[ns] = append(groups[ns], list...)
+			}
+		}
+		comic.TagGroups = groups
+		comic.Tags = flattenTagGroups(groups)
+		if req.Categories == nil {
+			comic.Categories = append([]string{}, groups["categories"]...)
+		}
+	} else if req.Tags != nil {
+		tags := cleanStrings(req.Tags)
+		comic.Tags = tags
+		comic.TagGroups = models.TagGroups{"": tags}
+	}
+	if req.Categories != nil {
+		comic.Categories = cleanStrings(req.Categories)
+	}
+
+	// 重命名存储目录
+	oldDirectory := comic.Directory
+	renamed := false
+	if req.RenameFolder {
+		baseName := sanitizeFolderName(comic.Title)
+		if isArchiveFile(oldDirectory) {
+			// 压缩包漫画保留扩展名，否则重命名后不再被识别为压缩包
+			baseName += filepath.Ext(oldDirectory)
+		}
+		if baseName != comic.Directory {
+			newDirectory, err := dm.uniqueFolderName(baseName)
+			if err != nil {
+				return nil, err
+			}
+			if err := dm.renameComicDir(oldDirectory, newDirectory); err != nil {
+				return nil, fmt.Errorf("重命名目录失败: %w", err)
+			}
+			log.Printf("[修改漫画] 目录已重命名: %s -> %s", oldDirectory, newDirectory)
+			comic.Directory = newDirectory
+			renamed = true
+		}
+	}
+
+	repo := NewTaskRepository(dm.db, dm.downloadPath)
+	if err := repo.SaveComicDetail(comic); err != nil {
+		if renamed {
+			// 保存失败时还原目录名
+			dm.renameComicDir(comic.Directory, oldDirectory)
+		}
+		return nil, fmt.Errorf("保存漫画信息失败: %w", err)
+	}
+
+	if !inDB || renamed {
+		// 扫描文件夹已转为正式记录，移除旧的索引
+		if _, err := dm.db.Exec("DELETE FROM scanned_comics WHERE directory = ? OR id = ?", oldDirectory, id); err != nil {
+			log.Printf("[修改漫画] 移除扫描索引失败: %v", err)
+		}
+	}
+
+	return comic, nil
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrArchiveCover 压缩包漫画没有目录，不能写入封面文件
+var ErrArchiveCover = errors.New("压缩包漫画不支持设置封面")
+
+// coverFileNames 漫画目录中可识别的封面文件名（按优先级排列）
+var coverFileNames = []string{"cover.jpg", "cover.jpeg", "cover.png", "cover.webp", "cover.gif"}
+
+// CoverSize 封面缩略图规格
+type CoverSize struct {
+	Width  int
+	Height int
+}
+
+// coverSizes 预定义的封面缩略图尺寸（统一裁剪为 3:4）
+var coverSizes = map[string]CoverSize{
+	"small":  {Width: 180, Height: 240},
+	"medium": {Width: 360, Height: 480},
+}
+
+// IsValidCoverSize 判断缩略图规格是否有效
+func IsValidCoverSize(size string) bool {
+	_, ok := coverSizes[size]
+	return ok
+}
+
+// coverCacheDir 封面缩略图缓存目录
+func (dm *DownloadManager) coverCacheDir() string {
+	return filepath.Join(dm.downloadPath, ".cache", "covers")
+}
+
+// GetCover 获取封面
+// 优先使用漫画目录中的封面文件，不存在时回退到第一章第一页（可能位于压缩包中）
+func (dm *DownloadManager) GetCover(id string) (PageRef, error) {
+	comic, err := dm.GetComic(id)
+	if err != nil {
+		return PageRef{}, err
+	}
+
+	if dm.isStoredRemotely(comic.Directory) {
+		for _, name := range coverFileNames {
+			key := joinStorageKey(comic.Directory, name)
+			if obj, err := dm.storage.Stat(key); err == nil && obj.Size > 0 {
+				return PageRef{Path: key, store: dm.storage}, nil
+			}
+		}
+	} else {
+		comicDir := filepath.Join(dm.downloadPath, comic.Directory)
+		for _, name := range coverFileNames {
+			coverPath := filepath.Join(comicDir, name)
+			if info, err := os.Stat(coverPath); err == nil && info.Size() > 0 {
+				return PageRef{Path: coverPath}, nil
+			}
+		}
+	}
+
+	// 回退：使用第一章的第一页
+	eps := append([]int(nil), comic.DownloadedEps...)
+	sort.Ints(eps)
+	eps = append(eps, 0) // 最后尝试根目录（无章节的漫画）
+
+	for _, ep := range eps {
+		src, err := dm.episodeSourceOf(comic, ep)
+		if err != nil {
+			continue
+		}
+		if pages, err := src.pages(); err == nil && len(pages) > 0 {
+			return pages[0], nil
+		}
+	}
+
+	return PageRef{}, fmt.Errorf("封面文件不存在")
+}
+
+// GetCoverThumbnail 获取指定规格的封面缩略图
+// 缩略图按需生成并缓存，原封面更新后自动重新生成；无法解码的图片直接返回原图
+func (dm *DownloadManager) GetCoverThumbnail(id, size string) (PageRef, error) {
+	spec, ok := coverSizes[size]
+	if !ok {
+		return PageRef{}, fmt.Errorf("无效的封面尺寸: %s", size)
+	}
+
+	src, err := dm.GetCover(id)
+	if err != nil {
+		return PageRef{}, err
+	}
+	_, srcModTime, err := src.Stat()
+	if err != nil {
+		return PageRef{}, err
+	}
+
+	thumbPath := filepath.Join(dm.coverCacheDir(), fmt.Sprintf("%s_%s.jpg", id, size))
+	if info, err := os.Stat(thumbPath); err == nil && !info.ModTime().Before(srcModTime) {
+		return PageRef{Path: thumbPath}, nil
+	}
+
+	img, err := src.Decode()
+	if err != nil {
+		log.Printf("[封面] 无法解码封面，返回原图: %s, 错误: %v", src, err)
+		return src, nil
+	}
+
+	thumb := resizeImage(cropToAspect(img, 3, 4), spec.Width, spec.Height)
+	if err := writeImageFile(thumbPath, thumb, "jpeg", 85); err != nil {
+		return PageRef{}, fmt.Errorf("生成封面缩略图失败: %w", err)
+	}
+
+	return PageRef{Path: thumbPath}, nil
+}
+
+// SetCoverFromPage 将指定页面设为漫画封面
+func (dm *DownloadManager) SetCoverFromPage(id string, ep, page int) error {
+	comic, err := dm.GetComic(id)
+	if err != nil {
+		return err
+	}
+
+	comicDir := filepath.Join(dm.downloadPath, comic.Directory)
+	if info, err := os.Stat(comicDir); err == nil && !info.IsDir() {
+		return ErrArchiveCover
+	}
+
+	pageRef, err := dm.GetPage(id, ep, page)
+	if err != nil {
+		return err
+	}
+
+	src, _, err := pageRef.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	ext := strings.ToLower(filepath.Ext(pageRef.Name()))
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+
+	if dm.isStoredRemotely(comic.Directory) {
+		// 远程存储中直接写入新封面，再移除其它格式的旧封面
+		coverKey := joinStorageKey(comic.Directory, "cover"+ext)
+		if err := dm.storage.Put(coverKey, src, -1); err != nil {
+			return fmt.Errorf("保存封面失败: %w", err)
+		}
+		for _, name := range coverFileNames {
+			if key := joinStorageKey(comic.Directory, name); key != coverKey {
+				dm.storage.Delete(key)
+			}
+		}
+		dm.removeCoverThumbnails(id)
+		return nil
+	}
+
+	coverPath := filepath.Join(comicDir, "cover"+ext)
+
+	// 临时文件名唯一，并发设置封面时不会互相覆盖
+	out, err := os.CreateTemp(comicDir, "cover-*.tmp")
+	if err != nil {
+		return fmt.Errorf("创建封面文件失败: %w", err)
+	}
+	tmpPath := out.Name()
+	out.Chmod(0644)
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("写入封面文件失败: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("写入封面文件失败: %w", err)
+	}
+
+	// 移除其它格式的旧封面，保证新封面优先生效
+	for _, name := range coverFileNames {
+		if p := filepath.Join(comicDir, name); p != coverPath {
+			os.Remove(p)
+		}
+	}
+
+	if err := os.Rename(tmpPath, coverPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("保存封面失败: %w", err)
+	}
+
+	dm.removeCoverThumbnails(id)
+	return nil
+}
+
+// removeCoverThumbnails 删除漫画的所有封面缩略图缓存
+func (dm *DownloadManager) removeCoverThumbnails(id string) {
+	for size := range coverSizes {
+		os.Remove(filepath.Join(dm.coverCacheDir(), fmt.Sprintf("%s_%s.jpg", id, size)))
+	}
+}
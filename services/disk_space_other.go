@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package services
+
+// diskFreeSpace 当前系统无法读取剩余空间，不做检查
+func diskFreeSpace(path string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package services
+
+import "syscall"
+
+// diskFreeSpace 返回路径所在文件系统中当前用户可用的字节数
+func diskFreeSpace(path string) (int64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, false
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"bytes"
 	"crypto/md5"
 	"database/sql"
 	"encoding/json"
@@ -13,7 +14,6 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -39,17 +39,41 @@ type DownloadManager struct {
 	currentTask   *models.DownloadTask
 	stopChan      chan bool
 	minDiskSpace  int64
+	imageCache    *imageCache
+	indexer       *libraryIndexer
+	readActivity  *readActivityTracker
+	sessions      *sessionTracker
+	uploads       *uploadStore
+	pageStore     *pageStore
+	integrity     *integrityChecker
+
+	episodeNumbersMu sync.Mutex // 分配新的章节号时加锁，避免并发请求分配出不同的编号
+
+	storage          Storage       // 漫画文件的存储后端
+	storageRedirect  bool          // 远程存储的页面是否重定向到存储的直接访问地址
+	storageURLExpiry time.Duration // 直接访问地址的有效期
+
+	trashRetention time.Duration // 回收站保留期，0 表示不自动清理
 }
 
+// defaultImageCacheSize 缩放图片缓存的默认大小上限
+const defaultImageCacheSize = 512 * 1024 * 1024
+
 // InitDownloadManager 初始化下载管理器
 func InitDownloadManager(downloadPath string) error {
 	var initErr error
 	once.Do(func() {
 		downloadManager = &DownloadManager{
 			downloadPath: downloadPath,
+			storage:      NewLocalStorage(downloadPath),
 			queue:        make([]*models.DownloadTask, 0),
 			stopChan:     make(chan bool, 1), // 缓冲为1，避免 Pause() 阻塞
 			minDiskSpace: 200 * 1024 * 1024,
+			indexer:      &libraryIndexer{trigger: make(chan struct{}, 1)},
+			readActivity: &readActivityTracker{last: make(map[string]time.Time)},
+			sessions:     &sessionTracker{sessions: make(map[string]*inferredSession)},
+			pageStore:    &pageStore{},
+			integrity:    &integrityChecker{},
 		}
 		initErr = downloadManager.init()
 	})
@@ -61,11 +85,30 @@ func GetDownloadManager() *DownloadManager {
 	return downloadManager
 }
 
+// Shutdown 服务器退出前调用：写入内存中尚未保存的阅读记录
+func (dm *DownloadManager) Shutdown() error {
+	return dm.flushPageViews()
+}
+
 func (dm *DownloadManager) init() error {
 	if err := dm.ensureDownloadDir(); err != nil {
 		return err
 	}
 
+	// 初始化缩放图片缓存
+	imageCache, err := newImageCache(filepath.Join(dm.downloadPath, ".cache", "pages"), defaultImageCacheSize)
+	if err != nil {
+		return err
+	}
+	dm.imageCache = imageCache
+
+	// 加载可续传上传会话
+	uploads, err := newUploadStore(filepath.Join(dm.downloadPath, ".cache", "uploads"))
+	if err != nil {
+		return err
+	}
+	dm.uploads = uploads
+
 	// 打开数据库
 	dbPath := filepath.Join(dm.downloadPath, "download.db")
 	db, err := sql.Open("sqlite3", dbPath)
@@ -84,6 +127,11 @@ func (dm *DownloadManager) init() error {
 		return fmt.Errorf("数据库迁移失败: %w", err)
 	}
 
+	// 初始化全文索引
+	if err := dm.initFullText(); err != nil {
+		return fmt.Errorf("初始化全文索引失败: %w", err)
+	}
+
 	// 加载未完成的任务
 	if err := dm.loadPendingTasks(); err != nil {
 		return fmt.Errorf("加载待处理任务失败: %w", err)
@@ -94,26 +142,48 @@ func (dm *DownloadManager) init() error {
 
 // migrateTables 执行数据库迁移
 func (dm *DownloadManager) migrateTables() error {
-	// 检查 comics 表是否有 detail_url 列
+	columns := []struct {
+		table  string
+		column string
+		def    string
+	}{
+		{"comics", "detail_url", "TEXT"},
+		{"comics", "tag_groups", "TEXT"},
+		{"scanned_comics", "tag_groups", "TEXT"},
+		{"comics", "deleted_at", "INTEGER"},
+		{"scanned_comics", "scan_version", "INTEGER"},
+	}
+
+	for _, c := range columns {
+		if err := dm.ensureColumn(c.table, c.column, c.def); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureColumn 检查表中是否有指定列，不存在时添加
+func (dm *DownloadManager) ensureColumn(table, column, def string) error {
 	var columnExists bool
 	err := dm.db.QueryRow(`
 		SELECT COUNT(*) > 0 
-		FROM pragma_table_info('comics') 
-		WHERE name='detail_url'
-	`).Scan(&columnExists)
+		FROM pragma_table_info(?) 
+		WHERE name = ?
+	`, table, column).Scan(&columnExists)
 
 	if err != nil {
-		return fmt.Errorf("检查 detail_url 列失败: %w", err)
+		return fmt.Errorf("检查 %s 列失败: %w", column, err)
 	}
 
 	// 如果列不存在，添加它
 	if !columnExists {
-		log.Println("[Migration] 添加 detail_url 列到 comics 表")
-		_, err = dm.db.Exec(`ALTER TABLE comics ADD COLUMN detail_url TEXT`)
+		log.Printf("[Migration] 添加 %s 列到 %s 表", column, table)
+		_, err = dm.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
 		if err != nil {
-			return fmt.Errorf("添加 detail_url 列失败: %w", err)
+			return fmt.Errorf("添加 %s 列失败: %w", column, err)
 		}
-		log.Println("[Migration] ✓ detail_url 列添加成功")
+		log.Printf("[Migration] ✓ %s 列添加成功", column)
 	}
 
 	return nil
@@ -127,10 +197,15 @@ func (dm *DownloadManager) ensureDownloadDir() error {
 	return nil
 }
 
-// ensureDiskSpace 检查磁盘空间是否充足
+// ErrInsufficientDiskSpace 下载目录所在磁盘的剩余空间不足
+var ErrInsufficientDiskSpace = errors.New("磁盘空间不足")
+
+// ensureDiskSpace 检查磁盘空间是否充足（剩余空间不少于 minDiskSpace）
 func (dm *DownloadManager) ensureDiskSpace() error {
-	// 这里可以添加实际的磁盘空间检查逻辑
-	// 暂时只返回 nil
+	free, ok := diskFreeSpace(dm.downloadPath)
+	if ok && free < dm.minDiskSpace {
+		return fmt.Errorf("%w: 剩余 %d MB，至少需要 %d MB", ErrInsufficientDiskSpace, free>>20, dm.minDiskSpace>>20)
+	}
 	return nil
 }
 
@@ -153,7 +228,8 @@ func (dm *DownloadManager) createTables() error {
 			directory TEXT,
 			eps TEXT,
 			downloaded_eps TEXT,
-			detail_url TEXT
+			detail_url TEXT,
+			tag_groups TEXT
 		)
 	`)
 	if err != nil {
@@ -181,6 +257,170 @@ func (dm *DownloadManager) createTables() error {
 			author TEXT
 		)
 	`)
+	if err != nil {
+		return err
+	}
+
+	// 扫描文件夹索引表（列与 comics 表一致，额外记录目录签名）
+	_, err = dm.db.Exec(`
+		CREATE TABLE IF NOT EXISTS scanned_comics (
+			directory TEXT PRIMARY KEY,
+			id TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL,
+			author TEXT,
+			description TEXT,
+			cover TEXT,
+			tags TEXT,
+			categories TEXT,
+			eps_count INTEGER,
+			pages_count INTEGER,
+			type TEXT,
+			time INTEGER,
+			size INTEGER,
+			eps TEXT,
+			downloaded_eps TEXT,
+			detail_url TEXT,
+			tag_groups TEXT,
+			signature INTEGER,
+			scan_version INTEGER,
+			scanned_at INTEGER
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// 最近阅读时间表（用于按最近阅读排序）
+	_, err = dm.db.Exec(`
+		CREATE TABLE IF NOT EXISTS read_activity (
+			comic_id TEXT PRIMARY KEY,
+			last_read INTEGER
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// 阅读进度表（多设备同步，updated_at 为毫秒时间戳）
+	_, err = dm.db.Exec(`
+		CREATE TABLE IF NOT EXISTS reading_progress (
+			comic_id TEXT PRIMARY KEY,
+			ep INTEGER NOT NULL,
+			page INTEGER NOT NULL,
+			percent REAL NOT NULL DEFAULT 0,
+			device TEXT,
+			updated_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// 阅读记录表（source 为 client 时由客户端上报，inferred 时由带会话ID的页面请求推断）
+	_, err = dm.db.Exec(`
+		CREATE TABLE IF NOT EXISTS reading_sessions (
+			id TEXT PRIMARY KEY,
+			comic_id TEXT NOT NULL,
+			ep INTEGER NOT NULL,
+			pages_viewed INTEGER NOT NULL DEFAULT 0,
+			start_time INTEGER NOT NULL,
+			end_time INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			device TEXT,
+			session_id TEXT
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = dm.db.Exec("CREATE INDEX IF NOT EXISTS idx_reading_sessions_start ON reading_sessions(start_time)")
+	if err != nil {
+		return err
+	}
+
+	// 回收站表（数据库中的漫画同时在 comics 表中标记 deleted_at）
+	_, err = dm.db.Exec(`
+		CREATE TABLE IF NOT EXISTS trash (
+			id TEXT PRIMARY KEY,
+			comic_id TEXT NOT NULL,
+			title TEXT,
+			directory TEXT NOT NULL,
+			size INTEGER,
+			scanned INTEGER NOT NULL DEFAULT 0,
+			detail TEXT,
+			deleted_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// 收藏夹表（smart_query 不为空时为智能收藏夹）
+	_, err = dm.db.Exec(`
+		CREATE TABLE IF NOT EXISTS collections (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			description TEXT,
+			smart_query TEXT,
+			created_at INTEGER,
+			updated_at INTEGER
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// 收藏夹中的漫画（手动排序）
+	_, err = dm.db.Exec(`
+		CREATE TABLE IF NOT EXISTS collection_items (
+			collection_id TEXT NOT NULL,
+			comic_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			added_at INTEGER,
+			PRIMARY KEY (collection_id, comic_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// 非数字命名的章节（子目录、压缩包，名称为空表示根目录中的页面）分配的章节号，
+	// 保证增删其它章节后章节号不变；漫画移入回收站时 directory 改为回收站中的位置
+	_, err = dm.db.Exec(`
+		CREATE TABLE IF NOT EXISTS episode_numbers (
+			directory TEXT NOT NULL,
+			name TEXT NOT NULL,
+			ep INTEGER NOT NULL,
+			PRIMARY KEY (directory, name)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// 页面指纹（用于重复检测，漫画保存时写入，按哈希查找页面相同的漫画）
+	// 旧版本按目录缓存指纹，结构不同，直接删除后重新计算
+	var oldFingerprints int
+	if err := dm.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('page_fingerprints') WHERE name = 'directory'").Scan(&oldFingerprints); err != nil {
+		return err
+	}
+	if oldFingerprints > 0 {
+		log.Printf("[Migration] 重建 page_fingerprints 表")
+		if _, err := dm.db.Exec("DROP TABLE page_fingerprints"); err != nil {
+			return err
+		}
+	}
+	_, err = dm.db.Exec(`
+		CREATE TABLE IF NOT EXISTS page_fingerprints (
+			comic_id TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			PRIMARY KEY (comic_id, hash)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = dm.db.Exec("CREATE INDEX IF NOT EXISTS idx_page_fingerprints_hash ON page_fingerprints(hash)")
 	return err
 }
 
@@ -395,13 +635,18 @@ func (dm *DownloadManager) processQueue() {
 func (dm *DownloadManager) downloadTask(task *models.DownloadTask) error {
 	// 🆕 所有下载都使用直接下载模式（客户端拦截 URL）
 	var extraCheck struct {
-		DirectMode bool `json:"direct_mode"`
+		DirectMode bool          `json:"direct_mode"`
+		Repair     *repairTarget `json:"repair"`
 	}
 	json.Unmarshal([]byte(task.Extra), &extraCheck)
 
 	if !extraCheck.DirectMode {
 		return fmt.Errorf("仅支持直接下载模式，请使用客户端拦截 URL 后发送到服务器")
 	}
+	if extraCheck.Repair != nil {
+		fmt.Printf("[任务调度] 重新下载损坏的页面\n")
+		return dm.downloadRepairPages(task)
+	}
 
 	fmt.Printf("[任务调度] 使用直接下载模式\n")
 	return dm.downloadDirectComic(task)
@@ -519,28 +764,38 @@ func (dm *DownloadManager) downloadFileOnce(url, filePath string, headers map[st
 		return err
 	}
 
-	file, err := os.Create(filePath)
+	// 写入临时文件后替换，不修改原文件：去重后的页面是多部漫画共享的硬链接
+	file, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	_, err = io.Copy(file, resp.Body)
-	return err
+	tmpPath := file.Name()
+	file.Chmod(0644)
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
 }
 
-// parseTags 解析标签 JSON，返回所有标签和分类
-func parseTags(tagsJSON string) (allTags []string, categories []string) {
+// parseTags 解析标签 JSON，返回所有标签、分类以及按命名空间分组的标签
+func parseTags(tagsJSON string) (allTags []string, categories []string, groups models.TagGroups) {
 	if tagsJSON == "" {
-		return nil, nil
+		return nil, nil, nil
 	}
 	var tagsMap map[string][]string
 	if err := json.Unmarshal([]byte(tagsJSON), &tagsMap); err != nil {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	allTags = make([]string, 0)
 	categories = make([]string, 0)
+	groups = make(models.TagGroups)
 
 	for key, list := range tagsMap {
 		// 提取分类（category/categories键）
@@ -549,9 +804,25 @@ func parseTags(tagsJSON string) (allTags []string, categories []string) {
 		}
 		// 所有标签都加入到allTags
 		allTags = append(allTags, list...)
+
+		// 保留命名空间（如 EHentai 的 female、artist、parody）
+		namespace := normalizeTagNamespace(key)
+		groups[namespace] = append(groups[namespace], list...)
 	}
 
-	return allTags, categories
+	return allTags, categories, groups
+}
+
+// normalizeTagNamespace 统一命名空间写法，通用标签键（tags、tag）归入空命名空间
+func normalizeTagNamespace(key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	switch key {
+	case "tag", "tags":
+		return ""
+	case "category":
+		return "categories"
+	}
+	return key
 }
 
 // updateTaskStatus 更新任务状态
@@ -572,241 +843,133 @@ func generateComicID(folderName string) string {
 	return fmt.Sprintf("scanned_%x", hash[:8]) // 使用前8字节，足够唯一
 }
 
-// GetAllComics 获取所有已下载的漫画（包括扫描文件夹）
-func (dm *DownloadManager) GetAllComics() ([]models.ComicDetail, error) {
-	// 1. 从数据库读取已记录的漫画
-	comicMap := make(map[string]*models.ComicDetail)
-
-	rows, err := dm.db.Query(`
-		SELECT id, title, author, description, cover, tags, categories,
-		       eps_count, pages_count, type, time, size, directory, eps, downloaded_eps, detail_url
-		FROM comics
-		ORDER BY time DESC
-	`)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var comic models.ComicDetail
-			var tagsJSON, categoriesJSON, epsJSON, downloadedEpsJSON string
-			var timeUnix int64
-
-			err := rows.Scan(
-				&comic.ID, &comic.Title, &comic.Author, &comic.Description,
-				&comic.Cover, &tagsJSON, &categoriesJSON, &comic.EpsCount,
-				&comic.PagesCount, &comic.Type, &timeUnix, &comic.Size,
-				&comic.Directory, &epsJSON, &downloadedEpsJSON, &comic.DetailURL,
-			)
-			if err == nil {
-				// 确保即使JSON为空也初始化为空数组
-				if tagsJSON != "" {
-					json.Unmarshal([]byte(tagsJSON), &comic.Tags)
-				}
-				if comic.Tags == nil {
-					comic.Tags = []string{}
-				}
-
-				if categoriesJSON != "" {
-					json.Unmarshal([]byte(categoriesJSON), &comic.Categories)
-				}
-				if comic.Categories == nil {
-					comic.Categories = []string{}
-				}
-
-				if epsJSON != "" {
-					json.Unmarshal([]byte(epsJSON), &comic.Eps)
-				}
-				if comic.Eps == nil {
-					comic.Eps = []string{}
-				}
-
-				if downloadedEpsJSON != "" {
-					json.Unmarshal([]byte(downloadedEpsJSON), &comic.DownloadedEps)
-				}
-				if comic.DownloadedEps == nil {
-					comic.DownloadedEps = []int{}
-				}
-
-				comic.Time = time.Unix(timeUnix, 0)
-				comicMap[comic.Directory] = &comic
-			}
-		}
-	}
-
-	// 2. 扫描下载目录中的所有文件夹
-	entries, err := os.ReadDir(dm.downloadPath)
-	if err != nil {
-		// 如果扫描失败，返回数据库中的漫画
-		var comics []models.ComicDetail
-		for _, comic := range comicMap {
-			comics = append(comics, *comic)
-		}
-		return comics, nil
-	}
-
-	dirSet := make(map[string]bool)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			dirName := entry.Name()
-			dirSet[dirName] = true
-
-			// 如果数据库中没有此文件夹的记录，创建一个基本记录
-			if _, exists := comicMap[dirName]; !exists {
-				info, _ := entry.Info()
-				modTime := time.Now()
-				if info != nil {
-					modTime = info.ModTime()
-				}
-
-				// 扫描章节
-				eps, downloadedEps := dm.scanComicFolder(filepath.Join(dm.downloadPath, dirName))
-
-				// 为扫描的漫画生成稳定的ID
-				scannedID := generateComicID(dirName)
-
-				// 计算文件夹大小
-				folderPath := filepath.Join(dm.downloadPath, dirName)
-				folderSize := calculateFolderSize(folderPath)
-
-				comicMap[dirName] = &models.ComicDetail{
-					Comic: models.Comic{
-						ID:          scannedID, // 使用哈希生成的稳定ID
-						Title:       dirName,
-						Author:      "未知",
-						Description: "从文件夹扫描的漫画",
-						Tags:        []string{},
-						Categories:  []string{},
-						Type:        "server", // 服务器漫画
-						EpsCount:    len(eps),
-						PagesCount:  0,          // 页数在阅读时动态获取
-						Size:        folderSize, // 计算的实际大小（字节）
-						Time:        modTime,
-					},
-					Directory:     dirName,
-					Eps:           eps,
-					DownloadedEps: downloadedEps,
-				}
-			}
-		}
-	}
-
-	// 3. 转换为切片并返回
-	var comics []models.ComicDetail
-	for _, comic := range comicMap {
-		comics = append(comics, *comic)
-	}
-
-	return comics, nil
-}
-
-// scanComicFolder 扫描漫画文件夹，获取章节信息
-func (dm *DownloadManager) scanComicFolder(folderPath string) ([]string, []int) {
-	var eps []string
-	var downloadedEps []int
-
-	entries, err := os.ReadDir(folderPath)
-	if err != nil {
-		return eps, downloadedEps
-	}
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			// 尝试将文件夹名转换为数字（章节序号）
-			if epNum, err := strconv.Atoi(entry.Name()); err == nil {
-				eps = append(eps, fmt.Sprintf("第 %d 话", epNum))
-				downloadedEps = append(downloadedEps, epNum)
-			}
-		}
-	}
+// comicColumns comics 表与 scanned_comics 表共有的查询列
+const comicColumns = `id, title, author, description, cover, tags, categories,
+		       eps_count, pages_count, type, time, size, directory, eps, downloaded_eps, detail_url, tag_groups`
 
-	// 排序
-	sort.Ints(downloadedEps)
-
-	return eps, downloadedEps
+// rowScanner 兼容 *sql.Row 和 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-// GetComic 获取漫画详情
-func (dm *DownloadManager) GetComic(id string) (*models.ComicDetail, error) {
+// scanComicDetail 从查询结果中读取一条漫画记录
+func scanComicDetail(row rowScanner) (*models.ComicDetail, error) {
 	var comic models.ComicDetail
-	var tagsJSON, categoriesJSON, epsJSON, downloadedEpsJSON string
-	var timeUnix int64
-
-	err := dm.db.QueryRow(`
-		SELECT id, title, author, description, cover, tags, categories,
-		       eps_count, pages_count, type, time, size, directory, eps, downloaded_eps, detail_url
-		FROM comics WHERE id = ?
-	`, id).Scan(
-		&comic.ID, &comic.Title, &comic.Author, &comic.Description,
-		&comic.Cover, &tagsJSON, &categoriesJSON, &comic.EpsCount,
-		&comic.PagesCount, &comic.Type, &timeUnix, &comic.Size,
-		&comic.Directory, &epsJSON, &downloadedEpsJSON, &comic.DetailURL,
+	var author, description, cover, comicType, directory, detailURL sql.NullString
+	var tagsJSON, categoriesJSON, epsJSON, downloadedEpsJSON, tagGroupsJSON sql.NullString
+	var epsCount, pagesCount, timeUnix, size sql.NullInt64
+
+	err := row.Scan(
+		&comic.ID, &comic.Title, &author, &description,
+		&cover, &tagsJSON, &categoriesJSON, &epsCount,
+		&pagesCount, &comicType, &timeUnix, &size,
+		&directory, &epsJSON, &downloadedEpsJSON, &detailURL,
+		&tagGroupsJSON,
 	)
-
 	if err != nil {
-		// 如果数据库中找不到，尝试从文件系统扫描
-		if err == sql.ErrNoRows {
-			// 获取所有漫画（包括扫描的）
-			allComics, scanErr := dm.GetAllComics()
-			if scanErr != nil {
-				return nil, err // 返回原始错误
-			}
-
-			// 查找匹配的漫画
-			for _, c := range allComics {
-				if c.ID == id {
-					return &c, nil
-				}
-			}
-		}
 		return nil, err
 	}
 
+	comic.Author = author.String
+	comic.Description = description.String
+	comic.Cover = cover.String
+	comic.Type = comicType.String
+	comic.Directory = directory.String
+	comic.DetailURL = detailURL.String
+	comic.EpsCount = int(epsCount.Int64)
+	comic.PagesCount = int(pagesCount.Int64)
+	comic.Size = size.Int64
+	comic.Time = time.Unix(timeUnix.Int64, 0)
+
 	// 确保即使JSON为空也初始化为空数组
-	if tagsJSON != "" {
-		json.Unmarshal([]byte(tagsJSON), &comic.Tags)
+	if tagsJSON.String != "" {
+		json.Unmarshal([]byte(tagsJSON.String), &comic.Tags)
 	}
 	if comic.Tags == nil {
 		comic.Tags = []string{}
 	}
 
-	if categoriesJSON != "" {
-		json.Unmarshal([]byte(categoriesJSON), &comic.Categories)
+	if categoriesJSON.String != "" {
+		json.Unmarshal([]byte(categoriesJSON.String), &comic.Categories)
 	}
 	if comic.Categories == nil {
 		comic.Categories = []string{}
 	}
 
-	if epsJSON != "" {
-		json.Unmarshal([]byte(epsJSON), &comic.Eps)
+	if tagGroupsJSON.String != "" {
+		json.Unmarshal([]byte(tagGroupsJSON.String), &comic.TagGroups)
+	}
+	if comic.TagGroups == nil {
+		comic.TagGroups = models.TagGroups{}
+	}
+
+	if epsJSON.String != "" {
+		json.Unmarshal([]byte(epsJSON.String), &comic.Eps)
 	}
 	if comic.Eps == nil {
 		comic.Eps = []string{}
 	}
 
-	if downloadedEpsJSON != "" {
-		json.Unmarshal([]byte(downloadedEpsJSON), &comic.DownloadedEps)
+	if downloadedEpsJSON.String != "" {
+		json.Unmarshal([]byte(downloadedEpsJSON.String), &comic.DownloadedEps)
 	}
 	if comic.DownloadedEps == nil {
 		comic.DownloadedEps = []int{}
 	}
 
-	comic.Time = time.Unix(timeUnix, 0)
-
 	return &comic, nil
 }
 
-// GetCoverPath 获取封面路径
-func (dm *DownloadManager) GetCoverPath(id string) (string, error) {
-	comic, err := dm.GetComic(id)
+// GetAllComics 获取所有已下载的漫画（包括扫描文件夹）
+// 扫描的文件夹由后台索引器维护在 scanned_comics 表中，这里只读取数据库
+func (dm *DownloadManager) GetAllComics() ([]models.ComicDetail, error) {
+	rows, err := dm.db.Query(libraryCTE + `
+		SELECT ` + comicColumns + ` FROM library
+		ORDER BY time DESC
+	`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer rows.Close()
 
-	coverPath := filepath.Join(dm.downloadPath, comic.Directory, "cover.jpg")
-	if _, err := os.Stat(coverPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("封面文件不存在")
+	comics := make([]models.ComicDetail, 0)
+	for rows.Next() {
+		comic, err := scanComicDetail(rows)
+		if err != nil {
+			continue
+		}
+		comics = append(comics, *comic)
+	}
+
+	return comics, rows.Err()
+}
+
+// scanComicFolder 扫描漫画文件夹，获取章节信息（章节目录和作为章节的压缩包）
+func (dm *DownloadManager) scanComicFolder(sources []episodeSource) ([]string, []int) {
+	eps := make([]string, 0)
+	downloadedEps := make([]int, 0)
+	for _, src := range sources {
+		eps = append(eps, src.title)
+		downloadedEps = append(downloadedEps, src.ep)
 	}
+	return eps, downloadedEps
+}
 
-	return coverPath, nil
+// GetComic 获取漫画详情
+func (dm *DownloadManager) GetComic(id string) (*models.ComicDetail, error) {
+	comic, err := scanComicDetail(dm.db.QueryRow(`
+		SELECT `+comicColumns+` FROM comics WHERE id = ? AND deleted_at IS NULL
+	`, id))
+	if err == sql.ErrNoRows {
+		// 数据库中找不到时，查询扫描文件夹的索引
+		comic, err = scanComicDetail(dm.db.QueryRow(`
+			SELECT `+comicColumns+` FROM scanned_comics WHERE id = ?
+		`, id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return comic, nil
 }
 
 // GetEpisodePageCount 获取章节的页面数量
@@ -816,100 +979,123 @@ func (dm *DownloadManager) GetEpisodePageCount(id string, ep int) (int, error) {
 		return 0, err
 	}
 
-	var imagePath string
-	if ep == 0 {
-		// 没有章节的漫画
-		imagePath = filepath.Join(dm.downloadPath, comic.Directory)
-	} else {
-		// 有章节的漫画
-		imagePath = filepath.Join(dm.downloadPath, comic.Directory, fmt.Sprintf("%d", ep))
-	}
-
-	// 读取目录中的图片文件
-	files, err := os.ReadDir(imagePath)
+	src, err := dm.episodeSourceOf(comic, ep)
 	if err != nil {
 		return 0, err
 	}
-
-	// 统计图片文件数量（排除 cover.jpg）
-	count := 0
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		name := file.Name()
-		// 排除封面和非图片文件
-		if name == "cover.jpg" || name == "cover.png" {
-			continue
-		}
-		ext := strings.ToLower(filepath.Ext(name))
-		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".webp" {
-			count++
+	if src.store == nil {
+		// 远程存储中的章节路径是存储键，不能按本地文件检查
+		if _, err := os.Stat(src.path); err != nil {
+			return 0, err
 		}
 	}
 
-	return count, nil
+	// 统计图片文件数量（排除封面和非图片文件）
+	return src.pageCount(), nil
+}
+
+// DeleteComic 删除漫画：移入回收站，保留期内可以恢复
+func (dm *DownloadManager) DeleteComic(id string) error {
+	_, err := dm.TrashComic(id)
+	return err
 }
 
-// GetImagePath 获取图片路径
-func (dm *DownloadManager) GetImagePath(id string, ep int, page int) (string, error) {
+// ErrEpisodeNotFound 章节不存在或未下载
+var ErrEpisodeNotFound = errors.New("章节不存在")
+
+// ErrPageNotFound 章节中没有该页
+var ErrPageNotFound = errors.New("页面不存在")
+
+// ErrRootArchiveEpisode 压缩包漫画只有一个章节，不能单独删除
+var ErrRootArchiveEpisode = errors.New("压缩包漫画只有一个章节，请删除整部漫画")
+
+// DeleteEpisode 删除漫画的单个章节，并更新已下载章节、页数和大小
+func (dm *DownloadManager) DeleteEpisode(id string, ep int) (*models.ComicDetail, error) {
 	comic, err := dm.GetComic(id)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var imagePath string
-	if ep == 0 {
-		// 没有章节的漫画
-		imagePath = filepath.Join(dm.downloadPath, comic.Directory)
-	} else {
-		// 有章节的漫画
-		imagePath = filepath.Join(dm.downloadPath, comic.Directory, fmt.Sprintf("%d", ep))
+	found := false
+	for _, e := range comic.DownloadedEps {
+		if e == ep {
+			found = true
+			break
+		}
 	}
-
-	// 查找匹配的文件
-	files, err := os.ReadDir(imagePath)
-	if err != nil {
-		return "", err
+	comicPath := filepath.Join(dm.downloadPath, comic.Directory)
+	src, err := dm.episodeSourceOf(comic, ep)
+	if !found || ep <= 0 || err != nil {
+		return nil, ErrEpisodeNotFound
 	}
-
-	// 尝试多种页面编号格式
-	pageFormats := []string{
-		fmt.Sprintf("%03d", page), // 001, 002, ...
-		fmt.Sprintf("%d", page),   // 1, 2, ...
-		fmt.Sprintf("%02d", page), // 01, 02, ...
+	if src.store == nil {
+		if _, err := os.Stat(src.path); err != nil {
+			return nil, ErrEpisodeNotFound
+		}
 	}
 
-	for _, file := range files {
-		name := file.Name()
-		nameWithoutExt := strings.TrimSuffix(name, filepath.Ext(name))
-
-		for _, pageStr := range pageFormats {
-			if nameWithoutExt == pageStr {
-				return filepath.Join(imagePath, name), nil
+	if src.root {
+		// 根目录中的页面只删除图片文件，保留封面、元数据和其它章节
+		if src.archive {
+			return nil, ErrRootArchiveEpisode
+		}
+		pages, err := src.pages()
+		if err != nil {
+			return nil, fmt.Errorf("删除章节失败: %w", err)
+		}
+		for _, page := range pages {
+			if err := page.remove(); err != nil {
+				return nil, fmt.Errorf("删除章节失败: %w", err)
 			}
 		}
+		if src.store != nil {
+			pageIndex.forget(src.store, src.path)
+		}
+	} else if err := src.removeAll(); err != nil {
+		return nil, fmt.Errorf("删除章节失败: %w", err)
 	}
+	log.Printf("[删除章节] %s 第 %d 章已删除", comic.Title, ep)
+	dm.collectPageObjects()
 
-	return "", fmt.Errorf("图片文件不存在: page=%d", page)
-}
+	// 封面可能取自被删除章节的第一页
+	dm.removeCoverThumbnails(id)
 
-// DeleteComic 删除漫画
-func (dm *DownloadManager) DeleteComic(id string) error {
-	comic, err := dm.GetComic(id)
+	inDB, err := dm.isDBComic(id)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// 删除文件
-	comicPath := filepath.Join(dm.downloadPath, comic.Directory)
-	if err := os.RemoveAll(comicPath); err != nil {
-		return err
+	if !inDB {
+		// 扫描的漫画直接重新索引文件夹
+		if err := dm.indexScannedFolder(comic.Directory, folderSignature(comicPath)); err != nil {
+			return nil, err
+		}
+		return dm.GetComic(id)
 	}
 
-	// 从数据库删除
-	_, err = dm.db.Exec("DELETE FROM comics WHERE id = ?", id)
-	return err
+	// 非数字命名的章节使用记录的章节号，删除章节后其它章节的编号不变；
+	// 已下载章节中找不到来源的编号一并移除
+	sources := make(map[int]episodeSource)
+	for _, src := range dm.comicSources(comic.Directory) {
+		sources[src.ep] = src
+	}
+	remaining := make([]int, 0, len(comic.DownloadedEps))
+	pagesCount := 0
+	for _, e := range comic.DownloadedEps {
+		src, ok := sources[e]
+		if e == ep || !ok {
+			continue
+		}
+		remaining = append(remaining, e)
+		pagesCount += src.pageCount()
+	}
+	downloadedEpsJSON, _ := json.Marshal(remaining)
+	if _, err := dm.db.Exec(`
+		UPDATE comics SET downloaded_eps = ?, pages_count = ?, size = ? WHERE id = ?
+	`, string(downloadedEpsJSON), pagesCount, dm.comicSize(comic.Directory), id); err != nil {
+		return nil, fmt.Errorf("更新漫画信息失败: %w", err)
+	}
+	dm.updatePageFingerprints(id, comic.Directory)
+	return dm.GetComic(id)
 }
 
 // downloadFile 下载文件
@@ -956,12 +1142,13 @@ func (repo *TaskRepository) SaveComicDetail(comic *models.ComicDetail) error {
 	categoriesJSON, _ := json.Marshal(comic.Categories)
 	epsJSON, _ := json.Marshal(comic.Eps)
 	downloadedEpsJSON, _ := json.Marshal(comic.DownloadedEps)
+	tagGroupsJSON, _ := json.Marshal(comic.TagGroups)
 
 	_, err := repo.db.Exec(`
 		INSERT INTO comics (
 			id, title, author, description, cover, tags, categories,
-			eps_count, pages_count, type, time, size, directory, eps, downloaded_eps, detail_url
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			eps_count, pages_count, type, time, size, directory, eps, downloaded_eps, detail_url, tag_groups
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			title = excluded.title,
 			author = excluded.author,
@@ -977,7 +1164,9 @@ func (repo *TaskRepository) SaveComicDetail(comic *models.ComicDetail) error {
 			detail_url = excluded.detail_url,
 			directory = excluded.directory,
 			eps = excluded.eps,
-			downloaded_eps = excluded.downloaded_eps
+			downloaded_eps = excluded.downloaded_eps,
+			tag_groups = excluded.tag_groups,
+			deleted_at = NULL
 	`,
 		comic.ID,
 		comic.Title,
@@ -995,64 +1184,49 @@ func (repo *TaskRepository) SaveComicDetail(comic *models.ComicDetail) error {
 		string(epsJSON),
 		string(downloadedEpsJSON),
 		comic.DetailURL,
+		string(tagGroupsJSON),
 	)
-	return err
-}
-
-func (repo *TaskRepository) SaveFile(path string, reader io.Reader) error {
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return err
-	}
-
-	file, err := os.Create(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, reader)
-	return err
+	syncComicFTS(repo.db, comic)
+	return nil
 }
 
-func (repo *TaskRepository) SaveBytes(path string, data []byte) error {
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+// SaveFile 保存下载的文件。下载、导入过程中的文件先写入下载目录（暂存），
+// 完成后由 storeComic 移入配置的存储后端；path 可以是下载目录中的绝对路径或相对路径
+func (repo *TaskRepository) SaveFile(path string, reader io.Reader) error {
+	key, err := repo.stagingKey(path)
+	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0644)
+	return NewLocalStorage(repo.downloadPath).Put(key, reader, -1)
 }
 
-func (repo *TaskRepository) AppendDownloadedEp(comicID string, ep int) error {
-	var downloaded string
-	err := repo.db.QueryRow("SELECT downloaded_eps FROM comics WHERE id = ?", comicID).Scan(&downloaded)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return err
-	}
-
-	var eps []int
-	if downloaded != "" {
-		if err := json.Unmarshal([]byte(downloaded), &eps); err != nil {
-			return err
-		}
-	}
+func (repo *TaskRepository) SaveBytes(path string, data []byte) error {
+	return repo.SaveFile(path, bytes.NewReader(data))
+}
 
-	for _, existing := range eps {
-		if existing == ep {
-			return nil
+// stagingKey 下载目录中的路径对应的存储键
+func (repo *TaskRepository) stagingKey(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(repo.downloadPath, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return "", fmt.Errorf("文件不在下载目录中: %s", path)
 		}
+		path = rel
 	}
-
-	eps = append(eps, ep)
-	data, _ := json.Marshal(eps)
-	_, err = repo.db.Exec("UPDATE comics SET downloaded_eps = ? WHERE id = ?", string(data), comicID)
-	return err
+	return filepath.ToSlash(path), nil
 }
 
 // SubmitDirectDownload 提交直接下载任务（方案2：客户端已获取URL）
-func (dm *DownloadManager) SubmitDirectDownload(reqData interface{}) (string, error) {
+// 漫画库中已有相同漫画时返回重复检测结果：策略为 skip 时不创建任务，其它策略在下载完成后处理
+func (dm *DownloadManager) SubmitDirectDownload(reqData interface{}) (string, *DuplicateReport, error) {
 	// 因为不能直接导入 handlers 包（会循环依赖），所以用反射处理
 	data, err := json.Marshal(reqData)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	var req struct {
@@ -1070,10 +1244,15 @@ func (dm *DownloadManager) SubmitDirectDownload(reqData interface{}) (string, er
 			PageURLs []string          `json:"page_urls"`
 			Headers  map[string]string `json:"headers"` // 客户端提供的 HTTP headers
 		} `json:"episodes"`
+		OnDuplicate string `json:"on_duplicate"`
 	}
 
 	if err := json.Unmarshal(data, &req); err != nil {
-		return "", err
+		return "", nil, err
+	}
+	policy, err := ParseDuplicatePolicy(req.OnDuplicate)
+	if err != nil {
+		return "", nil, err
 	}
 
 	dm.mu.Lock()
@@ -1085,7 +1264,7 @@ func (dm *DownloadManager) SubmitDirectDownload(reqData interface{}) (string, er
 			(existingTask.Status == "pending" || existingTask.Status == "downloading" || existingTask.Status == "paused") {
 			log.Printf("[DownloadManager] 漫画 %s 已在下载队列中，任务ID: %s，状态: %s",
 				req.ComicID, existingTask.ID, existingTask.Status)
-			return existingTask.ID, nil
+			return existingTask.ID, nil, nil
 		}
 	}
 
@@ -1100,7 +1279,24 @@ func (dm *DownloadManager) SubmitDirectDownload(reqData interface{}) (string, er
 	if err == nil {
 		// 找到了现有任务
 		log.Printf("[DownloadManager] 数据库中已有漫画 %s 的下载任务: %s", req.ComicID, existingTaskID)
-		return existingTaskID, nil
+		return existingTaskID, nil, nil
+	}
+
+	// 按来源ID和标题检查漫画库中是否已有该漫画（页面内容在下载完成后再比较）
+	matches, err := dm.findDuplicates(&models.ComicDetail{
+		Comic: models.Comic{ID: req.ComicID, Title: req.Title, Author: req.Author},
+	}, nil, "")
+	if err != nil {
+		return "", nil, err
+	}
+	var report *DuplicateReport
+	if len(matches) > 0 {
+		report = &DuplicateReport{Policy: policy, Action: DuplicateActionPending, Matches: matches}
+		if policy == DuplicateSkip {
+			report.Action, report.ComicID, report.Directory = DuplicateActionSkipped, matches[0].ComicID, matches[0].Directory
+			log.Printf("[DownloadManager] 漫画 %s 已存在（%s），跳过下载", req.ComicID, matches[0].Title)
+			return "", report, nil
+		}
 	}
 
 	// 创建任务
@@ -1134,9 +1330,10 @@ func (dm *DownloadManager) SubmitDirectDownload(reqData interface{}) (string, er
 
 	// 将 episodes 数据和 detail_url 存入 Extra
 	extraData := map[string]interface{}{
-		"direct_mode": true,
-		"episodes":    req.Episodes,
-		"detail_url":  req.DetailURL,
+		"direct_mode":  true,
+		"episodes":     req.Episodes,
+		"detail_url":   req.DetailURL,
+		"on_duplicate": policy,
 	}
 	extraJSON, _ := json.Marshal(extraData)
 	task.Extra = string(extraJSON)
@@ -1155,7 +1352,7 @@ func (dm *DownloadManager) SubmitDirectDownload(reqData interface{}) (string, er
 		task.CreatedAt.Unix(), task.UpdatedAt.Unix())
 
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	log.Printf("[DownloadManager] 创建新下载任务: %s, 漫画ID: %s, 标题: %s", taskID, req.ComicID, title)
@@ -1165,7 +1362,7 @@ func (dm *DownloadManager) SubmitDirectDownload(reqData interface{}) (string, er
 
 	go dm.processQueue()
 
-	return taskID, nil
+	return taskID, report, nil
 }
 
 // sanitizeFolderName 将字符串转换为安全的文件夹名称
@@ -1203,6 +1400,31 @@ func sanitizeFolderName(name string) string {
 	return safe
 }
 
+// uniqueFolderName 返回下载目录中尚不存在的文件夹名，已存在时添加数字后缀
+func (dm *DownloadManager) uniqueFolderName(baseFolderName string) (string, error) {
+	folderName := baseFolderName
+	// 压缩包漫画的后缀加在扩展名之前
+	stem, ext := baseFolderName, ""
+	if isArchiveFile(baseFolderName) {
+		ext = filepath.Ext(baseFolderName)
+		stem = strings.TrimSuffix(baseFolderName, ext)
+	}
+	counter := 1
+	for {
+		exists, err := dm.comicExists(folderName)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			// 文件夹不存在（使用远程存储时也不在存储中），可以使用
+			return folderName, nil
+		}
+		// 文件夹已存在，尝试下一个名字
+		counter++
+		folderName = fmt.Sprintf("%s_%d%s", stem, counter, ext)
+	}
+}
+
 // extractBookIdFromUrl 从 JM 图片 URL 中提取 bookId
 // 与客户端逻辑一致：
 // 1. url.substring(i + 1, url.length - 5)
@@ -1237,19 +1459,58 @@ func extractBookIdFromUrl(url string) string {
 }
 
 // downloadDirectComic 直接下载模式（客户端已获取URL）
+// directEpisode 直接下载任务中的一个章节（保存在任务的 extra 字段中）
+type directEpisode struct {
+	Order                int                 `json:"order"`
+	Name                 string              `json:"name"`
+	PageURLs             []string            `json:"page_urls"`
+	Headers              map[string]string   `json:"headers"`                // 客户端提供的 HTTP headers
+	DescrambleParams     map[string]string   `json:"descramble_params"`      // 全局反混淆参数（可选）
+	PageDescrambleParams []map[string]string `json:"page_descramble_params"` // 每个图片的反混淆参数（可选）
+}
+
+// descramble 有反混淆参数时，对章节中第 index 张图片（从0开始）进行反混淆处理（失败只记录日志）
+func (ep directEpisode) descramble(index int, filePath string) {
+	if len(ep.DescrambleParams) == 0 {
+		return
+	}
+	pageURL := ep.PageURLs[index]
+	epsId := ep.DescrambleParams["epsId"]
+	scrambleId := ep.DescrambleParams["scrambleId"]
+
+	// 使用客户端传来的 bookId（如果有）
+	var bookId string
+	if ep.PageDescrambleParams != nil && index < len(ep.PageDescrambleParams) {
+		bookId = ep.PageDescrambleParams[index]["bookId"]
+		fmt.Printf("[反混淆] 图片 %d/%d - URL: %s\n", index+1, len(ep.PageURLs), pageURL)
+		fmt.Printf("[反混淆] 使用客户端提供的 bookId: '%s'\n", bookId)
+	} else {
+		// 回退：从 URL 中提取 bookId
+		bookId = extractBookIdFromUrl(pageURL)
+		fmt.Printf("[反混淆] 回退：从URL提取 bookId: '%s'\n", bookId)
+	}
+
+	fmt.Printf("[反混淆] 参数: epsId=%s, scrambleId=%s, bookId=%s, 文件=%s\n", epsId, scrambleId, bookId, filePath)
+	if err := DescrambleJmImage(filePath, epsId, scrambleId, bookId); err != nil {
+		fmt.Printf("[❌ 错误] 图片 %d 反混淆失败: %v\n", index+1, err)
+		// 不中断下载，继续处理其他图片
+	} else {
+		fmt.Printf("[✅ 成功] 图片 %d 反混淆完成\n", index+1)
+	}
+}
+
+// directPageName 直接下载时第 index 张图片（从0开始）保存的文件名
+func directPageName(index int) string {
+	return fmt.Sprintf("%03d.jpg", index+1)
+}
+
 func (dm *DownloadManager) downloadDirectComic(task *models.DownloadTask) error {
 	// 解析 Extra 中的 episodes 数据和 detail_url
 	var extra struct {
-		DirectMode bool   `json:"direct_mode"`
-		DetailURL  string `json:"detail_url"` // 详情页链接
-		Episodes   []struct {
-			Order                int                 `json:"order"`
-			Name                 string              `json:"name"`
-			PageURLs             []string            `json:"page_urls"`
-			Headers              map[string]string   `json:"headers"`                // 客户端提供的 HTTP headers
-			DescrambleParams     map[string]string   `json:"descramble_params"`      // 全局反混淆参数（可选）
-			PageDescrambleParams []map[string]string `json:"page_descramble_params"` // 每个图片的反混淆参数（可选）
-		} `json:"episodes"`
+		DirectMode  bool            `json:"direct_mode"`
+		DetailURL   string          `json:"detail_url"` // 详情页链接
+		Episodes    []directEpisode `json:"episodes"`
+		OnDuplicate string          `json:"on_duplicate"` // 与已有漫画重复时的处理策略
 	}
 
 	if err := json.Unmarshal([]byte(task.Extra), &extra); err != nil {
@@ -1260,23 +1521,52 @@ func (dm *DownloadManager) downloadDirectComic(task *models.DownloadTask) error
 		return fmt.Errorf("不是直接下载模式")
 	}
 
-	repo := NewTaskRepository(dm.db, dm.downloadPath)
-
-	// 使用漫画标题作为文件夹名（安全化处理）
-	baseFolderName := sanitizeFolderName(task.Title)
-	folderName := baseFolderName
+	policy, err := ParseDuplicatePolicy(extra.OnDuplicate)
+	if err != nil {
+		return err
+	}
 
-	// 检查文件夹是否存在，如果存在则添加数字后缀
-	counter := 1
-	for {
-		testPath := filepath.Join(dm.downloadPath, folderName)
-		if _, err := os.Stat(testPath); os.IsNotExist(err) {
-			// 文件夹不存在，可以使用
-			break
+	// 提交任务后漫画库可能已有变化，下载前再检查一次：跳过时不下载，合并时只下载缺少的章节
+	if policy == DuplicateSkip || policy == DuplicateMerge {
+		matches, err := dm.findDuplicates(&models.ComicDetail{
+			Comic: models.Comic{ID: task.ComicID, Title: task.Title, Author: task.Author},
+		}, nil, "")
+		if err != nil {
+			return fmt.Errorf("重复检测失败: %w", err)
 		}
-		// 文件夹已存在，尝试下一个名字
-		counter++
-		folderName = fmt.Sprintf("%s_%d", baseFolderName, counter)
+		if len(matches) > 0 && policy == DuplicateSkip {
+			fmt.Printf("[直接下载] 漫画已存在（%s），跳过下载\n", matches[0].Title)
+			dm.recordDuplicateReport(task, &DuplicateReport{
+				Policy: policy, Action: DuplicateActionSkipped, Matches: matches,
+				ComicID: matches[0].ComicID, Directory: matches[0].Directory,
+			})
+			return nil
+		}
+		if len(matches) > 0 {
+			if existing, err := dm.GetComic(matches[0].ComicID); err == nil {
+				have := make(map[int]bool, len(existing.DownloadedEps))
+				for _, ep := range existing.DownloadedEps {
+					have[ep] = true
+				}
+				missing := extra.Episodes[:0]
+				totalPages := 0
+				for _, ep := range extra.Episodes {
+					if !have[ep.Order] {
+						missing = append(missing, ep)
+						totalPages += len(ep.PageURLs)
+					}
+				}
+				fmt.Printf("[直接下载] 漫画已存在（%s），只下载缺少的 %d 个章节\n", matches[0].Title, len(missing))
+				extra.Episodes = missing
+				task.TotalPages = totalPages
+			}
+		}
+	}
+
+	// 使用漫画标题作为文件夹名（安全化处理），已存在时添加数字后缀
+	folderName, err := dm.uniqueFolderName(sanitizeFolderName(task.Title))
+	if err != nil {
+		return err
 	}
 
 	downloadDir := filepath.Join(dm.downloadPath, folderName)
@@ -1320,7 +1610,7 @@ func (dm *DownloadManager) downloadDirectComic(task *models.DownloadTask) error
 		}
 
 		for index, pageURL := range ep.PageURLs {
-			filePath := filepath.Join(epDir, fmt.Sprintf("%03d.jpg", index+1))
+			filePath := filepath.Join(epDir, directPageName(index))
 			fmt.Printf("[直接下载] 正在下载章节 %d 第 %d/%d 页\n", ep.Order, index+1, len(ep.PageURLs))
 
 			// 使用章节对应的请求头下载图片
@@ -1339,46 +1629,18 @@ func (dm *DownloadManager) downloadDirectComic(task *models.DownloadTask) error
 			time.Sleep(10 * time.Millisecond)
 
 			// 如果有反混淆参数，进行反混淆处理
-			if ep.DescrambleParams != nil && len(ep.DescrambleParams) > 0 {
-				epsId := ep.DescrambleParams["epsId"]
-				scrambleId := ep.DescrambleParams["scrambleId"]
-				
-				// 使用客户端传来的 bookId（如果有）
-				var bookId string
-				if ep.PageDescrambleParams != nil && index < len(ep.PageDescrambleParams) {
-					bookId = ep.PageDescrambleParams[index]["bookId"]
-					fmt.Printf("[反混淆] 图片 %d/%d - URL: %s\n", index+1, len(ep.PageURLs), pageURL)
-					fmt.Printf("[反混淆] 使用客户端提供的 bookId: '%s'\n", bookId)
-				} else {
-					// 回退：从 URL 中提取 bookId
-					bookId = extractBookIdFromUrl(pageURL)
-					fmt.Printf("[反混淆] 回退：从URL提取 bookId: '%s'\n", bookId)
-				}
-
-				fmt.Printf("[反混淆] 参数: epsId=%s, scrambleId=%s, bookId=%s, 文件=%s\n", epsId, scrambleId, bookId, filePath)
-				if err := DescrambleJmImage(filePath, epsId, scrambleId, bookId); err != nil {
-					fmt.Printf("[❌ 错误] 图片 %d 反混淆失败: %v\n", index+1, err)
-					// 不中断下载，继续处理其他图片
-				} else {
-					fmt.Printf("[✅ 成功] 图片 %d 反混淆完成\n", index+1)
-				}
-			}
+			ep.descramble(index, filePath)
 
 			downloadedPages++
 			task.DownloadedPages = downloadedPages
 			task.CurrentEp = ep.Order
 			dm.updateTaskStatus(task)
 		}
-
-		// 保存章节信息
-		if err := repo.AppendDownloadedEp(task.ComicID, ep.Order); err != nil {
-			fmt.Printf("[警告] 保存章节信息失败: %v\n", err)
-		}
 	}
 
 	// 保存漫画详情
 	// 解析标签和分类
-	allTags, categories := parseTags(task.Tags)
+	allTags, categories, tagGroups := parseTags(task.Tags)
 
 	var epNames []string
 	var epOrders []int
@@ -1399,6 +1661,7 @@ func (dm *DownloadManager) downloadDirectComic(task *models.DownloadTask) error
 			Description: task.Description,
 			Tags:        allTags,    // 所有标签
 			Categories:  categories, // 分类（从tags中提取的category键）
+			TagGroups:   tagGroups,  // 带命名空间的标签
 			Type:        task.Type,
 			EpsCount:    len(extra.Episodes),
 			PagesCount:  task.TotalPages,
@@ -1411,46 +1674,68 @@ func (dm *DownloadManager) downloadDirectComic(task *models.DownloadTask) error
 		DownloadedEps: epOrders,
 	}
 
-	if err := repo.SaveComicDetail(detail); err != nil {
-		return fmt.Errorf("保存漫画详情失败: %w", err)
+	report, err := dm.saveNewComic(detail, policy)
+	if err != nil {
+		return err
 	}
+	dm.recordDuplicateReport(task, report)
 
-	fmt.Printf("[直接下载] ✅ 下载完成！\n")
+	fmt.Printf("[直接下载] ✅ 下载完成（%s）！\n", report.Action)
 	return nil
 }
 
-// ImportComicFromClient 从客户端导入已下载的漫画
-func (dm *DownloadManager) ImportComicFromClient(r *http.Request, comicID, title, comicType, author, description, coverURL string) error {
-	fmt.Printf("[导入] 开始导入漫画: %s\n", title)
+// recordDuplicateReport 将重复检测结果写入任务的 extra 字段，供客户端查询任务时查看
+func (dm *DownloadManager) recordDuplicateReport(task *models.DownloadTask, report *DuplicateReport) {
+	var extra map[string]interface{}
+	if err := json.Unmarshal([]byte(task.Extra), &extra); err != nil {
+		return
+	}
+	extra["duplicate"] = report
+	data, _ := json.Marshal(extra)
+	task.Extra = string(data)
+	if _, err := dm.db.Exec("UPDATE download_tasks SET extra = ? WHERE id = ?", task.Extra, task.ID); err != nil {
+		log.Printf("[重复检测] 保存任务 %s 的处理结果失败: %v", task.ID, err)
+	}
+}
+
+// ImportMetadata 导入漫画时客户端提供的元数据（表单字段）
+type ImportMetadata struct {
+	ComicID      string
+	Title        string
+	Type         string
+	Author       string
+	Description  string
+	Cover        string
+	Eps          string // 章节名称 JSON 数组
+	Tags         string // 标签 JSON（命名空间 -> 标签列表）
+	DownloadTime string // 下载时间（RFC3339）
+	OnDuplicate  string // 与已有漫画重复时的处理策略：skip、replace、merge、keep（默认）
+}
 
-	repo := NewTaskRepository(dm.db, dm.downloadPath)
+// ImportComicFromClient 从客户端导入已下载的漫画
+func (dm *DownloadManager) ImportComicFromClient(r *http.Request, meta ImportMetadata) (*ImportResult, error) {
+	fmt.Printf("[导入] 开始导入漫画: %s\n", meta.Title)
 
 	// 1. 创建漫画目录
-	folderName := sanitizeFolderName(title)
-	counter := 1
-	for {
-		testPath := filepath.Join(dm.downloadPath, folderName)
-		if _, err := os.Stat(testPath); os.IsNotExist(err) {
-			break
-		}
-		counter++
-		folderName = fmt.Sprintf("%s_%d", sanitizeFolderName(title), counter)
+	folderName, err := dm.uniqueFolderName(sanitizeFolderName(meta.Title))
+	if err != nil {
+		return nil, err
 	}
 
 	comicDir := filepath.Join(dm.downloadPath, folderName)
 	if err := os.MkdirAll(comicDir, 0755); err != nil {
-		return fmt.Errorf("创建目录失败: %w", err)
+		return nil, fmt.Errorf("创建目录失败: %w", err)
 	}
 
 	// 2. 获取上传的文件
 	form := r.MultipartForm
 	if form == nil {
-		return fmt.Errorf("没有上传文件")
+		return nil, fmt.Errorf("没有上传文件")
 	}
 
 	files := form.File["files"]
 	if len(files) == 0 {
-		return fmt.Errorf("没有上传文件")
+		return nil, fmt.Errorf("没有上传文件")
 	}
 
 	// 3. 解析章节结构并保存文件
@@ -1508,20 +1793,26 @@ func (dm *DownloadManager) ImportComicFromClient(r *http.Request, comicID, title
 			continue
 		}
 
+		if epNum < 1 {
+			fmt.Printf("[警告] 无效的章节号: %s (章节号从 1 开始)\n", filename)
+			file.Close()
+			continue
+		}
+
 		fmt.Printf("[导入] 解析成功: 章节 %d, 页面 %d, 扩展名 %s\n", epNum, pageNum, ext)
 
 		// 创建章节目录
 		epDir := filepath.Join(comicDir, fmt.Sprintf("%d", epNum))
 		if err := os.MkdirAll(epDir, 0755); err != nil {
 			file.Close()
-			return fmt.Errorf("创建章节目录失败: %w", err)
+			return nil, fmt.Errorf("创建章节目录失败: %w", err)
 		}
 
 		// 保存图片，保留原始扩展名
 		imagePath := filepath.Join(epDir, fmt.Sprintf("%03d%s", pageNum, ext))
 		if err := saveUploadedFile(file, imagePath); err != nil {
 			file.Close()
-			return fmt.Errorf("保存图片失败: %w", err)
+			return nil, fmt.Errorf("保存图片失败: %w", err)
 		}
 		fmt.Printf("[导入] ✅ 图片已保存: %s\n", imagePath)
 		file.Close()
@@ -1537,63 +1828,82 @@ func (dm *DownloadManager) ImportComicFromClient(r *http.Request, comicID, title
 
 	fmt.Printf("[导入] 文件处理完成: %d 个章节, %d 页\n", epsCount, totalPages)
 
-	// 4. 构建章节列表
+	// 4. 构建章节列表并保存
 	var epOrders []int
 	for ep := range episodeMap {
 		epOrders = append(epOrders, ep)
 	}
 	sort.Ints(epOrders)
 
+	report, err := dm.saveImportedComic(meta, folderName, epOrders, nil, totalPages)
+	if err != nil {
+		return nil, err
+	}
+	return &ImportResult{
+		ComicID:    report.ComicID,
+		Directory:  report.Directory,
+		Layout:     ImportLayoutClient,
+		EpsCount:   len(epOrders),
+		PagesCount: totalPages,
+		Duplicate:  report,
+	}, nil
+}
+
+// saveImportedComic 将导入的漫画写入数据库，与已有漫画重复时按 meta.OnDuplicate 处理
+// defaultEps 为客户端未提供章节名称时使用的名称（为空时使用"第 N 话"）
+func (dm *DownloadManager) saveImportedComic(meta ImportMetadata, folderName string, epOrders []int, defaultEps []string, totalPages int) (*DuplicateReport, error) {
+	policy, err := ParseDuplicatePolicy(meta.OnDuplicate)
+	if err != nil {
+		return nil, err
+	}
+	comicDir := filepath.Join(dm.downloadPath, folderName)
+	epsCount := len(epOrders)
+
 	// 尝试从客户端获取章节名称列表
 	var epNames []string
-	epsJSON := r.PostFormValue("eps")
-	if epsJSON != "" {
-		if err := json.Unmarshal([]byte(epsJSON), &epNames); err != nil {
+	if meta.Eps != "" {
+		if err := json.Unmarshal([]byte(meta.Eps), &epNames); err != nil {
 			fmt.Printf("[警告] 解析章节名称失败: %v\n", err)
-			// 如果解析失败，使用默认名称
-			for _, ep := range epOrders {
-				epNames = append(epNames, fmt.Sprintf("第 %d 话", ep))
-			}
+			epNames = nil
 		}
-	} else {
+	}
+	if epNames == nil {
 		// 没有提供章节名称，使用默认名称
-		for _, ep := range epOrders {
-			epNames = append(epNames, fmt.Sprintf("第 %d 话", ep))
+		for i, ep := range epOrders {
+			if i < len(defaultEps) && defaultEps[i] != "" {
+				epNames = append(epNames, defaultEps[i])
+			} else {
+				epNames = append(epNames, fmt.Sprintf("第 %d 话", ep))
+			}
 		}
 	}
 
-	// 5. 解析标签和分类
-	tagsJSON := r.PostFormValue("tags")
-	allTags, categories := parseTags(tagsJSON)
+	// 解析标签和分类
+	allTags, categories, tagGroups := parseTags(meta.Tags)
 
-	// 6. 获取下载时间
-	var downloadTime time.Time
-	downloadTimeStr := r.PostFormValue("download_time")
-	if downloadTimeStr != "" {
-		parsedTime, err := time.Parse(time.RFC3339, downloadTimeStr)
-		if err == nil {
+	// 获取下载时间
+	downloadTime := time.Now()
+	if meta.DownloadTime != "" {
+		if parsedTime, err := time.Parse(time.RFC3339, meta.DownloadTime); err == nil {
 			downloadTime = parsedTime
-		} else {
-			downloadTime = time.Now()
 		}
-	} else {
-		downloadTime = time.Now()
 	}
 
-	// 7. 保存到数据库
+	// 保存到数据库
 	// 计算漫画文件夹大小
 	folderSize := calculateFolderSize(comicDir)
 
 	detail := &models.ComicDetail{
 		Comic: models.Comic{
-			ID:          comicID,
-			Title:       title,
-			Cover:       coverURL,
-			Author:      author,
-			Description: description,
+			ID:          meta.ComicID,
+			Title:       meta.Title,
+			Cover:       meta.Cover,
+			Author:      meta.Author,
+			Description: meta.Description,
 			Tags:        allTags,    // 所有标签
 			Categories:  categories, // 分类（从tags中提取的category键）
-			Type:        comicType,
+			TagGroups:   tagGroups,  // 带命名空间的标签
+			Type:        meta.Type,
 			EpsCount:    epsCount,
 			PagesCount:  totalPages,
 			Size:        folderSize,   // 计算的实际大小（字节）
@@ -1604,12 +1914,13 @@ func (dm *DownloadManager) ImportComicFromClient(r *http.Request, comicID, title
 		DownloadedEps: epOrders,
 	}
 
-	if err := repo.SaveComicDetail(detail); err != nil {
-		return fmt.Errorf("保存漫画详情失败: %w", err)
+	report, err := dm.saveNewComic(detail, policy)
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("[导入] ✅ 导入完成！共 %d 个章节，%d 页，大小 %.2f MB\n", epsCount, totalPages, float64(folderSize)/(1024*1024))
-	return nil
+	fmt.Printf("[导入] ✅ 导入完成（%s）！共 %d 个章节，%d 页，大小 %.2f MB\n", report.Action, epsCount, totalPages, float64(folderSize)/(1024*1024))
+	return report, nil
 }
 
 // saveUploadedFile 保存上传的文件
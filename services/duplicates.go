@@ -0,0 +1,583 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"pica-comic-server/models"
+)
+
+// 重复漫画的处理策略
+const (
+	DuplicateKeep    = "keep"    // 保留两者：新漫画使用新目录，ID 冲突时添加后缀（默认）
+	DuplicateSkip    = "skip"    // 跳过：不保存新漫画
+	DuplicateReplace = "replace" // 替换：已有漫画移入回收站
+	DuplicateMerge   = "merge"   // 合并：只把已有漫画缺少的章节加入已有漫画
+)
+
+// 重复处理的结果
+const (
+	DuplicateActionCreated  = "created"  // 没有重复，正常保存
+	DuplicateActionPending  = "pending"  // 下载任务：下载完成后再按策略处理
+	DuplicateActionSkipped  = "skipped"  // 已跳过
+	DuplicateActionReplaced = "replaced" // 已替换已有漫画
+	DuplicateActionMerged   = "merged"   // 已合并到已有漫画
+	DuplicateActionKept     = "kept"     // 已保留两者
+)
+
+// 判断为重复的依据
+const (
+	DuplicateBySourceID = "source_id" // 来源漫画ID相同
+	DuplicateByTitle    = "title"     // 标题规范化后相同（作者相同或未知）
+	DuplicateByPages    = "pages"     // 前几页图片内容相同
+	DuplicateByAuthor   = "author"    // 页面内容相同，且作者相同（不单独作为依据）
+)
+
+var (
+	// ErrInvalidDuplicatePolicy 无法识别的重复处理策略
+	ErrInvalidDuplicatePolicy = errors.New("无效的重复处理策略，可选 skip、replace、merge、keep")
+	// ErrMergeTargetArchive 已有漫画是压缩包，无法加入章节
+	ErrMergeTargetArchive = errors.New("压缩包漫画不支持合并章节")
+)
+
+// ParseDuplicatePolicy 解析重复处理策略，为空时使用 keep
+func ParseDuplicatePolicy(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return DuplicateKeep, nil
+	case DuplicateKeep, DuplicateSkip, DuplicateReplace, DuplicateMerge:
+		return s, nil
+	}
+	return "", ErrInvalidDuplicatePolicy
+}
+
+// DuplicateMatch 漫画库中与新漫画重复的漫画
+type DuplicateMatch struct {
+	ComicID   string   `json:"comic_id"`
+	Title     string   `json:"title"`
+	Author    string   `json:"author"`
+	Directory string   `json:"directory"`
+	Reasons   []string `json:"reasons"`
+}
+
+// rank 匹配的可信度：来源ID > 页面内容 > 标题
+func (m DuplicateMatch) rank() int {
+	rank := 0
+	for _, reason := range m.Reasons {
+		switch reason {
+		case DuplicateBySourceID:
+			rank += 4
+		case DuplicateByPages:
+			rank += 2
+		case DuplicateByTitle, DuplicateByAuthor:
+			rank++
+		}
+	}
+	return rank
+}
+
+// corroborated 除页面内容外是否还有来源ID、标题或作者相同：
+// 只有页面相同的可能是共用汉化组声明页、通用封面的不同漫画，不执行替换或合并
+func (m DuplicateMatch) corroborated() bool {
+	for _, reason := range m.Reasons {
+		if reason != DuplicateByPages {
+			return true
+		}
+	}
+	return false
+}
+
+// DuplicateReport 重复检测及处理结果
+type DuplicateReport struct {
+	Policy     string           `json:"policy"`
+	Action     string           `json:"action"`
+	Matches    []DuplicateMatch `json:"matches,omitempty"`
+	ComicID    string           `json:"comic_id,omitempty"`    // 最终保存（或保留）的漫画ID
+	Directory  string           `json:"directory,omitempty"`   // 最终的漫画目录
+	MergedEps  []int            `json:"merged_eps,omitempty"`  // 合并时加入的章节
+	SkippedEps []int            `json:"skipped_eps,omitempty"` // 合并时已存在而跳过的章节
+}
+
+// normalizeForMatch 规范化标题、作者：转为小写，只保留字母和数字
+func normalizeForMatch(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// sameAuthor 比较作者，任一方为空或未知时视为相同
+func sameAuthor(a, b string) bool {
+	a, b = normalizeForMatch(a), normalizeForMatch(b)
+	unknown := normalizeForMatch("未知")
+	return a == "" || b == "" || a == unknown || b == unknown || a == b
+}
+
+// 页面指纹：第一个章节前几页的内容哈希，每页只读取开头部分（加上文件大小），避免扫描整个漫画库时读取全部图片
+const (
+	fingerprintPages  = 5
+	fingerprintPrefix = 16 * 1024
+	// commonPageComics 出现在这么多部漫画中的页面（汉化组声明页、通用封面等）不作为重复的依据
+	commonPageComics = 3
+)
+
+// pageHash 计算页面的指纹
+func pageHash(page PageRef) (string, error) {
+	rc, size, err := page.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, size)
+	if _, err := io.CopyN(h, rc, fingerprintPrefix); err != nil && err != io.EOF {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// pageHashes 计算漫画的页面指纹，sources 为漫画的章节
+func pageHashes(sources []episodeSource) []string {
+	for _, src := range sources {
+		pages, err := src.pages()
+		if err != nil || len(pages) == 0 {
+			continue
+		}
+		if len(pages) > fingerprintPages {
+			pages = pages[:fingerprintPages]
+		}
+		hashes := make([]string, 0, len(pages))
+		for _, page := range pages {
+			if hash, err := pageHash(page); err == nil {
+				hashes = append(hashes, hash)
+			}
+		}
+		return hashes
+	}
+	return nil
+}
+
+// storePageFingerprints 保存漫画的页面指纹（替换原有的指纹）
+func (dm *DownloadManager) storePageFingerprints(comicID string, hashes []string) error {
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM page_fingerprints WHERE comic_id = ?", comicID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO page_fingerprints (comic_id, hash) VALUES (?, ?)", comicID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// updatePageFingerprints 重新计算并保存漫画的页面指纹（漫画保存或章节变化后调用）
+func (dm *DownloadManager) updatePageFingerprints(comicID, directory string) {
+	if err := dm.storePageFingerprints(comicID, pageHashes(dm.comicSources(directory))); err != nil {
+		log.Printf("[重复检测] 保存页面指纹失败: %s, 错误: %v", directory, err)
+	}
+}
+
+// backfillPageFingerprints 为还没有页面指纹的漫画计算指纹（升级前已有的漫画、在其它途径保存的漫画），
+// 并清理已不在库中的漫画的指纹
+func (dm *DownloadManager) backfillPageFingerprints() {
+	if _, err := dm.db.Exec(`
+		DELETE FROM page_fingerprints WHERE comic_id NOT IN (SELECT id FROM comics UNION SELECT id FROM scanned_comics)
+	`); err != nil {
+		log.Printf("[重复检测] 清理页面指纹失败: %v", err)
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT id, directory FROM comics WHERE deleted_at IS NULL AND directory IS NOT NULL
+			AND id NOT IN (SELECT comic_id FROM page_fingerprints)
+		UNION ALL
+		SELECT id, directory FROM scanned_comics WHERE id NOT IN (SELECT comic_id FROM page_fingerprints)
+	`)
+	if err != nil {
+		log.Printf("[重复检测] 查询缺少指纹的漫画失败: %v", err)
+		return
+	}
+	type pending struct{ id, directory string }
+	var list []pending
+	for rows.Next() {
+		var p pending
+		if rows.Scan(&p.id, &p.directory) == nil {
+			list = append(list, p)
+		}
+	}
+	rows.Close()
+
+	for _, p := range list {
+		dm.updatePageFingerprints(p.id, p.directory)
+	}
+}
+
+// inClause 生成 SQL 的 IN 参数占位符
+func inClause(values []string) (string, []interface{}) {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", len(values)), ","), args
+}
+
+// pageMatches 按页面指纹在数据库中查找页面相同的漫画：
+// 忽略出现在 commonPageComics 部以上漫画中的页面，其余页面至少两页相同（页数更少时全部相同）
+func (dm *DownloadManager) pageMatches(hashes []string) (map[string]bool, error) {
+	unique := make([]string, 0, len(hashes))
+	seen := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		if !seen[h] {
+			seen[h] = true
+			unique = append(unique, h)
+		}
+	}
+	if len(unique) == 0 {
+		return nil, nil
+	}
+
+	placeholders, args := inClause(unique)
+	rows, err := dm.db.Query(`
+		SELECT hash FROM page_fingerprints WHERE hash IN (`+placeholders+`)
+		GROUP BY hash HAVING COUNT(DISTINCT comic_id) >= ?
+	`, append(args, commonPageComics)...)
+	if err != nil {
+		return nil, err
+	}
+	common := make(map[string]bool)
+	for rows.Next() {
+		var h string
+		if rows.Scan(&h) == nil {
+			common[h] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var distinctive []string
+	for _, h := range unique {
+		if !common[h] {
+			distinctive = append(distinctive, h)
+		}
+	}
+	if len(distinctive) == 0 {
+		return nil, nil
+	}
+
+	placeholders, args = inClause(distinctive)
+	rows, err = dm.db.Query(`
+		SELECT comic_id, COUNT(*),
+			(SELECT COUNT(*) FROM page_fingerprints t WHERE t.comic_id = f.comic_id)
+		FROM page_fingerprints f WHERE hash IN (`+placeholders+`)
+		GROUP BY comic_id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	matches := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		var shared, total int
+		if err := rows.Scan(&id, &shared, &total); err != nil {
+			return nil, err
+		}
+		need := 2
+		if len(distinctive) < need {
+			need = len(distinctive)
+		}
+		if total < need {
+			need = total
+		}
+		if shared >= need {
+			matches[id] = true
+		}
+	}
+	return matches, rows.Err()
+}
+
+// knownAuthor 作者是否已知（不为空或“未知”）
+func knownAuthor(author string) bool {
+	a := normalizeForMatch(author)
+	return a != "" && a != normalizeForMatch("未知")
+}
+
+// findDuplicates 在漫画库（下载的和扫描的漫画）中查找与新漫画重复的漫画，按可信度排序
+// hashes 为新漫画的页面指纹（为空时不比较页面），excludeDir 为新漫画自身的目录；
+// 已有漫画的页面指纹在保存时写入数据库，查找时不读取漫画文件
+func (dm *DownloadManager) findDuplicates(comic *models.ComicDetail, hashes []string, excludeDir string) ([]DuplicateMatch, error) {
+	pageMatched, err := dm.pageMatches(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT id, title, COALESCE(author, ''), directory FROM comics WHERE deleted_at IS NULL
+		UNION ALL
+		SELECT id, title, COALESCE(author, ''), directory FROM scanned_comics
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	title := normalizeForMatch(comic.Title)
+	var matches []DuplicateMatch
+	for rows.Next() {
+		var m DuplicateMatch
+		if err := rows.Scan(&m.ComicID, &m.Title, &m.Author, &m.Directory); err != nil {
+			return nil, err
+		}
+		if m.Directory == excludeDir {
+			continue
+		}
+		if comic.ID != "" && m.ComicID == comic.ID {
+			m.Reasons = append(m.Reasons, DuplicateBySourceID)
+		}
+		if title != "" && normalizeForMatch(m.Title) == title && sameAuthor(comic.Author, m.Author) {
+			m.Reasons = append(m.Reasons, DuplicateByTitle)
+		}
+		if pageMatched[m.ComicID] {
+			m.Reasons = append(m.Reasons, DuplicateByPages)
+			if knownAuthor(comic.Author) && knownAuthor(m.Author) &&
+				normalizeForMatch(comic.Author) == normalizeForMatch(m.Author) {
+				m.Reasons = append(m.Reasons, DuplicateByAuthor)
+			}
+		}
+		if len(m.Reasons) > 0 {
+			matches = append(matches, m)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].rank() > matches[j].rank() })
+	return matches, nil
+}
+
+// uniqueComicID 返回数据库中尚未使用的漫画ID，已使用时添加数字后缀
+func (dm *DownloadManager) uniqueComicID(id string) (string, error) {
+	candidate := id
+	for counter := 2; ; counter++ {
+		var exists int
+		err := dm.db.QueryRow("SELECT 1 FROM comics WHERE id = ?", candidate).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s_%d", id, counter)
+	}
+}
+
+// isActiveComicID 数据库中是否有该ID的漫画（不含回收站中的）
+func (dm *DownloadManager) isActiveComicID(id string) (bool, error) {
+	var exists int
+	err := dm.db.QueryRow("SELECT 1 FROM comics WHERE id = ? AND deleted_at IS NULL", id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// saveNewComic 检测新漫画是否与漫画库中已有的漫画重复，并按策略保存
+// detail.Directory 为新漫画已写入的目录，跳过、合并后该目录会被删除
+func (dm *DownloadManager) saveNewComic(detail *models.ComicDetail, policy string) (*DuplicateReport, error) {
+	newPath := filepath.Join(dm.downloadPath, detail.Directory)
+	sources, _ := listEpisodeSources(newPath, nil)
+	hashes := pageHashes(sources)
+	matches, err := dm.findDuplicates(detail, hashes, detail.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("重复检测失败: %w", err)
+	}
+
+	report := &DuplicateReport{Policy: policy, Action: DuplicateActionCreated, Matches: matches}
+	if len(matches) > 0 {
+		target := matches[0]
+		log.Printf("[重复检测] '%s' 与已有漫画 '%s' 重复（%s），策略: %s",
+			detail.Title, target.Title, strings.Join(target.Reasons, ", "), policy)
+
+		action := policy
+		if (policy == DuplicateReplace || policy == DuplicateMerge) && !target.corroborated() {
+			// 只有页面内容相同时不确定是同一部漫画，不改动已有漫画
+			log.Printf("[重复检测] 只有页面内容相同，不执行%s，保留两者", policy)
+			action = DuplicateKeep
+		}
+
+		switch action {
+		case DuplicateSkip:
+			os.RemoveAll(newPath)
+			report.Action, report.ComicID, report.Directory = DuplicateActionSkipped, target.ComicID, target.Directory
+			return report, nil
+		case DuplicateMerge:
+			if err := dm.mergeIntoComic(target, detail, report); err != nil {
+				return nil, err
+			}
+			return report, nil
+		case DuplicateReplace:
+			if _, err := dm.TrashComic(target.ComicID); err != nil {
+				return nil, fmt.Errorf("移除已有漫画失败: %w", err)
+			}
+			// 原目录空出后使用不带后缀的目录名
+			base := sanitizeFolderName(detail.Title)
+			if exists, err := dm.comicExists(base); err == nil && base != detail.Directory && !exists {
+				if err := os.Rename(newPath, filepath.Join(dm.downloadPath, base)); err == nil {
+					dm.moveEpisodeNumbers(detail.Directory, base)
+					detail.Directory = base
+				}
+			}
+			report.Action = DuplicateActionReplaced
+		default:
+			active, err := dm.isActiveComicID(detail.ID)
+			if err != nil {
+				return nil, err
+			}
+			if active {
+				if detail.ID, err = dm.uniqueComicID(detail.ID); err != nil {
+					return nil, err
+				}
+			}
+			report.Action = DuplicateActionKept
+		}
+	}
+
+	if err := dm.storeComic(detail.Directory); err != nil {
+		return nil, err
+	}
+	repo := NewTaskRepository(dm.db, dm.downloadPath)
+	if err := repo.SaveComicDetail(detail); err != nil {
+		return nil, fmt.Errorf("保存漫画详情失败: %w", err)
+	}
+	if err := dm.storePageFingerprints(detail.ID, hashes); err != nil {
+		log.Printf("[重复检测] 保存页面指纹失败: %s, 错误: %v", detail.Directory, err)
+	}
+	report.ComicID, report.Directory = detail.ID, detail.Directory
+	return report, nil
+}
+
+// mergeIntoComic 将新漫画中已有漫画缺少的章节移入已有漫画的目录，并更新其章节信息，然后删除新漫画的目录
+func (dm *DownloadManager) mergeIntoComic(target DuplicateMatch, detail *models.ComicDetail, report *DuplicateReport) error {
+	if isArchiveFile(target.Directory) {
+		return ErrMergeTargetArchive
+	}
+	// 移动任何章节之前先检查章节号，避免合并到一半失败
+	for _, ep := range detail.DownloadedEps {
+		if ep < 1 {
+			return fmt.Errorf("无效的章节号: %d", ep)
+		}
+	}
+	exists, err := dm.comicExists(target.Directory)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("已有漫画的目录不存在: %s", target.Directory)
+	}
+	existing, err := dm.GetComic(target.ComicID)
+	if err != nil {
+		return err
+	}
+
+	have := make(map[int]bool, len(existing.DownloadedEps))
+	for _, ep := range existing.DownloadedEps {
+		have[ep] = true
+	}
+	for _, src := range dm.comicSources(target.Directory) {
+		have[src.ep] = true
+	}
+	newPath := filepath.Join(dm.downloadPath, detail.Directory)
+	for _, ep := range detail.DownloadedEps {
+		if have[ep] {
+			report.SkippedEps = append(report.SkippedEps, ep)
+			continue
+		}
+		if err := dm.moveIntoComic(filepath.Join(newPath, strconv.Itoa(ep)), target.Directory, strconv.Itoa(ep)); err != nil {
+			return fmt.Errorf("合并章节失败: %w", err)
+		}
+		report.MergedEps = append(report.MergedEps, ep)
+	}
+	os.RemoveAll(newPath)
+	report.Action, report.ComicID, report.Directory = DuplicateActionMerged, target.ComicID, target.Directory
+
+	inDB, err := dm.isDBComic(target.ComicID)
+	if err != nil {
+		return err
+	}
+	if !inDB {
+		// 扫描的漫画直接重新索引文件夹
+		return dm.indexScannedFolder(target.Directory, folderSignature(filepath.Join(dm.downloadPath, target.Directory)))
+	}
+
+	downloaded := append([]int(nil), existing.DownloadedEps...)
+	downloaded = append(downloaded, report.MergedEps...)
+	sort.Ints(downloaded)
+	pagesCount := 0
+	for _, ep := range downloaded {
+		if src, err := dm.episodeSourceOf(existing, ep); err == nil {
+			pagesCount += src.pageCount()
+		}
+	}
+	// 章节名称：合并进来的章节使用新漫画中的名称
+	eps := append([]string(nil), existing.Eps...)
+	for i, ep := range detail.DownloadedEps {
+		if ep < 1 || !containsInt(report.MergedEps, ep) {
+			continue
+		}
+		for len(eps) < ep {
+			eps = append(eps, fmt.Sprintf("第 %d 话", len(eps)+1))
+		}
+		if i < len(detail.Eps) && (ep > len(existing.Eps) || eps[ep-1] == "") {
+			eps[ep-1] = detail.Eps[i]
+		}
+	}
+	epsCount := existing.EpsCount
+	if len(downloaded) > epsCount {
+		epsCount = len(downloaded)
+	}
+
+	epsJSON, _ := json.Marshal(eps)
+	downloadedJSON, _ := json.Marshal(downloaded)
+	if _, err := dm.db.Exec(`
+		UPDATE comics SET eps = ?, eps_count = ?, downloaded_eps = ?, pages_count = ?, size = ? WHERE id = ?
+	`, string(epsJSON), epsCount, string(downloadedJSON), pagesCount, dm.comicSize(target.Directory), target.ComicID); err != nil {
+		return fmt.Errorf("更新漫画信息失败: %w", err)
+	}
+	if comic, err := dm.GetComic(target.ComicID); err == nil {
+		syncComicFTS(dm.db, comic)
+	}
+	dm.updatePageFingerprints(target.ComicID, target.Directory)
+	return nil
+}
+
+// containsInt 切片中是否包含 v
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"pica-comic-server/models"
+)
+
+// newTestManager 创建使用内存数据库和临时下载目录的下载管理器
+func newTestManager(t *testing.T) *DownloadManager {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	downloadPath := t.TempDir()
+	dm := &DownloadManager{
+		db:           db,
+		downloadPath: downloadPath,
+		storage:      NewLocalStorage(downloadPath),
+		indexer:      &libraryIndexer{},
+	}
+	if err := dm.createTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.migrateTables(); err != nil {
+		t.Fatal(err)
+	}
+	return dm
+}
+
+func TestFindDuplicatesByPages(t *testing.T) {
+	dm := newTestManager(t)
+	library := []struct {
+		id, title, author string
+		hashes            []string
+	}{
+		{"a", "Comic A", "Artist", []string{"credits", "a1", "a2", "a3"}},
+		{"b", "Comic B", "Other", []string{"credits", "b1", "b2"}},
+		{"c", "Comic C", "", []string{"credits", "c1"}},
+		{"d", "Comic D", "Someone", []string{"d1"}},
+	}
+	for _, c := range library {
+		if _, err := dm.db.Exec("INSERT INTO comics (id, title, author, directory) VALUES (?, ?, ?, ?)",
+			c.id, c.title, c.author, c.title); err != nil {
+			t.Fatal(err)
+		}
+		if err := dm.storePageFingerprints(c.id, c.hashes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		comic  models.Comic
+		hashes []string
+		want   map[string][]string // 漫画ID -> 判断依据
+	}{
+		{
+			name:   "two shared pages",
+			comic:  models.Comic{Title: "New"},
+			hashes: []string{"a1", "a2", "x"},
+			want:   map[string][]string{"a": {DuplicateByPages}},
+		},
+		{
+			name:   "page shared by many comics is ignored",
+			comic:  models.Comic{Title: "New"},
+			hashes: []string{"credits", "b1", "y"},
+			want:   map[string][]string{},
+		},
+		{
+			name:   "only common pages",
+			comic:  models.Comic{Title: "New"},
+			hashes: []string{"credits"},
+			want:   map[string][]string{},
+		},
+		{
+			name:   "single page comic matches fully",
+			comic:  models.Comic{Title: "New", Author: "someone"},
+			hashes: []string{"d1"},
+			want:   map[string][]string{"d": {DuplicateByPages, DuplicateByAuthor}},
+		},
+		{
+			name:   "title and source id without pages",
+			comic:  models.Comic{ID: "b", Title: "comic-a!", Author: "未知"},
+			hashes: nil,
+			want:   map[string][]string{"a": {DuplicateByTitle}, "b": {DuplicateBySourceID}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := dm.findDuplicates(&models.ComicDetail{Comic: tt.comic}, tt.hashes, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string][]string)
+			for _, m := range matches {
+				got[m.ComicID] = m.Reasons
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateMatchCorroborated(t *testing.T) {
+	tests := []struct {
+		reasons []string
+		want    bool
+	}{
+		{[]string{DuplicateByPages}, false},
+		{[]string{DuplicateByPages, DuplicateByAuthor}, true},
+		{[]string{DuplicateByTitle, DuplicateByPages}, true},
+		{[]string{DuplicateBySourceID}, true},
+	}
+	for _, tt := range tests {
+		if got := (DuplicateMatch{Reasons: tt.reasons}).corroborated(); got != tt.want {
+			t.Errorf("corroborated(%v) = %v, want %v", tt.reasons, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package services
+
+import "log"
+
+// episodeNumbers 读取漫画目录中非数字命名章节已分配的章节号（名称 -> 章节号）
+func (dm *DownloadManager) episodeNumbers(directory string) map[string]int {
+	numbers := make(map[string]int)
+	rows, err := dm.db.Query("SELECT name, ep FROM episode_numbers WHERE directory = ?", directory)
+	if err != nil {
+		log.Printf("[章节] 读取章节号失败 (%s): %v", directory, err)
+		return numbers
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var ep int
+		if rows.Scan(&name, &ep) == nil {
+			numbers[name] = ep
+		}
+	}
+	return numbers
+}
+
+// saveEpisodeNumbers 记录漫画目录中非数字命名章节的章节号
+func (dm *DownloadManager) saveEpisodeNumbers(directory string, numbers map[string]int) error {
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO episode_numbers (directory, name, ep) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for name, ep := range numbers {
+		if _, err := stmt.Exec(directory, name, ep); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// moveEpisodeNumbers 漫画目录改名后，章节号随目录转移
+func (dm *DownloadManager) moveEpisodeNumbers(oldDirectory, newDirectory string) error {
+	if oldDirectory == newDirectory {
+		return nil
+	}
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM episode_numbers WHERE directory = ?", newDirectory); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE episode_numbers SET directory = ? WHERE directory = ?", newDirectory, oldDirectory); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
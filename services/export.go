@@ -0,0 +1,274 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"pica-comic-server/models"
+)
+
+// ErrEmptyExport 没有可导出的页面
+var ErrEmptyExport = errors.New("没有可导出的页面")
+
+// exportEpisode 导出中的一个章节
+type exportEpisode struct {
+	Ep    int
+	Title string
+	Pages []PageRef
+}
+
+// ComicExport 一次导出的内容：整部漫画或单个章节
+type ComicExport struct {
+	Comic    *models.ComicDetail
+	Ep       int // 0 表示整部漫画
+	Title    string
+	Episodes []exportEpisode
+	Cover    *PageRef // 漫画封面，可能与第一页相同
+}
+
+// episodeTitle 章节标题：优先使用漫画信息中的章节名
+func episodeTitle(comic *models.ComicDetail, src episodeSource) string {
+	if src.ep > 0 && src.ep <= len(comic.Eps) && strings.TrimSpace(comic.Eps[src.ep-1]) != "" {
+		return comic.Eps[src.ep-1]
+	}
+	return src.title
+}
+
+// ExportComic 准备导出整部漫画（按章节顺序，没有章节时导出根目录中的页面）
+func (dm *DownloadManager) ExportComic(id string) (*ComicExport, error) {
+	return dm.ExportEpisodes(id, nil)
+}
+
+// ExportEpisodes 准备导出漫画中选定的章节（按章节顺序），eps 为空时导出全部章节
+// 选定的章节都不存在时返回 ErrEpisodeNotFound
+func (dm *DownloadManager) ExportEpisodes(id string, eps []int) (*ComicExport, error) {
+	comic, err := dm.GetComic(id)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := dm.comicSources(comic.Directory)
+	if len(sources) == 0 {
+		root, _ := dm.episodeSourceOf(comic, 0)
+		sources = []episodeSource{root}
+	}
+	if len(eps) > 0 {
+		// 未下载的章节直接跳过，便于按范围选择
+		selected := make(map[int]bool, len(eps))
+		for _, ep := range eps {
+			selected[ep] = true
+		}
+		filtered := sources[:0]
+		for _, src := range sources {
+			if selected[src.ep] {
+				filtered = append(filtered, src)
+			}
+		}
+		if len(filtered) == 0 {
+			return nil, ErrEpisodeNotFound
+		}
+		sources = filtered
+	}
+
+	export := &ComicExport{Comic: comic, Title: comic.Title}
+	for _, src := range sources {
+		pages, err := src.pages()
+		if err != nil || len(pages) == 0 {
+			continue
+		}
+		export.Episodes = append(export.Episodes, exportEpisode{
+			Ep:    src.ep,
+			Title: episodeTitle(comic, src),
+			Pages: pages,
+		})
+	}
+	if len(export.Episodes) == 0 {
+		return nil, ErrEmptyExport
+	}
+	if cover, err := dm.GetCover(id); err == nil {
+		export.Cover = &cover
+	}
+	return export, nil
+}
+
+// ExportEpisode 准备导出单个章节
+func (dm *DownloadManager) ExportEpisode(id string, ep int) (*ComicExport, error) {
+	comic, err := dm.GetComic(id)
+	if err != nil {
+		return nil, err
+	}
+	src, err := dm.episodeSourceOf(comic, ep)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := src.pages()
+	if err != nil {
+		return nil, ErrEpisodeNotFound
+	}
+	if len(pages) == 0 {
+		return nil, ErrEmptyExport
+	}
+
+	title := episodeTitle(comic, src)
+	export := &ComicExport{Comic: comic, Ep: ep, Title: comic.Title}
+	if title != "" {
+		export.Title = comic.Title + " - " + title
+	}
+	export.Episodes = []exportEpisode{{Ep: ep, Title: title, Pages: pages}}
+	if cover, err := dm.GetCover(id); err == nil {
+		export.Cover = &cover
+	}
+	return export, nil
+}
+
+// PageCount 导出的总页数
+func (e *ComicExport) PageCount() int {
+	count := 0
+	for _, episode := range e.Episodes {
+		count += len(episode.Pages)
+	}
+	return count
+}
+
+// FileName 导出文件名（不含扩展名）
+func (e *ComicExport) FileName() string {
+	return sanitizeFolderName(e.Title)
+}
+
+// comicInfo ComicInfo.xml（ComicRack 元数据格式）
+type comicInfo struct {
+	XMLName   xml.Name        `xml:"ComicInfo"`
+	XSI       string          `xml:"xmlns:xsi,attr"`
+	XSD       string          `xml:"xmlns:xsd,attr"`
+	Title     string          `xml:"Title,omitempty"`
+	Series    string          `xml:"Series,omitempty"`
+	Number    string          `xml:"Number,omitempty"`
+	Summary   string          `xml:"Summary,omitempty"`
+	Writer    string          `xml:"Writer,omitempty"`
+	Genre     string          `xml:"Genre,omitempty"`
+	Tags      string          `xml:"Tags,omitempty"`
+	Web       string          `xml:"Web,omitempty"`
+	PageCount int             `xml:"PageCount"`
+	Pages     []comicInfoPage `xml:"Pages>Page,omitempty"`
+}
+
+// comicInfoPage ComicInfo.xml 中的页面信息，章节的第一页带书签
+type comicInfoPage struct {
+	Image    int    `xml:"Image,attr"`
+	Type     string `xml:"Type,attr,omitempty"`
+	Bookmark string `xml:"Bookmark,attr,omitempty"`
+}
+
+// ComicInfoXML 生成 ComicInfo.xml
+func (e *ComicExport) ComicInfoXML() ([]byte, error) {
+	comic := e.Comic
+	info := comicInfo{
+		XSI:       "http://www.w3.org/2001/XMLSchema-instance",
+		XSD:       "http://www.w3.org/2001/XMLSchema",
+		Title:     e.Title,
+		Series:    comic.Title,
+		Summary:   comic.Description,
+		Writer:    comic.Author,
+		Genre:     strings.Join(comic.Categories, ", "),
+		Tags:      strings.Join(comic.Tags, ", "),
+		Web:       comic.DetailURL,
+		PageCount: e.PageCount(),
+	}
+	if e.Ep > 0 {
+		info.Number = fmt.Sprintf("%d", e.Ep)
+	}
+
+	index := 0
+	for _, episode := range e.Episodes {
+		for i := range episode.Pages {
+			page := comicInfoPage{Image: index}
+			if index == 0 {
+				page.Type = "FrontCover"
+			}
+			if i == 0 && len(e.Episodes) > 1 {
+				page.Bookmark = episode.Title
+			}
+			info.Pages = append(info.Pages, page)
+			index++
+		}
+	}
+
+	data, err := xml.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// exportPageName 导出文件中的页面文件名：按全局顺序编号，保持原扩展名
+func exportPageName(index, total int, page PageRef) string {
+	width := len(fmt.Sprintf("%d", total))
+	if width < 3 {
+		width = 3
+	}
+	return fmt.Sprintf("%0*d%s", width, index, strings.ToLower(path.Ext(page.Name())))
+}
+
+// WriteCBZ 将导出内容以 CBZ 格式写入 w（边读边写，不产生临时文件）
+// 图片本身已经压缩，页面使用存储模式写入
+func (e *ComicExport) WriteCBZ(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	info, err := e.ComicInfoXML()
+	if err != nil {
+		return err
+	}
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     "ComicInfo.xml",
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(info); err != nil {
+		return err
+	}
+
+	total := e.PageCount()
+	index := 0
+	for _, episode := range e.Episodes {
+		for _, page := range episode.Pages {
+			index++
+			if err := writeZipPage(zw, exportPageName(index, total, page), page); err != nil {
+				return fmt.Errorf("写入页面失败 %s: %w", page, err)
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// writeZipPage 将一张页面写入压缩包
+func writeZipPage(zw *zip.Writer, name string, page PageRef) error {
+	_, modTime, err := page.Stat()
+	if err != nil {
+		return err
+	}
+	rc, _, err := page.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, rc)
+	return err
+}
@@ -0,0 +1,329 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// epubTranscodeQuality 不能原样嵌入的图片转为 JPEG 时使用的质量
+const epubTranscodeQuality = 90
+
+// epubFormats 可以原样嵌入的图片格式（EPUB 核心媒体类型）对应的扩展名
+var epubFormats = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+}
+
+// epubMediaTypes 图片扩展名对应的媒体类型
+var epubMediaTypes = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+	".gif": "image/gif",
+}
+
+// EPUBOptions EPUB 导出选项
+type EPUBOptions struct {
+	Resize *ResizeOptions // 缩放参数（适配电子墨水屏分辨率），为空时使用原图
+	RTL    bool           // 从右往左翻页（日漫）
+}
+
+// epubItem EPUB 清单中的一个文件
+type epubItem struct {
+	id         string
+	href       string
+	mediaType  string
+	properties string
+	spine      bool
+}
+
+// epubImage 处理后的页面图片
+type epubImage struct {
+	data   []byte
+	ext    string
+	width  int
+	height int
+}
+
+// loadEPUBImage 读取页面图片，按需缩放，并获取其尺寸
+// 格式按文件内容识别；阅读器不一定支持 WebP 等格式，JPEG、PNG、GIF 以外的图片转为 JPEG
+func loadEPUBImage(page PageRef, resize *ResizeOptions) (*epubImage, error) {
+	data, err := page.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("无法识别的图片格式: %w", err)
+	}
+	if ext, ok := epubFormats[format]; ok && resize == nil {
+		return &epubImage{data: data, ext: ext, width: cfg.Width, height: cfg.Height}, nil
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("解码图片失败: %w", err)
+	}
+	opts := ResizeOptions{Format: "jpeg", Quality: epubTranscodeQuality}
+	if resize != nil {
+		opts = *resize
+	}
+	b := decoded.Bounds()
+	w, h := fitSize(b.Dx(), b.Dy(), opts.MaxWidth, opts.MaxHeight)
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, resizeImage(decoded, w, h), opts.Format, opts.Quality); err != nil {
+		return nil, err
+	}
+	return &epubImage{data: buf.Bytes(), ext: opts.ext(), width: w, height: h}, nil
+}
+
+// xmlEscape 转义 XML 文本
+func xmlEscape(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// epubPageXHTML 固定版式页面：整页显示一张图片
+func epubPageXHTML(title, imageHref string, width, height int) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+<title>%s</title>
+<meta name="viewport" content="width=%d, height=%d"/>
+<style>html, body { margin: 0; padding: 0; width: %dpx; height: %dpx; } img { display: block; width: 100%%; height: 100%%; }</style>
+</head>
+<body><img src="%s" alt=""/></body>
+</html>
+`, xmlEscape(title), width, height, width, height, imageHref)
+}
+
+// epubWriter 向压缩包写入 EPUB 各文件
+type epubWriter struct {
+	zw    *zip.Writer
+	items []epubItem
+}
+
+// writeMimetype 写入 mimetype 文件：必须是第一个条目，且不压缩、不带数据描述符
+func (ew *epubWriter) writeMimetype() error {
+	data := []byte("application/epub+zip")
+	fw, err := ew.zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(len(data)),
+		UncompressedSize64: uint64(len(data)),
+		Modified:           time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+// writeFile 写入文件，图片使用存储模式，文本使用压缩
+func (ew *epubWriter) writeFile(name string, data []byte, compress bool) error {
+	method := zip.Store
+	if compress {
+		method = zip.Deflate
+	}
+	fw, err := ew.zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+// addPage 写入一页（图片和对应的 XHTML），返回页面文件路径
+func (ew *epubWriter) addPage(id, title string, img *epubImage, imageProps string) (string, error) {
+	imageHref := "images/" + id + img.ext
+	pageHref := "pages/" + id + ".xhtml"
+	if err := ew.writeFile("OEBPS/"+imageHref, img.data, false); err != nil {
+		return "", err
+	}
+	xhtml := epubPageXHTML(title, "../"+imageHref, img.width, img.height)
+	if err := ew.writeFile("OEBPS/"+pageHref, []byte(xhtml), true); err != nil {
+		return "", err
+	}
+	ew.items = append(ew.items,
+		epubItem{id: "img-" + id, href: imageHref, mediaType: epubMediaTypes[img.ext], properties: imageProps},
+		epubItem{id: "page-" + id, href: pageHref, mediaType: "application/xhtml+xml", spine: true},
+	)
+	return pageHref, nil
+}
+
+// WriteEPUB 将导出内容以 EPUB 3 固定版式写入 w（边读边写，每张图片一页）
+// 章节作为目录项；页面尺寸需要先读取图片，因此 content.opf 和目录写在压缩包末尾
+func (e *ComicExport) WriteEPUB(w io.Writer, opts EPUBOptions) error {
+	ew := &epubWriter{zw: zip.NewWriter(w)}
+	if err := ew.writeMimetype(); err != nil {
+		return err
+	}
+	if err := ew.writeFile("META-INF/container.xml", []byte(epubContainerXML), true); err != nil {
+		return err
+	}
+
+	// 单独的封面文件作为第一页，否则第一页即封面
+	coverID := "img-p0001"
+	if e.Cover != nil && e.Cover.String() != e.Episodes[0].Pages[0].String() {
+		img, err := loadEPUBImage(*e.Cover, opts.Resize)
+		if err != nil {
+			// 封面无法转换时使用第一页作为封面
+			log.Printf("[导出] 跳过无法转换的封面: %s, 错误: %v", e.Cover, err)
+		} else {
+			if _, err := ew.addPage("cover", e.Title, img, "cover-image"); err != nil {
+				return err
+			}
+			coverID = "img-cover"
+		}
+	}
+
+	var nav []epubNavPoint
+	index := 0
+	for _, episode := range e.Episodes {
+		title := episode.Title
+		if title == "" {
+			title = e.Title
+		}
+		first := true
+		for i, page := range episode.Pages {
+			img, err := loadEPUBImage(page, opts.Resize)
+			if err != nil {
+				// 无法解码的页面（文件损坏）跳过，不中断整个导出
+				log.Printf("[导出] 跳过无法转换的页面: %s, 错误: %v", page, err)
+				continue
+			}
+			index++
+			props := ""
+			if coverID == "img-p0001" && index == 1 {
+				props = "cover-image"
+			}
+			id := fmt.Sprintf("p%04d", index)
+			href, err := ew.addPage(id, fmt.Sprintf("%s %d", title, i+1), img, props)
+			if err != nil {
+				return err
+			}
+			if first {
+				nav = append(nav, epubNavPoint{title: title, href: href})
+				first = false
+			}
+		}
+	}
+
+	if index == 0 {
+		return ErrEmptyExport
+	}
+
+	if err := ew.writeFile("OEBPS/nav.xhtml", []byte(epubNavXHTML(e.Title, nav)), true); err != nil {
+		return err
+	}
+	ew.items = append(ew.items, epubItem{id: "nav", href: "nav.xhtml", mediaType: "application/xhtml+xml", properties: "nav"})
+
+	if err := ew.writeFile("OEBPS/content.opf", []byte(e.epubPackage(ew.items, coverID, opts)), true); err != nil {
+		return err
+	}
+	return ew.zw.Close()
+}
+
+const epubContainerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// epubNavPoint 目录项
+type epubNavPoint struct {
+	title string
+	href  string
+}
+
+// epubNavXHTML 生成导航文档（章节目录）
+func epubNavXHTML(title string, points []epubNavPoint) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>%s</title></head>
+<body>
+<nav epub:type="toc" id="toc">
+<h1>%s</h1>
+<ol>
+`, xmlEscape(title), xmlEscape(title))
+	for _, p := range points {
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", p.href, xmlEscape(p.title))
+	}
+	b.WriteString("</ol>\n</nav>\n</body>\n</html>\n")
+	return b.String()
+}
+
+// epubPackage 生成 content.opf：元数据来自漫画信息，版式为固定版式
+func (e *ComicExport) epubPackage(items []epubItem, coverID string, opts EPUBOptions) string {
+	comic := e.Comic
+	identifier := "urn:pica-comic:" + comic.ID
+	if e.Ep > 0 {
+		identifier = fmt.Sprintf("%s:%d", identifier, e.Ep)
+	}
+	direction := "ltr"
+	if opts.RTL {
+		direction = "rtl"
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&b, "<dc:identifier id=\"bookid\">%s</dc:identifier>\n", xmlEscape(identifier))
+	fmt.Fprintf(&b, "<dc:title>%s</dc:title>\n", xmlEscape(e.Title))
+	b.WriteString("<dc:language>zh</dc:language>\n")
+	if comic.Author != "" {
+		fmt.Fprintf(&b, "<dc:creator>%s</dc:creator>\n", xmlEscape(comic.Author))
+	}
+	if comic.Description != "" {
+		fmt.Fprintf(&b, "<dc:description>%s</dc:description>\n", xmlEscape(comic.Description))
+	}
+	for _, tag := range comic.Tags {
+		fmt.Fprintf(&b, "<dc:subject>%s</dc:subject>\n", xmlEscape(tag))
+	}
+	if comic.DetailURL != "" {
+		fmt.Fprintf(&b, "<dc:source>%s</dc:source>\n", xmlEscape(comic.DetailURL))
+	}
+	fmt.Fprintf(&b, "<meta property=\"dcterms:modified\">%s</meta>\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	b.WriteString(`<meta property="rendition:layout">pre-paginated</meta>
+<meta property="rendition:orientation">portrait</meta>
+<meta property="rendition:spread">none</meta>
+`)
+	// EPUB 2 形式的封面声明，部分阅读器（Kindle、Kobo）依赖它显示书架封面
+	fmt.Fprintf(&b, "<meta name=\"cover\" content=\"%s\"/>\n", coverID)
+	b.WriteString("</metadata>\n<manifest>\n")
+
+	for _, item := range items {
+		fmt.Fprintf(&b, "<item id=\"%s\" href=\"%s\" media-type=\"%s\"", item.id, item.href, item.mediaType)
+		if item.properties != "" {
+			fmt.Fprintf(&b, " properties=\"%s\"", item.properties)
+		}
+		b.WriteString("/>\n")
+	}
+
+	fmt.Fprintf(&b, "</manifest>\n<spine page-progression-direction=\"%s\">\n", direction)
+	for _, item := range items {
+		if item.spine {
+			fmt.Fprintf(&b, "<itemref idref=\"%s\"/>\n", item.id)
+		}
+	}
+	b.WriteString("</spine>\n</package>\n")
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testWebP 一张很小的无损 WebP 图片
+const testWebP = "" +
+	"UklGRrIBAABXRUJQVlA4TKUBAAAvSsAYAA8w//M///MfeJAkbXvaSG7m8Q3GfYSBJekwQztm/IcZlgwnmWImn2BK7aFmBtnV" +
+	"ir6q//8VOkFE/xm4baTIu8c48ArEo6+B3zFKYln3pqClSCKX0begFTAXFOLXHSyF8cCNcZEG4OywuA4KVVfJCiArU7GAgJI8" +
+	"+lJP/OKMT/fBAjevg1cYB7YVkFuWga2lyPi5I0HFy5YTpWIHg0RZpkniRVW9odHAKOwosWuOGdxIyn2OvaCDvhg/we6TwadP" +
+	"BPbqBV58MsLmMJ8yZnOWk8SRz4N+QoyPL+MnamzMvcE1rHNEr91F9GKZPVUcS9w7PhhH36suB9qPeYb/oLk6cuTiJ0wOK3m5" +
+	"h1cKjW6EVZCYMK7dxcKCBdgP9HkKr9gkAO2P8GKZGWVdIAatQa+1IDpt6qyorVwdy01xdW8Jkfk6xjEXmVQQ+HQdFr6OKhIN" +
+	"34dXWq0+0qr6EJSCeeVLH9+gvGTLyqM65PQ44ihzlTXxQKjKbAvshXgir7Lil9w4L2bvMycmjQcqXaMCO6BlY28i+FOLzbfI" +
+	"1vEqxAhotocAAA=="
+
+func TestLoadEPUBImageFormats(t *testing.T) {
+	dir := t.TempDir()
+	webpData, err := base64.StdEncoding.DecodeString(testWebP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "1.webp"), webpData, 0644)
+	// 扩展名与内容不符的 PNG 按内容识别
+	writeTestPNG(t, filepath.Join(dir, "2.bin"), color.RGBA{G: 255, A: 255})
+	writeTestPNG(t, filepath.Join(dir, "3.png"), color.RGBA{B: 255, A: 255})
+	os.WriteFile(filepath.Join(dir, "4.jpg"), []byte("not an image"), 0644)
+
+	tests := []struct {
+		name    string
+		resize  *ResizeOptions
+		wantExt string
+		wantFmt string
+		wantErr bool
+	}{
+		{name: "1.webp", wantExt: ".jpg", wantFmt: "jpeg"},
+		{name: "2.bin", wantExt: ".png", wantFmt: "png"},
+		{name: "3.png", wantExt: ".png", wantFmt: "png"},
+		{name: "3.png", resize: &ResizeOptions{MaxWidth: 20, Format: "jpeg", Quality: 80}, wantExt: ".jpg", wantFmt: "jpeg"},
+		{name: "4.jpg", wantErr: true},
+	}
+	for _, tt := range tests {
+		img, err := loadEPUBImage(PageRef{Path: filepath.Join(dir, tt.name)}, tt.resize)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: 无法解码的图片应返回错误", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if img.ext != tt.wantExt || epubMediaTypes[img.ext] == "" {
+			t.Errorf("%s: ext = %q, want %q", tt.name, img.ext, tt.wantExt)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(img.data))
+		if err != nil || format != tt.wantFmt {
+			t.Errorf("%s: 嵌入的数据格式 = %q (%v), want %q", tt.name, format, err, tt.wantFmt)
+			continue
+		}
+		if cfg.Width != img.width || cfg.Height != img.height {
+			t.Errorf("%s: 记录的尺寸 %dx%d 与图片 %dx%d 不一致", tt.name, img.width, img.height, cfg.Width, cfg.Height)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// PDF 中固定编号的对象，其余对象按写入顺序编号
+const (
+	pdfCatalogObj  = 1
+	pdfPagesObj    = 2
+	pdfInfoObj     = 3
+	pdfOutlinesObj = 4
+	pdfFirstObj    = 5
+)
+
+// pdfWriter 顺序写出 PDF 对象，记录每个对象的偏移用于生成交叉引用表
+type pdfWriter struct {
+	w       io.Writer
+	offset  int64
+	offsets map[int]int64
+	nextObj int
+	err     error
+}
+
+func (pw *pdfWriter) Write(p []byte) (int, error) {
+	if pw.err != nil {
+		return 0, pw.err
+	}
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	pw.err = err
+	return n, err
+}
+
+func (pw *pdfWriter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(pw, format, args...)
+}
+
+// newObj 分配一个对象编号
+func (pw *pdfWriter) newObj() int {
+	n := pw.nextObj
+	pw.nextObj++
+	return n
+}
+
+// beginObj 开始写入对象
+func (pw *pdfWriter) beginObj(n int) {
+	pw.offsets[n] = pw.offset
+	pw.printf("%d 0 obj\n", n)
+}
+
+// writeObj 写入一个字典对象
+func (pw *pdfWriter) writeObj(n int, dict string) {
+	pw.beginObj(n)
+	pw.printf("%s\nendobj\n", dict)
+}
+
+// writeStream 写入一个流对象，数据从 r 中复制，length 必须是实际长度
+func (pw *pdfWriter) writeStream(n int, dict string, length int64, r io.Reader) {
+	pw.beginObj(n)
+	pw.printf("<< %s /Length %d >>\nstream\n", dict, length)
+	if pw.err != nil {
+		return
+	}
+	copied, err := io.Copy(pw, r)
+	if err == nil && copied != length {
+		err = fmt.Errorf("数据长度不一致: %d != %d", copied, length)
+	}
+	if err != nil && pw.err == nil {
+		pw.err = err
+	}
+	pw.printf("\nendstream\nendobj\n")
+}
+
+// finish 写出交叉引用表和文件尾
+func (pw *pdfWriter) finish() error {
+	xref := pw.offset
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", pw.nextObj)
+	for n := 1; n < pw.nextObj; n++ {
+		pw.printf("%010d 00000 n \n", pw.offsets[n])
+	}
+	pw.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		pw.nextObj, pdfCatalogObj, pdfInfoObj, xref)
+	return pw.err
+}
+
+// pdfText 将字符串编码为 PDF 文本（带 BOM 的 UTF-16BE 十六进制串），可包含中文
+func pdfText(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, r := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// pdfImage 准备写入 PDF 的图片：JPEG 原样嵌入，其它格式转为 Flate 压缩的 RGB
+type pdfImage struct {
+	width, height int
+	dict          string // 除 Length 外的图片字典内容
+	length        int64
+	data          io.Reader
+	closer        io.Closer
+}
+
+// loadPDFImage 读取页面图片
+func loadPDFImage(page PageRef) (*pdfImage, error) {
+	rc, size, err := page.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	// JPEG 只读取文件头获取尺寸和颜色空间，数据直接复制，不重新编码
+	var head bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(rc, &head))
+	if err == nil && format == "jpeg" {
+		colorSpace, decode := "/DeviceRGB", ""
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			colorSpace = "/DeviceGray"
+		case color.CMYKModel:
+			colorSpace = "/DeviceCMYK"
+			// 带 Adobe APP14 标记的 CMYK JPEG 是反相存储的
+			if hasAdobeMarker(head.Bytes()) {
+				decode = " /Decode [1 0 1 0 1 0 1 0]"
+			}
+		}
+		return &pdfImage{
+			width:  cfg.Width,
+			height: cfg.Height,
+			dict: fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode%s",
+				cfg.Width, cfg.Height, colorSpace, decode),
+			length: size,
+			data:   io.MultiReader(&head, rc),
+			closer: rc,
+		}, nil
+	}
+
+	defer rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("无法识别的图片格式: %w", err)
+	}
+	img, _, err := image.Decode(io.MultiReader(&head, rc))
+	if err != nil {
+		return nil, fmt.Errorf("解码图片失败: %w", err)
+	}
+	return encodePDFImage(img)
+}
+
+// hasAdobeMarker 检查 JPEG 文件头中在图像数据之前是否有 Adobe APP14 标记段
+func hasAdobeMarker(data []byte) bool {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return false
+	}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return false
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// 填充字节
+			i++
+			continue
+		}
+		// 图像数据（SOS）之后不会再有 APP14
+		if marker == 0xDA {
+			return false
+		}
+		length := int(data[i+2])<<8 | int(data[i+3])
+		if length < 2 {
+			return false
+		}
+		if marker == 0xEE && length >= 7 && i+9 <= len(data) && string(data[i+4:i+9]) == "Adobe" {
+			return true
+		}
+		i += 2 + length
+	}
+	return false
+}
+
+// encodePDFImage 将图片转为 Flate 压缩的 RGB 数据（透明部分铺白底）
+func encodePDFImage(img image.Image) (*pdfImage, error) {
+	b := img.Bounds()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	row := make([]byte, b.Dx()*3)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		fillPDFRow(row, img, y)
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return &pdfImage{
+		width:  b.Dx(),
+		height: b.Dy(),
+		dict: fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode",
+			b.Dx(), b.Dy()),
+		length: int64(buf.Len()),
+		data:   &buf,
+	}, nil
+}
+
+// fillPDFRow 将图片第 y 行转为 RGB 并与白色背景混合；常见的图片类型直接读取像素
+func fillPDFRow(row []byte, img image.Image, y int) {
+	b := img.Bounds()
+	w := b.Dx()
+	switch src := img.(type) {
+	case *image.RGBA:
+		// 预乘 alpha：加上 255-a 即与白色混合
+		pix := src.Pix[src.PixOffset(b.Min.X, y):]
+		for x := 0; x < w; x++ {
+			p := pix[x*4 : x*4+4]
+			bg := 255 - p[3]
+			row[x*3], row[x*3+1], row[x*3+2] = p[0]+bg, p[1]+bg, p[2]+bg
+		}
+	case *image.NRGBA:
+		pix := src.Pix[src.PixOffset(b.Min.X, y):]
+		for x := 0; x < w; x++ {
+			p := pix[x*4 : x*4+4]
+			a := uint32(p[3])
+			bg := 255 * (255 - a)
+			row[x*3] = uint8((uint32(p[0])*a + bg) / 255)
+			row[x*3+1] = uint8((uint32(p[1])*a + bg) / 255)
+			row[x*3+2] = uint8((uint32(p[2])*a + bg) / 255)
+		}
+	case *image.YCbCr:
+		for x := 0; x < w; x++ {
+			yi, ci := src.YOffset(b.Min.X+x, y), src.COffset(b.Min.X+x, y)
+			row[x*3], row[x*3+1], row[x*3+2] = color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+		}
+	default:
+		for x := 0; x < w; x++ {
+			r, g, bl, a := img.At(b.Min.X+x, y).RGBA()
+			// 与白色背景混合
+			row[x*3] = uint8((r + (0xffff - a)) >> 8)
+			row[x*3+1] = uint8((g + (0xffff - a)) >> 8)
+			row[x*3+2] = uint8((bl + (0xffff - a)) >> 8)
+		}
+	}
+}
+
+// pdfOutline 书签（指向章节第一页）
+type pdfOutline struct {
+	title string
+	page  int // 页面对象编号
+}
+
+// WritePDF 将导出内容以 PDF 格式写入 w：每张图片一页，页面大小等于图片原始尺寸
+// 页面边读边写，内存中只保留当前一页；每个章节生成一个书签
+func (e *ComicExport) WritePDF(w io.Writer) error {
+	pw := &pdfWriter{w: w, offsets: make(map[int]int64), nextObj: pdfFirstObj}
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	var pageObjs []int
+	var outlines []pdfOutline
+	for _, episode := range e.Episodes {
+		first := true
+		for _, page := range episode.Pages {
+			img, err := loadPDFImage(page)
+			if err != nil {
+				// 无法解码的页面（文件损坏）跳过，不中断整个导出
+				log.Printf("[导出] 跳过无法转换的页面: %s, 错误: %v", page, err)
+				continue
+			}
+
+			imageObj, contentObj, pageObj := pw.newObj(), pw.newObj(), pw.newObj()
+			pw.writeStream(imageObj, img.dict, img.length, img.data)
+			if img.closer != nil {
+				img.closer.Close()
+			}
+			content := fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", img.width, img.height)
+			pw.writeStream(contentObj, "", int64(len(content)), strings.NewReader(content))
+			pw.writeObj(pageObj, fmt.Sprintf(
+				"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>",
+				pdfPagesObj, img.width, img.height, imageObj, contentObj))
+			if pw.err != nil {
+				return pw.err
+			}
+
+			pageObjs = append(pageObjs, pageObj)
+			if first {
+				title := episode.Title
+				if title == "" {
+					title = e.Title
+				}
+				outlines = append(outlines, pdfOutline{title: title, page: pageObj})
+				first = false
+			}
+		}
+	}
+	if len(pageObjs) == 0 {
+		return ErrEmptyExport
+	}
+
+	// 页面树
+	kids := make([]string, len(pageObjs))
+	for i, obj := range pageObjs {
+		kids[i] = fmt.Sprintf("%d 0 R", obj)
+	}
+	pw.writeObj(pdfPagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pageObjs)))
+
+	// 书签
+	outlineObjs := make([]int, len(outlines))
+	for i := range outlines {
+		outlineObjs[i] = pw.newObj()
+	}
+	for i, o := range outlines {
+		dict := fmt.Sprintf("<< /Title %s /Parent %d 0 R /Dest [%d 0 R /Fit]", pdfText(o.title), pdfOutlinesObj, o.page)
+		if i > 0 {
+			dict += fmt.Sprintf(" /Prev %d 0 R", outlineObjs[i-1])
+		}
+		if i < len(outlines)-1 {
+			dict += fmt.Sprintf(" /Next %d 0 R", outlineObjs[i+1])
+		}
+		pw.writeObj(outlineObjs[i], dict+" >>")
+	}
+	pw.writeObj(pdfOutlinesObj, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>",
+		outlineObjs[0], outlineObjs[len(outlineObjs)-1], len(outlineObjs)))
+
+	// 文档信息
+	info := fmt.Sprintf("<< /Title %s /Producer %s /CreationDate (D:%s)",
+		pdfText(e.Title), pdfText("pica-comic-server"), time.Now().UTC().Format("20060102150405Z"))
+	if e.Comic.Author != "" {
+		info += " /Author " + pdfText(e.Comic.Author)
+	}
+	if e.Comic.Description != "" {
+		info += " /Subject " + pdfText(e.Comic.Description)
+	}
+	if len(e.Comic.Tags) > 0 {
+		info += " /Keywords " + pdfText(strings.Join(e.Comic.Tags, ", "))
+	}
+	pw.writeObj(pdfInfoObj, info+" >>")
+
+	pageMode := "/UseNone"
+	if len(outlines) > 1 {
+		pageMode = "/UseOutlines"
+	}
+	pw.writeObj(pdfCatalogObj, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /Outlines %d 0 R /PageMode %s >>",
+		pdfPagesObj, pdfOutlinesObj, pageMode))
+
+	return pw.finish()
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestHasAdobeMarker(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	app0 := []byte{0xFF, 0xE0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0x00}
+	app14 := []byte{0xFF, 0xEE, 0x00, 0x0E, 'A', 'd', 'o', 'b', 'e', 0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x02}
+	sof := []byte{0xFF, 0xC0, 0x00, 0x05, 0x08, 0x00, 0x01}
+	sos := []byte{0xFF, 0xDA, 0x00, 0x02}
+	join := func(parts ...[]byte) []byte {
+		var out []byte
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"adobe", join(soi, app14, sof, sos), true},
+		{"adobe after other segments", join(soi, app0, sof, app14, sos), true},
+		{"fill bytes", join(soi, []byte{0xFF}, app14), true},
+		{"no adobe", join(soi, app0, sof, sos), false},
+		{"adobe after scan", join(soi, sof, sos, app14), false},
+		{"other app14", join(soi, []byte{0xFF, 0xEE, 0x00, 0x07, 'O', 't', 'h', 'e', 'r'}, sos), false},
+		{"truncated", join(soi, app14[:6]), false},
+		{"not jpeg", app14, false},
+	}
+	for _, tt := range tests {
+		if got := hasAdobeMarker(tt.data); got != tt.want {
+			t.Errorf("%s: hasAdobeMarker = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// genericImage 隐藏具体类型，强制 fillPDFRow 走通用路径
+type genericImage struct{ image.Image }
+
+func TestFillPDFRowFastPaths(t *testing.T) {
+	rect := image.Rect(3, 2, 9, 6)
+	rgba := image.NewRGBA(rect)
+	nrgba := image.NewNRGBA(rect)
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := color.NRGBA{R: uint8(x * 30), G: uint8(y * 40), B: uint8(x * y), A: uint8(x*y*7 + 20)}
+			rgba.Set(x, y, c)
+			nrgba.Set(x, y, c)
+			ycbcr.Y[ycbcr.YOffset(x, y)] = uint8(x * 25)
+			ycbcr.Cb[ycbcr.COffset(x, y)] = uint8(100 + y*10)
+			ycbcr.Cr[ycbcr.COffset(x, y)] = uint8(200 - x*10)
+		}
+	}
+
+	for _, img := range []image.Image{rgba, nrgba, ycbcr} {
+		fast := make([]byte, rect.Dx()*3)
+		generic := make([]byte, rect.Dx()*3)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			fillPDFRow(fast, img, y)
+			fillPDFRow(generic, genericImage{img}, y)
+			for i := range fast {
+				// 允许取整造成的 1 级差异
+				if d := int(fast[i]) - int(generic[i]); d < -1 || d > 1 {
+					t.Fatalf("%T 第 %d 行第 %d 字节: 快速路径 %d, 通用路径 %d", img, y, i, fast[i], generic[i])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"unicode"
+
+	"pica-comic-server/models"
+)
+
+// fullTextEnabled 当前 SQLite 是否支持 FTS5（需使用 -tags sqlite_fts5 编译）
+var fullTextEnabled bool
+
+// 片段高亮时使用的私有区字符，去掉分词插入的零宽空格后再替换为 HTML 标记
+const (
+	markOpen  = "\uE000"
+	markClose = "\uE001"
+)
+
+// SearchResult 全文搜索结果
+type SearchResult struct {
+	Comic          models.ComicDetail `json:"comic"`
+	TitleHighlight string             `json:"title_highlight"` // 高亮后的标题
+	Snippet        string             `json:"snippet"`         // 最匹配字段的高亮片段
+	Rank           float64            `json:"rank"`            // 相关度（越小越相关）
+}
+
+// SearchPage 全文搜索分页结果
+type SearchPage struct {
+	Results  []SearchResult `json:"results"`
+	Total    int            `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	HasMore  bool           `json:"has_more"`
+	FullText bool           `json:"full_text"` // false 表示 FTS5 不可用，已回退为简单匹配
+}
+
+// initFullText 创建全文索引表，索引与漫画库不一致时从现有数据重建
+func (dm *DownloadManager) initFullText() error {
+	_, err := dm.db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS comics_fts USING fts5(
+			comic_id UNINDEXED,
+			title,
+			author,
+			description,
+			tags,
+			eps,
+			tokenize = 'unicode61 remove_diacritics 2'
+		)
+	`)
+	var count int
+	if err == nil {
+		err = dm.db.QueryRow("SELECT COUNT(*) FROM comics_fts").Scan(&count)
+	}
+	if err != nil {
+		log.Printf("[全文搜索] FTS5 不可用，搜索将回退为简单匹配（请使用 -tags sqlite_fts5 编译）: %v", err)
+		fullTextEnabled = false
+		return nil
+	}
+	fullTextEnabled = true
+
+	// 索引数量与漫画库不一致时（如曾用不支持 FTS5 的版本修改过数据）重建索引
+	var libraryCount int
+	if err := dm.db.QueryRow(libraryCTE + " SELECT COUNT(*) FROM library").Scan(&libraryCount); err != nil {
+		return err
+	}
+	if count != libraryCount {
+		return dm.RebuildFullText()
+	}
+	return nil
+}
+
+// RebuildFullText 重建全文索引
+func (dm *DownloadManager) RebuildFullText() error {
+	if !fullTextEnabled {
+		return fmt.Errorf("FTS5 不可用")
+	}
+
+	comics, err := dm.GetAllComics()
+	if err != nil {
+		return err
+	}
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM comics_fts"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for i := range comics {
+		if err := insertComicFTS(tx, &comics[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("[全文搜索] 索引已重建，共 %d 部漫画", len(comics))
+	return nil
+}
+
+// sqlExecer 兼容 *sql.DB 和 *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertComicFTS 写入一条全文索引（调用方负责先删除旧记录）
+func insertComicFTS(db sqlExecer, comic *models.ComicDetail) error {
+	tags := append(append([]string{}, comic.Tags...), comic.Categories...)
+	_, err := db.Exec(`
+		INSERT INTO comics_fts (comic_id, title, author, description, tags, eps)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`,
+		comic.ID,
+		segmentCJK(comic.Title),
+		segmentCJK(comic.Author),
+		segmentCJK(comic.Description),
+		segmentCJK(strings.Join(tags, " ")),
+		segmentCJK(strings.Join(comic.Eps, " ")),
+	)
+	return err
+}
+
+// syncComicFTS 更新一部漫画的全文索引
+func syncComicFTS(db sqlExecer, comic *models.ComicDetail) {
+	if !fullTextEnabled {
+		return
+	}
+	if _, err := db.Exec("DELETE FROM comics_fts WHERE comic_id = ?", comic.ID); err != nil {
+		log.Printf("[全文搜索] 删除旧索引失败: %v", err)
+		return
+	}
+	if err := insertComicFTS(db, comic); err != nil {
+		log.Printf("[全文搜索] 更新索引失败: %v", err)
+	}
+}
+
+// removeComicFTS 删除一部漫画的全文索引
+func removeComicFTS(db sqlExecer, id string) {
+	if !fullTextEnabled {
+		return
+	}
+	if _, err := db.Exec("DELETE FROM comics_fts WHERE comic_id = ?", id); err != nil {
+		log.Printf("[全文搜索] 删除索引失败: %v", err)
+	}
+}
+
+// isCJK 判断是否为中日韩文字（这些文字之间没有空格，需要逐字切分）
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// cjkSeparator 切分中日韩文字时插入的零宽空格，unicode61 分词器将其视为分隔符
+const cjkSeparator = '\u200B'
+
+// segmentCJK 在每个中日韩文字两侧插入零宽空格，使 unicode61 分词器按单字建立索引
+// 文本中的高亮标记字符被去掉，避免伪造高亮
+func segmentCJK(text string) string {
+	var b strings.Builder
+	b.Grow(len(text) * 2)
+	for _, r := range text {
+		if string(r) == markOpen || string(r) == markClose {
+			continue
+		}
+		if isCJK(r) {
+			b.WriteRune(cjkSeparator)
+			b.WriteRune(r)
+			b.WriteRune(cjkSeparator)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// desegmentCJK 去掉 segmentCJK 插入的零宽空格，转义 HTML 后把高亮标记替换为 <mark>
+// 标题和简介可能来自元数据文件或客户端，必须转义后才能作为 HTML 显示
+func desegmentCJK(text string) string {
+	return strings.NewReplacer(
+		string(cjkSeparator), "",
+		markOpen, "<mark>",
+		markClose, "</mark>",
+	).Replace(html.EscapeString(text))
+}
+
+// buildFTSQuery 将用户输入转换为 FTS5 查询：
+// 中日韩文字按单字组成短语，其它词使用前缀匹配，多个词之间为 AND 关系
+func buildFTSQuery(input string) string {
+	var terms []string
+	for _, word := range strings.Fields(input) {
+		// 将一个词拆分为 CJK 段和非 CJK 段
+		var segment []rune
+		segmentIsCJK := false
+		flush := func() {
+			if len(segment) == 0 {
+				return
+			}
+			text := strings.ReplaceAll(string(segment), `"`, `""`)
+			if segmentIsCJK {
+				terms = append(terms, `"`+segmentCJK(text)+`"`)
+			} else if strings.IndexFunc(text, func(r rune) bool {
+				return unicode.IsLetter(r) || unicode.IsNumber(r)
+			}) >= 0 {
+				terms = append(terms, `"`+text+`"*`)
+			}
+			segment = segment[:0]
+		}
+		for _, r := range word {
+			cjk := isCJK(r)
+			if len(segment) > 0 && cjk != segmentIsCJK {
+				flush()
+			}
+			segmentIsCJK = cjk
+			segment = append(segment, r)
+		}
+		flush()
+	}
+	return strings.Join(terms, " ")
+}
+
+// extraScanner 在漫画列之后追加读取额外的列
+type extraScanner struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func (s extraScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// SearchComics 全文搜索漫画库（标题、作者、简介、标签、章节名），按相关度排序
+func (dm *DownloadManager) SearchComics(input string, page, pageSize int) (*SearchPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	result := &SearchPage{Results: []SearchResult{}, Page: page, PageSize: pageSize, FullText: fullTextEnabled}
+
+	if !fullTextEnabled {
+		// 回退为标题/作者的简单匹配
+		libraryPage, err := dm.QueryLibrary(LibraryQuery{Keyword: input, Page: page, PageSize: pageSize})
+		if err != nil {
+			return nil, err
+		}
+		for _, comic := range libraryPage.Comics {
+			result.Results = append(result.Results, SearchResult{Comic: comic, TitleHighlight: html.EscapeString(comic.Title)})
+		}
+		result.Total = libraryPage.Total
+		result.HasMore = libraryPage.HasMore
+		return result, nil
+	}
+
+	match := buildFTSQuery(input)
+	if match == "" {
+		return result, nil
+	}
+
+	from := " FROM comics_fts JOIN library ON library.id = comics_fts.comic_id WHERE comics_fts MATCH ?"
+	if err := dm.db.QueryRow(libraryCTE+" SELECT COUNT(*)"+from, match).Scan(&result.Total); err != nil {
+		return nil, fmt.Errorf("全文搜索失败: %w", err)
+	}
+
+	rows, err := dm.db.Query(libraryCTE+" SELECT "+libraryColumns+`,
+		highlight(comics_fts, 1, ?, ?),
+		snippet(comics_fts, -1, ?, ?, '…', 24),
+		bm25(comics_fts, 0, 10.0, 5.0, 1.0, 3.0, 2.0) AS rank`+
+		from+" ORDER BY rank LIMIT ? OFFSET ?",
+		markOpen, markClose, markOpen, markClose, match, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, fmt.Errorf("全文搜索失败: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var titleHighlight, snippet string
+		var rank float64
+		comic, err := scanComicDetail(extraScanner{row: rows, extra: []interface{}{&titleHighlight, &snippet, &rank}})
+		if err != nil {
+			continue
+		}
+		result.Results = append(result.Results, SearchResult{
+			Comic:          *comic,
+			TitleHighlight: desegmentCJK(titleHighlight),
+			Snippet:        desegmentCJK(snippet),
+			Rank:           rank,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result.HasMore = page*pageSize < result.Total
+	return result, nil
+}
@@ -0,0 +1,23 @@
+package services
+
+import "testing"
+
+func TestDesegmentCJK(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{segmentCJK("漫画"), "漫画"},
+		{markOpen + segmentCJK("漫") + markClose + segmentCJK("画"), "<mark>漫</mark>画"},
+		{`<script>alert(1)</script> ` + markOpen + "abc" + markClose, "&lt;script&gt;alert(1)&lt;/script&gt; <mark>abc</mark>"},
+		{`<img src=x onerror="x()">`, "&lt;img src=x onerror=&#34;x()&#34;&gt;"},
+		{"Tom & Jerry's", "Tom &amp; Jerry&#39;s"},
+		// 文本中原有的标记字符在建立索引时被去掉，不会变成 <mark>
+		{segmentCJK("a" + markOpen + "<b>" + markClose), "a&lt;b&gt;"},
+	}
+	for _, tt := range tests {
+		if got := desegmentCJK(tt.text); got != tt.want {
+			t.Errorf("desegmentCJK(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResizeOptions 页面图片缩放参数
+type ResizeOptions struct {
+	MaxWidth  int    // 最大宽度，0 表示不限制
+	MaxHeight int    // 最大高度，0 表示不限制
+	Format    string // 输出格式：jpeg 或 png
+	Quality   int    // JPEG 质量（1-100）
+}
+
+// cacheKey 生成缓存键：包含源文件路径、大小、修改时间和缩放参数，源文件变化时键随之变化
+func (opts ResizeOptions) cacheKey(src string, size int64, modTime time.Time) string {
+	raw := fmt.Sprintf("%s|%d|%d|%d|%d|%s|%d",
+		src, size, modTime.UnixNano(),
+		opts.MaxWidth, opts.MaxHeight, opts.Format, opts.Quality)
+	sum := sha1.Sum([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// ext 输出文件扩展名
+func (opts ResizeOptions) ext() string {
+	if opts.Format == "png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// cacheEntry LRU 缓存条目
+type cacheEntry struct {
+	key  string
+	path string
+	size int64
+}
+
+// resizeCall 进行中的缩放，同一缓存键的并发请求等待同一次缩放完成
+type resizeCall struct {
+	done chan struct{}
+	err  error
+}
+
+// imageCache 基于磁盘的图片缓存，总大小超过上限时按 LRU 淘汰
+type imageCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	total    int64
+	lru      *list.List               // 队首为最近使用
+	entries  map[string]*list.Element // key -> 条目
+	inflight map[string]*resizeCall   // key -> 进行中的缩放
+}
+
+// newImageCache 创建图片缓存，并加载目录中已有的缓存文件
+func newImageCache(dir string, maxBytes int64) (*imageCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建图片缓存目录失败: %w", err)
+	}
+
+	cache := &imageCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*resizeCall),
+	}
+	cache.load()
+	return cache, nil
+}
+
+// load 加载已有缓存文件，按修改时间恢复 LRU 顺序
+func (ic *imageCache) load() {
+	files, err := os.ReadDir(ic.dir)
+	if err != nil {
+		return
+	}
+
+	type fileItem struct {
+		entry *cacheEntry
+		mtime int64
+	}
+	var items []fileItem
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(ic.dir, name))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		key := strings.TrimSuffix(name, filepath.Ext(name))
+		items = append(items, fileItem{
+			entry: &cacheEntry{key: key, path: filepath.Join(ic.dir, name), size: info.Size()},
+			mtime: info.ModTime().UnixNano(),
+		})
+	}
+
+	// 最近修改的放在队首
+	sort.Slice(items, func(i, j int) bool { return items[i].mtime > items[j].mtime })
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	for _, item := range items {
+		ic.entries[item.entry.key] = ic.lru.PushBack(item.entry)
+		ic.total += item.entry.size
+	}
+	ic.evictLocked()
+}
+
+// open 查找缓存并打开文件，命中时标记为最近使用
+// 文件在持有锁时打开，之后即使条目被淘汰，已打开的文件仍可读取
+func (ic *imageCache) open(key string) (*os.File, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	elem, ok := ic.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	f, err := os.Open(entry.path)
+	if err != nil {
+		// 文件被外部删除
+		ic.lru.Remove(elem)
+		delete(ic.entries, key)
+		ic.total -= entry.size
+		return nil, false
+	}
+	ic.lru.MoveToFront(elem)
+	return f, true
+}
+
+// begin 登记一次缩放；已有同键缩放进行中时返回该缩放和 false，调用方应等待其完成
+func (ic *imageCache) begin(key string) (*resizeCall, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if call, ok := ic.inflight[key]; ok {
+		return call, false
+	}
+	call := &resizeCall{done: make(chan struct{})}
+	ic.inflight[key] = call
+	return call, true
+}
+
+// finish 结束缩放并唤醒等待者
+func (ic *imageCache) finish(key string, call *resizeCall, err error) {
+	ic.mu.Lock()
+	delete(ic.inflight, key)
+	ic.mu.Unlock()
+	call.err = err
+	close(call.done)
+}
+
+// add 登记新写入的缓存文件并打开，再淘汰超出上限的旧条目
+// 新条目位于队首，淘汰不会删除刚写入的文件
+func (ic *imageCache) add(key, path string, size int64) (*os.File, error) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if elem, ok := ic.entries[key]; ok {
+		old := elem.Value.(*cacheEntry)
+		ic.total -= old.size
+		old.size = size
+		old.path = path
+		ic.total += size
+		ic.lru.MoveToFront(elem)
+	} else {
+		ic.entries[key] = ic.lru.PushFront(&cacheEntry{key: key, path: path, size: size})
+		ic.total += size
+	}
+	ic.evictLocked()
+	return f, nil
+}
+
+// evictLocked 淘汰最久未使用的条目，直到总大小不超过上限（调用方需持有锁）
+func (ic *imageCache) evictLocked() {
+	for ic.total > ic.maxBytes && ic.lru.Len() > 1 {
+		elem := ic.lru.Back()
+		entry := elem.Value.(*cacheEntry)
+		ic.lru.Remove(elem)
+		delete(ic.entries, entry.key)
+		ic.total -= entry.size
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[图片缓存] 删除缓存文件失败: %v", err)
+		}
+	}
+}
+
+// SetImageCacheSize 设置缩放图片缓存的大小上限（字节）
+func (dm *DownloadManager) SetImageCacheSize(maxBytes int64) {
+	if dm.imageCache == nil {
+		return
+	}
+	dm.imageCache.mu.Lock()
+	dm.imageCache.maxBytes = maxBytes
+	dm.imageCache.evictLocked()
+	dm.imageCache.mu.Unlock()
+}
+
+// GetResizedImage 获取缩放/转码后的页面图片，返回已打开的缓存文件和缓存键（可用作 ETag），调用方负责关闭文件
+// 同一缓存键的并发请求只缩放一次
+func (dm *DownloadManager) GetResizedImage(src PageRef, opts ResizeOptions) (*os.File, string, error) {
+	size, modTime, err := src.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := opts.cacheKey(src.String(), size, modTime)
+	for {
+		if f, ok := dm.imageCache.open(key); ok {
+			return f, key, nil
+		}
+
+		call, leader := dm.imageCache.begin(key)
+		if !leader {
+			<-call.done
+			if call.err != nil {
+				return nil, "", call.err
+			}
+			// 缩放完成后重新查找缓存（极少数情况下已被淘汰，则重新缩放）
+			continue
+		}
+
+		f, err := dm.resizeToCache(src, opts, key)
+		dm.imageCache.finish(key, call, err)
+		if err != nil {
+			return nil, "", err
+		}
+		return f, key, nil
+	}
+}
+
+// resizeToCache 解码并缩放页面，写入缓存后返回已打开的缓存文件
+func (dm *DownloadManager) resizeToCache(src PageRef, opts ResizeOptions, key string) (*os.File, error) {
+	img, err := src.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("解码图片失败: %w", err)
+	}
+
+	b := img.Bounds()
+	w, h := fitSize(b.Dx(), b.Dy(), opts.MaxWidth, opts.MaxHeight)
+	resized := resizeImage(img, w, h)
+
+	cachePath := filepath.Join(dm.imageCache.dir, key+opts.ext())
+	if err := writeImageFile(cachePath, resized, opts.Format, opts.Quality); err != nil {
+		return nil, fmt.Errorf("写入图片缓存失败: %w", err)
+	}
+
+	cacheInfo, err := os.Stat(cachePath)
+	if err != nil {
+		return nil, fmt.Errorf("写入图片缓存失败: %w", err)
+	}
+	f, err := dm.imageCache.add(key, cachePath, cacheInfo.Size())
+	if err != nil {
+		return nil, fmt.Errorf("打开图片缓存失败: %w", err)
+	}
+	return f, nil
+}
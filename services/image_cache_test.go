@@ -0,0 +1,144 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writeTestPNG 写入一张纯色 PNG 测试图片
+func writeTestPNG(t *testing.T, path string, c color.Color) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestImageCache(t *testing.T, dm *DownloadManager, maxBytes int64) {
+	t.Helper()
+	cache, err := newImageCache(filepath.Join(t.TempDir(), "cache"), maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm.imageCache = cache
+}
+
+func TestGetResizedImageConcurrent(t *testing.T) {
+	dm := newTestManager(t)
+	newTestImageCache(t, dm, 1<<20)
+	src := filepath.Join(t.TempDir(), "1.png")
+	writeTestPNG(t, src, color.RGBA{R: 255, A: 255})
+	opts := ResizeOptions{MaxWidth: 20, Format: "jpeg", Quality: 80}
+
+	const n = 8
+	var wg sync.WaitGroup
+	keys := make([]string, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f, key, err := dm.GetResizedImage(PageRef{Path: src}, opts)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer f.Close()
+			keys[i] = key
+			if _, err := io.ReadAll(f); err != nil {
+				errs[i] = err
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("GetResizedImage 失败: %v", errs[i])
+		}
+		if keys[i] != keys[0] {
+			t.Errorf("缓存键不一致: %s != %s", keys[i], keys[0])
+		}
+	}
+	files, _ := os.ReadDir(dm.imageCache.dir)
+	if len(files) != 1 {
+		t.Errorf("缓存目录中有 %d 个文件，期望 1 个", len(files))
+	}
+	if len(dm.imageCache.inflight) != 0 {
+		t.Errorf("缩放结束后仍有 %d 个进行中的记录", len(dm.imageCache.inflight))
+	}
+}
+
+func TestImageCacheBeginDedupes(t *testing.T) {
+	cache, err := newImageCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, leader := cache.begin("k")
+	if !leader {
+		t.Fatal("第一次 begin 应负责缩放")
+	}
+	waiter, leader := cache.begin("k")
+	if leader || waiter != call {
+		t.Fatal("同键的第二次 begin 应等待进行中的缩放")
+	}
+	cache.finish("k", call, nil)
+	<-waiter.done
+	if _, leader := cache.begin("k"); !leader {
+		t.Error("缩放结束后 begin 应重新负责缩放")
+	}
+}
+
+func TestResizedFileSurvivesEviction(t *testing.T) {
+	dm := newTestManager(t)
+	// 上限极小，每次写入新条目都会淘汰旧条目
+	newTestImageCache(t, dm, 1)
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "1.png"), filepath.Join(dir, "2.png")
+	writeTestPNG(t, first, color.RGBA{R: 255, A: 255})
+	writeTestPNG(t, second, color.RGBA{B: 255, A: 255})
+	opts := ResizeOptions{MaxWidth: 20, Format: "png"}
+
+	f1, _, err := dm.GetResizedImage(PageRef{Path: first}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	want, err := os.ReadFile(f1.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f2, _, err := dm.GetResizedImage(PageRef{Path: second}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2.Close()
+
+	if _, err := os.Stat(f1.Name()); !os.IsNotExist(err) {
+		t.Fatalf("第一个缓存文件应已被淘汰，err = %v", err)
+	}
+	got, err := io.ReadAll(f1)
+	if err != nil {
+		t.Fatalf("读取已淘汰的缓存文件失败: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Error("已打开的缓存文件内容被淘汰破坏")
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // 支持 GIF 格式
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/webp" // 支持 WebP 格式
+)
+
+// pageImageExts 视为漫画页面的图片扩展名
+var pageImageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".webp": true,
+	".gif":  true,
+}
+
+// isCoverFile 判断文件名是否为封面文件
+func isCoverFile(name string) bool {
+	base := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+	return base == "cover"
+}
+
+// isPageImage 判断文件名是否为漫画页面图片（排除封面和隐藏文件）
+func isPageImage(name string) bool {
+	if strings.HasPrefix(name, ".") || isCoverFile(name) {
+		return false
+	}
+	return pageImageExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// decodeImageFile 读取并解码图片文件
+func decodeImageFile(path string) (image.Image, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	return image.Decode(f)
+}
+
+// encodeImage 按格式编码图片，format 为 "png" 时输出 PNG，其余输出 JPEG
+func encodeImage(w io.Writer, img image.Image, format string, quality int) error {
+	if format == "png" {
+		return png.Encode(w, img)
+	}
+	if quality <= 0 || quality > 100 {
+		quality = 85
+	}
+	// JPEG 不支持透明通道，透明部分铺白底，避免变成黑色
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		bg := image.NewRGBA(img.Bounds())
+		draw.Draw(bg, bg.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(bg, bg.Bounds(), img, img.Bounds().Min, draw.Over)
+		img = bg
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// writeImageFile 将图片编码写入文件（先写临时文件再重命名，避免读到半个文件）
+func writeImageFile(path string, img image.Image, format string, quality int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := out.Name()
+	out.Chmod(0644)
+	if err := encodeImage(out, img, format, quality); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// fitSize 计算等比缩放到 maxW x maxH 以内的尺寸（0 表示不限制，不放大）
+func fitSize(w, h, maxW, maxH int) (int, int) {
+	scale := 1.0
+	if maxW > 0 && w > maxW {
+		scale = float64(maxW) / float64(w)
+	}
+	if maxH > 0 && h > maxH {
+		if s := float64(maxH) / float64(h); s < scale {
+			scale = s
+		}
+	}
+	nw := int(float64(w)*scale + 0.5)
+	nh := int(float64(h)*scale + 0.5)
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+	return nw, nh
+}
+
+// cropToAspect 按目标宽高比居中裁剪
+func cropToAspect(img image.Image, aspectW, aspectH int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	cropW, cropH := w, h
+	if w*aspectH > h*aspectW {
+		cropW = h * aspectW / aspectH
+	} else {
+		cropH = w * aspectH / aspectW
+	}
+	if cropW == w && cropH == h {
+		return img
+	}
+
+	x0 := b.Min.X + (w-cropW)/2
+	y0 := b.Min.Y + (h-cropH)/2
+	rect := image.Rect(x0, y0, x0+cropW, y0+cropH)
+
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, cropW, cropH))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+// resizeImage 将图片缩放到指定尺寸
+// 缩小时使用区域平均采样，画质明显好于最近邻，且不依赖第三方库
+func resizeImage(img image.Image, dstW, dstH int) image.Image {
+	b := img.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+	if srcW == dstW && srcH == dstH {
+		return img
+	}
+
+	// 统一转换为 RGBA，便于直接访问像素
+	src, ok := img.(*image.RGBA)
+	if !ok || src.Bounds().Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	xRatio := float64(srcW) / float64(dstW)
+	yRatio := float64(srcH) / float64(dstH)
+
+	for dy := 0; dy < dstH; dy++ {
+		sy0 := int(float64(dy) * yRatio)
+		sy1 := int(float64(dy+1) * yRatio)
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		if sy1 > srcH {
+			sy1 = srcH
+		}
+		for dx := 0; dx < dstW; dx++ {
+			sx0 := int(float64(dx) * xRatio)
+			sx1 := int(float64(dx+1) * xRatio)
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+			if sx1 > srcW {
+				sx1 = srcW
+			}
+
+			var r, g, bl, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				off := sy*src.Stride + sx0*4
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint32(src.Pix[off])
+					g += uint32(src.Pix[off+1])
+					bl += uint32(src.Pix[off+2])
+					a += uint32(src.Pix[off+3])
+					off += 4
+					n++
+				}
+			}
+			dst.SetRGBA(dx, dy, color.RGBA{
+				R: uint8(r / n),
+				G: uint8(g / n),
+				B: uint8(bl / n),
+				A: uint8(a / n),
+			})
+		}
+	}
+
+	return dst
+}
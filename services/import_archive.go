@@ -0,0 +1,468 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidImportArchive 上传的压缩包格式或结构无法识别
+	ErrInvalidImportArchive = errors.New("无法识别的压缩包")
+	// ErrImportTooLarge 压缩包解压后的大小或条目数超出上限
+	ErrImportTooLarge = errors.New("压缩包内容超出导入上限")
+)
+
+const (
+	// maxImportSize 导入压缩包解压后的总大小上限
+	maxImportSize int64 = 32 << 30
+	// maxImportEntries 导入压缩包中的条目数上限
+	maxImportEntries = 100000
+)
+
+// 导入压缩包的目录结构
+const (
+	ImportLayoutClient   = "client"   // 客户端命名：ep1_page001.jpg
+	ImportLayoutEpisodes = "episodes" // 每个章节一个文件夹
+	ImportLayoutFlat     = "flat"     // 所有页面在同一层，作为第 1 话
+)
+
+// ImportResult 压缩包导入结果
+type ImportResult struct {
+	ComicID    string `json:"comic_id"`
+	Directory  string `json:"directory"`
+	Layout     string `json:"layout"`
+	EpsCount   int    `json:"eps_count"`
+	PagesCount int    `json:"pages_count"`
+
+	Duplicate *DuplicateReport `json:"duplicate,omitempty"` // 重复检测及处理结果
+}
+
+// importStagingDir 解压导入文件的临时目录（位于下载目录中，便于直接移动文件）
+func (dm *DownloadManager) importStagingDir() string {
+	return filepath.Join(dm.downloadPath, ".cache", "imports")
+}
+
+// cleanArchivePath 规范化压缩包中的路径，拒绝绝对路径和 ..，忽略隐藏文件和 macOS 元数据
+func cleanArchivePath(name string) (string, bool) {
+	name = strings.TrimPrefix(strings.ReplaceAll(name, "\\", "/"), "./")
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, "/"), "/")
+	for _, part := range parts {
+		if part == "" || part == ".." || strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return "", false
+		}
+	}
+	return strings.Join(parts, "/"), true
+}
+
+// archiveStager 将压缩包中的图片写入临时目录：限制解压的总大小和条目数，清理后同名的条目只保留第一个
+type archiveStager struct {
+	dir     string
+	files   []string // 已写入的文件（相对路径）
+	seen    map[string]bool
+	bytes   int64 // 剩余可写入的字节数
+	entries int   // 剩余可处理的条目数
+	full    error // 超出大小上限时返回的错误
+}
+
+// newArchiveStager 创建写入 dir 的解压器，最多写入 maxBytes 字节
+func newArchiveStager(dir string, maxBytes int64) *archiveStager {
+	return &archiveStager{
+		dir:     dir,
+		seen:    make(map[string]bool),
+		bytes:   maxBytes,
+		entries: maxImportEntries,
+		full:    fmt.Errorf("%w: 解压后超过 %d MB", ErrImportTooLarge, maxBytes>>20),
+	}
+}
+
+// newImportStager 按导入上限和磁盘剩余空间（保留 minDiskSpace）创建解压器
+func (dm *DownloadManager) newImportStager(dir string) (*archiveStager, error) {
+	if err := dm.ensureDiskSpace(); err != nil {
+		return nil, err
+	}
+	s := newArchiveStager(dir, maxImportSize)
+	if free, ok := diskFreeSpace(dm.downloadPath); ok && free-dm.minDiskSpace < s.bytes {
+		s.bytes = free - dm.minDiskSpace
+		s.full = fmt.Errorf("%w: 解压需要的空间超过剩余的 %d MB", ErrInsufficientDiskSpace, s.bytes>>20)
+	}
+	return s, nil
+}
+
+// countEntry 计入一个条目（包括被忽略的条目），超出上限时返回错误
+func (s *archiveStager) countEntry() error {
+	if s.entries--; s.entries < 0 {
+		return fmt.Errorf("%w: 条目超过 %d 个", ErrImportTooLarge, maxImportEntries)
+	}
+	return nil
+}
+
+// accept 判断条目是否需要解压，返回清理后的相对路径
+func (s *archiveStager) accept(name string) (string, bool) {
+	clean, ok := cleanArchivePath(name)
+	// 只保留图片（页面和封面）
+	if !ok || !pageImageExts[strings.ToLower(path.Ext(clean))] || s.seen[clean] {
+		return "", false
+	}
+	return clean, true
+}
+
+// stageFile 将一个条目写入临时目录
+func (s *archiveStager) stageFile(name string, r io.Reader) error {
+	clean, ok := s.accept(name)
+	if !ok {
+		return nil
+	}
+	dst := filepath.Join(s.dir, filepath.FromSlash(clean))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	// 多读一个字节以判断是否超出上限
+	n, err := io.CopyN(out, r, s.bytes+1)
+	if err != nil && err != io.EOF {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if n > s.bytes {
+		return s.full
+	}
+	s.bytes -= n
+	s.seen[clean] = true
+	s.files = append(s.files, clean)
+	return nil
+}
+
+// unpackZip 解压 ZIP/CBZ 中的图片到临时目录，解压前按条目记录的大小检查上限
+func unpackZip(ra io.ReaderAt, size int64, s *archiveStager) ([]string, error) {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportArchive, err)
+	}
+	if len(zr.File) > s.entries {
+		return nil, fmt.Errorf("%w: 条目超过 %d 个", ErrImportTooLarge, maxImportEntries)
+	}
+	var total uint64
+	for _, f := range zr.File {
+		if _, ok := s.accept(f.Name); ok && !f.FileInfo().IsDir() {
+			total += f.UncompressedSize64
+		}
+	}
+	if total > uint64(s.bytes) {
+		return nil, s.full
+	}
+
+	for _, f := range zr.File {
+		if err := s.countEntry(); err != nil {
+			return nil, err
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = s.stageFile(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.files, nil
+}
+
+// unpackTar 边读边解压 tar 流中的图片到临时目录
+func unpackTar(r io.Reader, s *archiveStager) ([]string, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImportArchive, err)
+		}
+		if err := s.countEntry(); err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := s.stageFile(hdr.Name, tr); err != nil {
+			return nil, err
+		}
+	}
+	return s.files, nil
+}
+
+// importEpisode 识别出的一个章节
+type importEpisode struct {
+	ep    int
+	title string
+	pages []string // 临时目录中的相对路径，按页码顺序
+}
+
+// stripCommonDir 去掉所有文件共同的顶层目录（常见于打包整个漫画文件夹的压缩包）
+func stripCommonDir(files []string) (string, []string) {
+	prefix := ""
+	for {
+		var top string
+		for i, f := range files {
+			dir, _, found := strings.Cut(f, "/")
+			if !found || (i > 0 && dir != top) {
+				return prefix, files
+			}
+			top = dir
+		}
+		if len(files) == 0 {
+			return prefix, files
+		}
+		stripped := make([]string, len(files))
+		for i, f := range files {
+			stripped[i] = strings.TrimPrefix(f, top+"/")
+		}
+		prefix += top + "/"
+		files = stripped
+	}
+}
+
+// detectImportLayout 识别压缩包的目录结构，返回章节列表和根部的封面文件
+func detectImportLayout(files []string) (string, []importEpisode, string, error) {
+	prefix, rel := stripCommonDir(files)
+
+	var cover string
+	var pages []string
+	for _, f := range rel {
+		if !strings.Contains(f, "/") && isCoverFile(f) {
+			cover = prefix + f
+			continue
+		}
+		if isPageImage(path.Base(f)) {
+			pages = append(pages, f)
+		}
+	}
+	if len(pages) == 0 {
+		return "", nil, "", fmt.Errorf("%w: 没有找到页面图片", ErrInvalidImportArchive)
+	}
+
+	// 客户端命名：ep1_page001.jpg（可以位于任意目录中）
+	client := make(map[int][]string)
+	pageNums := make(map[string]int)
+	for _, f := range pages {
+		var epNum, pageNum int
+		base := path.Base(f)
+		if n, err := fmt.Sscanf(strings.TrimSuffix(base, path.Ext(base)), "ep%d_page%d", &epNum, &pageNum); err != nil || n != 2 {
+			client = nil
+			break
+		}
+		if epNum < 1 {
+			return "", nil, "", fmt.Errorf("%w: 无效的章节号 %d（%s）", ErrInvalidImportArchive, epNum, base)
+		}
+		client[epNum] = append(client[epNum], prefix+f)
+		pageNums[prefix+f] = pageNum
+	}
+	if client != nil {
+		var episodes []importEpisode
+		for ep, list := range client {
+			sort.Slice(list, func(i, j int) bool { return pageNums[list[i]] < pageNums[list[j]] })
+			episodes = append(episodes, importEpisode{ep: ep, pages: list})
+		}
+		sort.Slice(episodes, func(i, j int) bool { return episodes[i].ep < episodes[j].ep })
+		return ImportLayoutClient, episodes, cover, nil
+	}
+
+	// 所有页面在同一层
+	flat := true
+	for _, f := range pages {
+		if strings.Contains(f, "/") {
+			flat = false
+			break
+		}
+	}
+	if flat {
+		sortNatural(pages)
+		for i := range pages {
+			pages[i] = prefix + pages[i]
+		}
+		return ImportLayoutFlat, []importEpisode{{ep: 1, pages: pages}}, cover, nil
+	}
+
+	// 每个章节一个文件夹：页面必须都在第一层子目录中
+	byDir := make(map[string][]string)
+	for _, f := range pages {
+		dir, name, _ := strings.Cut(f, "/")
+		if name == "" || strings.Contains(name, "/") || dir == f {
+			return "", nil, "", fmt.Errorf("%w: 页面需要全部在同一层，或每个章节一个文件夹", ErrInvalidImportArchive)
+		}
+		byDir[dir] = append(byDir[dir], prefix+f)
+	}
+	dirs := make([]string, 0, len(byDir))
+	numeric := true
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+		if n, err := strconv.Atoi(dir); err != nil || n <= 0 {
+			numeric = false
+		}
+	}
+	if numeric {
+		sort.Slice(dirs, func(i, j int) bool {
+			a, _ := strconv.Atoi(dirs[i])
+			b, _ := strconv.Atoi(dirs[j])
+			return a < b
+		})
+	} else {
+		sortNatural(dirs)
+	}
+
+	episodes := make([]importEpisode, 0, len(dirs))
+	for i, dir := range dirs {
+		list := byDir[dir]
+		sortNatural(list)
+		episode := importEpisode{ep: i + 1, title: dir, pages: list}
+		if numeric {
+			episode.ep, _ = strconv.Atoi(dir)
+			episode.title = ""
+		}
+		episodes = append(episodes, episode)
+	}
+	return ImportLayoutEpisodes, episodes, cover, nil
+}
+
+// ImportComicArchive 从单个压缩包导入漫画：支持 ZIP/CBZ、tar 和 tar.gz，自动识别目录结构，
+// 解压为标准的章节目录（1/001.jpg ...）后写入数据库，元数据与逐页上传相同
+// size 为压缩包大小（未知时为 -1）；ZIP 需要随机读取，src 不支持 io.ReaderAt 时先写入临时文件
+func (dm *DownloadManager) ImportComicArchive(src io.Reader, size int64, meta ImportMetadata) (*ImportResult, error) {
+	if err := os.MkdirAll(dm.importStagingDir(), 0755); err != nil {
+		return nil, err
+	}
+	stagingDir, err := os.MkdirTemp(dm.importStagingDir(), "import-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stagingDir)
+	stager, err := dm.newImportStager(stagingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(src, 4096)
+	head, _ := br.Peek(512)
+
+	var files []string
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		ra, ok := src.(io.ReaderAt)
+		if !ok || size < 0 {
+			// 先写入临时文件
+			tmp, err := os.CreateTemp(dm.importStagingDir(), "upload-*.zip")
+			if err != nil {
+				return nil, err
+			}
+			defer os.Remove(tmp.Name())
+			defer tmp.Close()
+			if size, err = io.Copy(tmp, io.LimitReader(br, stager.bytes+1)); err != nil {
+				return nil, fmt.Errorf("接收上传文件失败: %w", err)
+			}
+			if size > stager.bytes {
+				return nil, stager.full
+			}
+			ra = tmp
+		}
+		files, err = unpackZip(ra, size, stager)
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		gz, gzErr := gzip.NewReader(br)
+		if gzErr != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImportArchive, gzErr)
+		}
+		defer gz.Close()
+		files, err = unpackTar(gz, stager)
+	case len(head) > 262 && string(head[257:262]) == "ustar":
+		files, err = unpackTar(br, stager)
+	default:
+		return nil, fmt.Errorf("%w: 只支持 ZIP、CBZ、tar 和 tar.gz", ErrInvalidImportArchive)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	layout, episodes, cover, err := detectImportLayout(files)
+	if err != nil {
+		return nil, err
+	}
+
+	// 移动到标准目录结构
+	folderName, err := dm.uniqueFolderName(sanitizeFolderName(meta.Title))
+	if err != nil {
+		return nil, err
+	}
+	comicDir := filepath.Join(dm.downloadPath, folderName)
+	if err := os.MkdirAll(comicDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建目录失败: %w", err)
+	}
+	moveStaged := func(rel, dst string) error {
+		return os.Rename(filepath.Join(stagingDir, filepath.FromSlash(rel)), dst)
+	}
+
+	if cover != "" {
+		if err := moveStaged(cover, filepath.Join(comicDir, "cover"+strings.ToLower(path.Ext(cover)))); err != nil {
+			os.RemoveAll(comicDir)
+			return nil, err
+		}
+	}
+
+	var epOrders []int
+	var epTitles []string
+	totalPages := 0
+	for _, episode := range episodes {
+		epDir := filepath.Join(comicDir, strconv.Itoa(episode.ep))
+		if err := os.MkdirAll(epDir, 0755); err != nil {
+			os.RemoveAll(comicDir)
+			return nil, fmt.Errorf("创建章节目录失败: %w", err)
+		}
+		for i, page := range episode.pages {
+			dst := filepath.Join(epDir, fmt.Sprintf("%03d%s", i+1, strings.ToLower(path.Ext(page))))
+			if err := moveStaged(page, dst); err != nil {
+				os.RemoveAll(comicDir)
+				return nil, fmt.Errorf("保存图片失败: %w", err)
+			}
+		}
+		epOrders = append(epOrders, episode.ep)
+		epTitles = append(epTitles, episode.title)
+		totalPages += len(episode.pages)
+	}
+
+	report, err := dm.saveImportedComic(meta, folderName, epOrders, epTitles, totalPages)
+	if err != nil {
+		os.RemoveAll(comicDir)
+		return nil, err
+	}
+	return &ImportResult{
+		ComicID:    report.ComicID,
+		Directory:  report.Directory,
+		Layout:     layout,
+		EpsCount:   len(epOrders),
+		PagesCount: totalPages,
+		Duplicate:  report,
+	}, nil
+}
@@ -0,0 +1,381 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCleanArchivePath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"001.jpg", "001.jpg", true},
+		{"./ch1/001.jpg", "ch1/001.jpg", true},
+		{`ch1\001.jpg`, "ch1/001.jpg", true},
+		{"漫画/第1话/001.jpg", "漫画/第1话/001.jpg", true},
+		{"ch1/", "ch1", true},
+		{"..jpg", "", false}, // 以 . 开头视为隐藏文件
+		{"", "", false},
+		{"/etc/passwd", "", false},
+		{`\abs\001.jpg`, "", false},
+		{"../evil.jpg", "", false},
+		{"ch1/../../evil.jpg", "", false},
+		{`ch1\..\..\evil.jpg`, "", false},
+		{"ch1/..", "", false},
+		{"ch1//001.jpg", "", false},
+		{"__MACOSX/ch1/._001.jpg", "", false},
+		{"ch1/__MACOSX/001.jpg", "", false},
+		{"ch1/.DS_Store", "", false},
+		{".hidden/001.jpg", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := cleanArchivePath(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("cleanArchivePath(%q) = %q, %v; want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// hostileEntries 不应被解压的条目，以及唯一应当保留的页面
+var hostileEntries = []string{
+	"../evil.jpg",
+	"ch1/../../evil.jpg",
+	"/tmp/abs-evil.jpg",
+	"__MACOSX/ch1/._001.jpg",
+	"ch1/.hidden.jpg",
+	"ch1/notes.txt",
+	"ch1/001.jpg",
+}
+
+func assertStagedOnlyPage(t *testing.T, root, staging string, files []string) {
+	t.Helper()
+	if !reflect.DeepEqual(files, []string{"ch1/001.jpg"}) {
+		t.Errorf("staged files = %v, want [ch1/001.jpg]", files)
+	}
+	if _, err := os.Stat(filepath.Join(root, "evil.jpg")); !os.IsNotExist(err) {
+		t.Error("entry with .. was written outside the staging directory")
+	}
+	var written []string
+	filepath.Walk(staging, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(staging, p)
+			written = append(written, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if !reflect.DeepEqual(written, []string{"ch1/001.jpg"}) {
+		t.Errorf("files in staging directory = %v", written)
+	}
+}
+
+func TestUnpackZipRejectsUnsafePaths(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range hostileEntries {
+		// CreateHeader 不会清理名称，可以写入任意路径
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("data"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	staging := filepath.Join(root, "staging")
+	os.MkdirAll(staging, 0755)
+	files, err := unpackZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), newArchiveStager(staging, maxImportSize))
+	if err != nil {
+		t.Fatalf("unpackZip: %v", err)
+	}
+	assertStagedOnlyPage(t, root, staging, files)
+}
+
+func TestUnpackTarRejectsUnsafePaths(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range hostileEntries {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 4, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte("data"))
+	}
+	// 符号链接和硬链接条目不解压
+	tw.WriteHeader(&tar.Header{Name: "ch1/002.jpg", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink})
+	tw.WriteHeader(&tar.Header{Name: "ch1/003.jpg", Linkname: "../evil.jpg", Typeflag: tar.TypeLink})
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	staging := filepath.Join(root, "staging")
+	os.MkdirAll(staging, 0755)
+	files, err := unpackTar(&buf, newArchiveStager(staging, maxImportSize))
+	if err != nil {
+		t.Fatalf("unpackTar: %v", err)
+	}
+	assertStagedOnlyPage(t, root, staging, files)
+}
+
+// archiveEntry 测试压缩包中的一个条目
+type archiveEntry struct {
+	name string
+	data string
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func buildTar(t *testing.T, entries []archiveEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.data))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestUnpackLimits(t *testing.T) {
+	page := strings.Repeat("x", 100)
+	tests := []struct {
+		name     string
+		entries  []archiveEntry
+		maxBytes int64
+		maxCount int
+		want     []string // 解压出的文件，为空时期望 ErrImportTooLarge
+	}{
+		{
+			name:     "duplicate cleaned names keep the first entry",
+			entries:  []archiveEntry{{"a/1.jpg", "first"}, {"./a/1.jpg", "second"}, {`a\1.jpg`, "third"}, {"a/2.jpg", page}},
+			maxBytes: maxImportSize,
+			want:     []string{"a/1.jpg", "a/2.jpg"},
+		},
+		{
+			name:     "skipped entries do not count towards the size limit",
+			entries:  []archiveEntry{{"notes.txt", page + page}, {"1.jpg", page}},
+			maxBytes: 100,
+			want:     []string{"1.jpg"},
+		},
+		{
+			name:     "total size over the limit",
+			entries:  []archiveEntry{{"1.jpg", page}, {"2.jpg", page}},
+			maxBytes: 150,
+		},
+		{
+			name:     "too many entries including skipped ones",
+			entries:  []archiveEntry{{"1.jpg", "x"}, {"a.txt", "x"}, {"b.txt", "x"}},
+			maxBytes: maxImportSize,
+			maxCount: 2,
+		},
+	}
+	for _, tt := range tests {
+		for _, format := range []string{"zip", "tar"} {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				staging := t.TempDir()
+				s := newArchiveStager(staging, tt.maxBytes)
+				if tt.maxCount > 0 {
+					s.entries = tt.maxCount
+				}
+				var files []string
+				var err error
+				if format == "zip" {
+					r := buildZip(t, tt.entries)
+					files, err = unpackZip(r, r.Size(), s)
+				} else {
+					files, err = unpackTar(buildTar(t, tt.entries), s)
+				}
+				if tt.want == nil {
+					if !errors.Is(err, ErrImportTooLarge) {
+						t.Fatalf("error = %v, want ErrImportTooLarge", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(files, tt.want) {
+					t.Errorf("files = %v, want %v", files, tt.want)
+				}
+				if data, _ := os.ReadFile(filepath.Join(staging, "a", "1.jpg")); len(data) > 0 && string(data) != "first" {
+					t.Errorf("a/1.jpg = %q, want the first entry", data)
+				}
+			})
+		}
+	}
+}
+
+func TestUnpackZipChecksDeclaredSize(t *testing.T) {
+	// 条目记录的解压后大小超出上限时，不解压任何文件
+	r := buildZip(t, []archiveEntry{{"1.jpg", strings.Repeat("x", 1000)}, {"2.jpg", "x"}})
+	staging := t.TempDir()
+	if _, err := unpackZip(r, r.Size(), newArchiveStager(staging, 500)); !errors.Is(err, ErrImportTooLarge) {
+		t.Fatalf("error = %v, want ErrImportTooLarge", err)
+	}
+	if entries, _ := os.ReadDir(staging); len(entries) != 0 {
+		t.Errorf("staged %d files before rejecting the archive", len(entries))
+	}
+}
+
+func TestDetectImportLayout(t *testing.T) {
+	type episode struct {
+		Ep    int
+		Title string
+		Pages []string
+	}
+	tests := []struct {
+		name     string
+		files    []string
+		layout   string
+		cover    string
+		episodes []episode
+		err      bool
+	}{
+		{
+			name:   "client naming",
+			files:  []string{"ep2_page001.jpg", "ep1_page002.png", "ep1_page001.jpg", "cover.jpg"},
+			layout: ImportLayoutClient,
+			cover:  "cover.jpg",
+			episodes: []episode{
+				{1, "", []string{"ep1_page001.jpg", "ep1_page002.png"}},
+				{2, "", []string{"ep2_page001.jpg"}},
+			},
+		},
+		{
+			name:     "client naming sorts page numbers numerically under a common directory",
+			files:    []string{"Comic/ep1_page10.jpg", "Comic/ep1_page9.jpg", "Comic/ep1_page011.jpg"},
+			layout:   ImportLayoutClient,
+			episodes: []episode{{1, "", []string{"Comic/ep1_page9.jpg", "Comic/ep1_page10.jpg", "Comic/ep1_page011.jpg"}}},
+		},
+		{
+			name:  "client naming rejects episode 0",
+			files: []string{"ep0_page001.jpg", "ep1_page001.jpg"},
+			err:   true,
+		},
+		{
+			name:     "flat pages in natural order with cover in common directory",
+			files:    []string{"Comic/P10.jpg", "Comic/P2.jpg", "Comic/p1.jpg", "Comic/cover.png"},
+			layout:   ImportLayoutFlat,
+			cover:    "Comic/cover.png",
+			episodes: []episode{{1, "", []string{"Comic/p1.jpg", "Comic/P2.jpg", "Comic/P10.jpg"}}},
+		},
+		{
+			name:   "numeric episode folders keep their numbers",
+			files:  []string{"10/001.jpg", "2/002.jpg", "2/001.jpg"},
+			layout: ImportLayoutEpisodes,
+			episodes: []episode{
+				{2, "", []string{"2/001.jpg", "2/002.jpg"}},
+				{10, "", []string{"10/001.jpg"}},
+			},
+		},
+		{
+			name:   "named episode folders in natural order",
+			files:  []string{"Book/Vol.10/a.jpg", "Book/Vol.2/a.jpg", "Book/cover.jpg"},
+			layout: ImportLayoutEpisodes,
+			cover:  "Book/cover.jpg",
+			episodes: []episode{
+				{1, "Vol.2", []string{"Book/Vol.2/a.jpg"}},
+				{2, "Vol.10", []string{"Book/Vol.10/a.jpg"}},
+			},
+		},
+		{
+			name:   "mixed numeric and named folders are numbered by position",
+			files:  []string{"Extra/a.jpg", "1/a.jpg"},
+			layout: ImportLayoutEpisodes,
+			episodes: []episode{
+				{1, "1", []string{"1/a.jpg"}},
+				{2, "Extra", []string{"Extra/a.jpg"}},
+			},
+		},
+		{
+			name:   "folder 0 is not an episode number",
+			files:  []string{"0/a.jpg", "1/a.jpg"},
+			layout: ImportLayoutEpisodes,
+			episodes: []episode{
+				{1, "0", []string{"0/a.jpg"}},
+				{2, "1", []string{"1/a.jpg"}},
+			},
+		},
+		{
+			name:   "nested common directories are stripped",
+			files:  []string{"a/b/ch1/001.jpg", "a/b/ch2/001.jpg"},
+			layout: ImportLayoutEpisodes,
+			episodes: []episode{
+				{1, "ch1", []string{"a/b/ch1/001.jpg"}},
+				{2, "ch2", []string{"a/b/ch2/001.jpg"}},
+			},
+		},
+		{
+			name:  "pages both at the root and in folders",
+			files: []string{"001.jpg", "ch1/001.jpg"},
+			err:   true,
+		},
+		{
+			name:  "pages nested more than one folder deep",
+			files: []string{"a/ch1/001.jpg", "b/001.jpg"},
+			err:   true,
+		},
+		{
+			name:  "only a cover",
+			files: []string{"cover.jpg"},
+			err:   true,
+		},
+		{
+			name: "empty archive",
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, episodes, cover, err := detectImportLayout(tt.files)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidImportArchive) {
+					t.Fatalf("error = %v, want ErrInvalidImportArchive", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := make([]episode, len(episodes))
+			for i, e := range episodes {
+				got[i] = episode{e.ep, e.title, e.pages}
+			}
+			if layout != tt.layout || cover != tt.cover || !reflect.DeepEqual(got, tt.episodes) {
+				t.Errorf("got layout %q cover %q episodes %+v\nwant layout %q cover %q episodes %+v",
+					layout, cover, got, tt.layout, tt.cover, tt.episodes)
+			}
+		})
+	}
+}
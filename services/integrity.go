@@ -0,0 +1,799 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pica-comic-server/models"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/webp"
+)
+
+// 完整性检查发现的问题类型
+const (
+	IssueEmptyPage        = "empty_page"        // 0 字节的页面
+	IssueBrokenPage       = "broken_page"       // 无法识别或解析图片头的页面
+	IssueTruncatedPage    = "truncated_page"    // 不完整的页面（缺少图片结束标记）
+	IssueMissingPage      = "missing_page"      // 下载任务中有、章节目录中缺少的页面
+	IssueMissingEpisode   = "missing_episode"   // downloaded_eps 中有、目录中没有的章节
+	IssueUntrackedEpisode = "untracked_episode" // 目录中有、downloaded_eps 中没有的章节
+	IssuePagesCount       = "pages_count"       // pages_count 与实际页数不一致
+	IssueOrphanRow        = "orphan_row"        // 目录已不存在的漫画记录
+	IssueOrphanFolder     = "orphan_folder"     // 不属于任何漫画的目录
+)
+
+// 完整性检查的修复选项
+const (
+	RepairRecount = "recount" // 按实际内容更新 downloaded_eps、pages_count 和大小
+	RepairOrphans = "orphans" // 把孤立的记录和目录移入回收站
+	RepairRequeue = "requeue" // 损坏、缺少的页面在下载任务中有原始地址时重新下载
+)
+
+// ErrInvalidRepair 无效的修复选项
+var ErrInvalidRepair = errors.New("无效的修复选项，可选 recount、orphans、requeue")
+
+// ParseRepairs 解析逗号分隔的修复选项
+func ParseRepairs(s string) ([]string, error) {
+	var repairs []string
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		switch r {
+		case "":
+		case RepairRecount, RepairOrphans, RepairRequeue:
+			repairs = append(repairs, r)
+		default:
+			return nil, ErrInvalidRepair
+		}
+	}
+	return repairs, nil
+}
+
+// IntegrityOptions 完整性检查的选项
+type IntegrityOptions struct {
+	ComicID        string   // 只检查指定漫画，为空时检查整个库（包括孤立目录）
+	Repairs        []string // 要执行的修复
+	StartDownloads bool     // 重新下载的任务加入队列后立即开始，否则等待下次开始下载
+}
+
+// IntegrityIssue 检查发现的一个问题
+type IntegrityIssue struct {
+	Type        string `json:"type"`
+	ComicID     string `json:"comic_id,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Directory   string `json:"directory,omitempty"`
+	Ep          int    `json:"ep,omitempty"`
+	Page        int    `json:"page,omitempty"` // 页码（从1开始），缺少的页面为空
+	File        string `json:"file,omitempty"` // 页面文件名
+	Size        int64  `json:"size,omitempty"` // 孤立目录的大小（字节）
+	Detail      string `json:"detail,omitempty"`
+	Requeueable bool   `json:"requeueable,omitempty"` // 下载任务中有原始地址，可以重新下载
+	Repair      string `json:"repair,omitempty"`      // 已执行的修复
+}
+
+// IntegrityReport 完整性检查的报告
+type IntegrityReport struct {
+	Comics   int              `json:"comics"` // 检查的漫画数
+	Pages    int              `json:"pages"`  // 检查的页面数
+	Issues   []IntegrityIssue `json:"issues"`
+	Summary  map[string]int   `json:"summary"`           // 各类问题的数量
+	Repairs  []string         `json:"repairs,omitempty"` // 执行的修复选项
+	Repaired int              `json:"repaired"`          // 已修复的问题数
+	Tasks    []string         `json:"tasks,omitempty"`   // 重新下载的任务ID
+	Errors   []string         `json:"errors,omitempty"`  // 修复失败的原因
+	Duration string           `json:"duration"`
+	Time     time.Time        `json:"time"`
+}
+
+// integrityChecker 同一时间只允许一次检查，并保留最近一次的报告
+type integrityChecker struct {
+	mu   sync.Mutex
+	last *IntegrityReport
+}
+
+// LastIntegrityReport 返回最近一次完整性检查的报告
+func (dm *DownloadManager) LastIntegrityReport() *IntegrityReport {
+	dm.integrity.mu.Lock()
+	defer dm.integrity.mu.Unlock()
+	return dm.integrity.last
+}
+
+// CheckLibrary 检查漫画库的完整性：解析每个页面的图片头，比较实际的章节、页数与数据库记录，
+// 并查找孤立的记录和目录；按 opts.Repairs 执行修复
+func (dm *DownloadManager) CheckLibrary(opts IntegrityOptions) (*IntegrityReport, error) {
+	dm.integrity.mu.Lock()
+	defer dm.integrity.mu.Unlock()
+
+	start := time.Now()
+	report := &IntegrityReport{Repairs: opts.Repairs, Summary: make(map[string]int), Time: start}
+	repair := func(r string) bool { return containsString(opts.Repairs, r) }
+
+	taskEpisodes := dm.directTaskEpisodes()
+	var ids []string
+	if opts.ComicID != "" {
+		ids = []string{opts.ComicID}
+	} else {
+		// 先对账扫描文件夹的索引，没有可识别页面的扫描文件夹作为孤立目录检查
+		if _, err := dm.ReconcileLibrary(); err != nil {
+			return nil, err
+		}
+		rows, err := dm.db.Query(`
+			SELECT id FROM comics WHERE deleted_at IS NULL
+			UNION ALL SELECT id FROM scanned_comics WHERE pages_count > 0
+		`)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id string
+			if rows.Scan(&id) == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+	}
+
+	for _, id := range ids {
+		comic, err := dm.GetComic(id)
+		if err != nil {
+			if opts.ComicID != "" {
+				return nil, err
+			}
+			continue
+		}
+		inDB, err := dm.isDBComic(id)
+		if err != nil {
+			return nil, err
+		}
+		report.Comics++
+
+		exists, err := dm.comicExists(comic.Directory)
+		if err != nil {
+			// 无法确认目录是否存在时不当作孤立记录
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", comic.Title, err))
+			continue
+		}
+		if !exists {
+			issue := IntegrityIssue{Type: IssueOrphanRow, ComicID: comic.ID, Title: comic.Title, Directory: comic.Directory, Detail: "漫画目录不存在"}
+			if repair(RepairOrphans) {
+				if _, err := dm.TrashComic(comic.ID); err != nil {
+					report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", comic.Title, err))
+				} else {
+					issue.Repair = "trashed"
+				}
+			}
+			report.add(issue)
+			continue
+		}
+
+		var episodes map[int]directEpisode
+		if inDB {
+			episodes = taskEpisodes[comic.ID]
+		}
+		issues := dm.checkComic(comic, inDB, episodes, report)
+		if repair(RepairRequeue) && len(episodes) > 0 {
+			if taskID, n := dm.requeuePages(comic, issues, episodes, report); taskID != "" {
+				report.Tasks = append(report.Tasks, taskID)
+				log.Printf("[完整性检查] %s: %d 页重新加入下载队列（%s）", comic.Title, n, taskID)
+			}
+		}
+		if repair(RepairRecount) && inDB && needsRecount(issues) {
+			if err := dm.recountComic(comic); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", comic.Title, err))
+			} else {
+				for i := range issues {
+					if isCountIssue(issues[i].Type) && issues[i].Repair == "" {
+						issues[i].Repair = "recounted"
+					}
+				}
+			}
+		}
+		for _, issue := range issues {
+			report.add(issue)
+		}
+	}
+
+	if opts.ComicID == "" {
+		dm.checkOrphanFolders(repair(RepairOrphans), report)
+	}
+
+	// 检查结束后再开始下载，避免检查孤立目录时把重新下载当作进行中的下载
+	if opts.StartDownloads && len(report.Tasks) > 0 {
+		dm.mu.Lock()
+		if !dm.isDownloading {
+			go dm.processQueue()
+		}
+		dm.mu.Unlock()
+	}
+
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+	log.Printf("[完整性检查] 完成: %d 部漫画, %d 页, %d 个问题, 已修复 %d, 耗时 %s",
+		report.Comics, report.Pages, len(report.Issues), report.Repaired, report.Duration)
+	dm.integrity.last = report
+	return report, nil
+}
+
+// add 记录一个问题
+func (r *IntegrityReport) add(issue IntegrityIssue) {
+	r.Issues = append(r.Issues, issue)
+	r.Summary[issue.Type]++
+	if issue.Repair != "" {
+		r.Repaired++
+	}
+}
+
+// checkComic 检查漫画的页面和章节，episodes 为下载任务中记录的章节（没有时为空）
+func (dm *DownloadManager) checkComic(comic *models.ComicDetail, inDB bool, episodes map[int]directEpisode, report *IntegrityReport) []IntegrityIssue {
+	var issues []IntegrityIssue
+	newIssue := func(typ string, ep int, detail string) IntegrityIssue {
+		return IntegrityIssue{Type: typ, ComicID: comic.ID, Title: comic.Title, Directory: comic.Directory, Ep: ep, Detail: detail}
+	}
+
+	hasPageURL := func(ep int, name string) bool {
+		e, ok := episodes[ep]
+		if !ok {
+			return false
+		}
+		for i := range e.PageURLs {
+			if directPageName(i) == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	present := make(map[int]bool)
+	total := 0
+	for _, src := range dm.comicSources(comic.Directory) {
+		present[src.ep] = true
+		pages, err := src.pages()
+		if err != nil {
+			issues = append(issues, newIssue(IssueBrokenPage, src.ep, "读取章节失败: "+err.Error()))
+			continue
+		}
+		total += len(pages)
+
+		names := make(map[string]bool, len(pages))
+		for i, page := range pages {
+			report.Pages++
+			names[page.Name()] = true
+			typ, detail := checkPage(page)
+			if typ == "" {
+				continue
+			}
+			issue := newIssue(typ, src.ep, detail)
+			issue.Page, issue.File = i+1, page.Name()
+			issue.Requeueable = !src.archive && hasPageURL(src.ep, page.Name())
+			issues = append(issues, issue)
+		}
+
+		// 下载任务中有、目录中缺少的页面
+		if e, ok := episodes[src.ep]; ok && !src.archive && !src.root {
+			for i := range e.PageURLs {
+				if name := directPageName(i); !names[name] {
+					issue := newIssue(IssueMissingPage, src.ep, "页面文件不存在")
+					issue.File, issue.Requeueable = name, true
+					issues = append(issues, issue)
+				}
+			}
+		}
+	}
+
+	if !inDB {
+		// 扫描文件夹的章节和页数来自扫描结果，不需要比较
+		return issues
+	}
+	for _, ep := range comic.DownloadedEps {
+		if !present[ep] {
+			_, ok := episodes[ep]
+			issue := newIssue(IssueMissingEpisode, ep, "章节目录不存在")
+			issue.Requeueable = ok
+			issues = append(issues, issue)
+		}
+	}
+	var untracked []int
+	for ep := range present {
+		if !containsInt(comic.DownloadedEps, ep) {
+			untracked = append(untracked, ep)
+		}
+	}
+	sort.Ints(untracked)
+	for _, ep := range untracked {
+		issues = append(issues, newIssue(IssueUntrackedEpisode, ep, "章节不在 downloaded_eps 中"))
+	}
+	if total != comic.PagesCount {
+		issues = append(issues, newIssue(IssuePagesCount, 0, fmt.Sprintf("记录 %d 页，实际 %d 页", comic.PagesCount, total)))
+	}
+	return issues
+}
+
+// isCountIssue 问题是否可以通过重新统计修复
+func isCountIssue(typ string) bool {
+	return typ == IssueMissingEpisode || typ == IssueUntrackedEpisode || typ == IssuePagesCount
+}
+
+// needsRecount 是否有需要重新统计的问题
+func needsRecount(issues []IntegrityIssue) bool {
+	for _, issue := range issues {
+		if isCountIssue(issue.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageFormat 根据文件头识别图片格式
+func imageFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return "gif"
+	case len(header) >= 16 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "webp"
+	}
+	return ""
+}
+
+// checkPage 检查页面：大小为 0、图片头无法解析，或（本地文件）缺少结束标记时返回问题类型和说明
+func checkPage(page PageRef) (string, string) {
+	size, _, err := page.Stat()
+	if err != nil {
+		return IssueBrokenPage, "读取失败: " + err.Error()
+	}
+	if size == 0 {
+		return IssueEmptyPage, "文件大小为 0"
+	}
+
+	rc, _, err := page.Open()
+	if err != nil {
+		return IssueBrokenPage, "读取失败: " + err.Error()
+	}
+	defer rc.Close()
+	header := make([]byte, 512)
+	n, err := io.ReadFull(rc, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return IssueBrokenPage, "读取失败: " + err.Error()
+	}
+	header = header[:n]
+
+	format := imageFormat(header)
+	r := io.MultiReader(bytes.NewReader(header), rc)
+	switch format {
+	case "jpeg":
+		_, err = jpeg.DecodeConfig(r)
+	case "png":
+		_, err = png.DecodeConfig(r)
+	case "gif":
+		_, err = gif.DecodeConfig(r)
+	case "webp":
+		// WebP 没有结束标记，用 RIFF 头中记录的大小检查是否完整
+		if riffSize := int64(binary.LittleEndian.Uint32(header[4:8])) + 8; riffSize > size {
+			return IssueTruncatedPage, fmt.Sprintf("文件大小 %d 字节，应为 %d 字节", size, riffSize)
+		}
+		_, err = webp.DecodeConfig(r)
+	default:
+		return IssueBrokenPage, "无法识别的图片格式"
+	}
+	if err != nil {
+		return IssueBrokenPage, "图片头解析失败: " + err.Error()
+	}
+
+	// 本地文件额外检查结尾，发现下载中断留下的不完整图片
+	if !page.InArchive() && !page.InStorage() && !hasImageTrailer(page.Path, format, size) {
+		return IssueTruncatedPage, "缺少图片结束标记"
+	}
+	return "", ""
+}
+
+// hasImageTrailer 检查图片文件是否以对应格式的结束标记结尾（忽略末尾的填充字节）
+func hasImageTrailer(path, format string, size int64) bool {
+	if format == "webp" {
+		return true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	tail := make([]byte, 64)
+	if size < int64(len(tail)) {
+		tail = tail[:size]
+	}
+	if _, err := f.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return false
+	}
+	tail = bytes.TrimRight(tail, "\x00\r\n ")
+	switch format {
+	case "jpeg":
+		return bytes.HasSuffix(tail, []byte{0xFF, 0xD9})
+	case "png":
+		return bytes.Contains(tail, []byte("IEND"))
+	case "gif":
+		return bytes.HasSuffix(tail, []byte{0x3B})
+	}
+	return true
+}
+
+// directTaskEpisodes 从已完成的直接下载任务中读取各漫画章节的原始页面地址（漫画ID -> 章节号 -> 章节，后完成的任务优先）
+func (dm *DownloadManager) directTaskEpisodes() map[string]map[int]directEpisode {
+	rows, err := dm.db.Query(`
+		SELECT comic_id, COALESCE(extra, '') FROM download_tasks
+		WHERE status = 'completed' ORDER BY created_at
+	`)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	episodes := make(map[string]map[int]directEpisode)
+	for rows.Next() {
+		var taskComicID, extraJSON string
+		if rows.Scan(&taskComicID, &extraJSON) != nil {
+			continue
+		}
+		var extra struct {
+			DirectMode bool             `json:"direct_mode"`
+			Episodes   []directEpisode  `json:"episodes"`
+			Repair     *repairTarget    `json:"repair"`
+			Duplicate  *DuplicateReport `json:"duplicate"`
+		}
+		if json.Unmarshal([]byte(extraJSON), &extra) != nil || !extra.DirectMode || extra.Repair != nil {
+			continue
+		}
+		// 保留副本或合并时，漫画的ID记录在重复检测结果中
+		if extra.Duplicate != nil && extra.Duplicate.ComicID != "" {
+			taskComicID = extra.Duplicate.ComicID
+		}
+		if episodes[taskComicID] == nil {
+			episodes[taskComicID] = make(map[int]directEpisode)
+		}
+		for _, ep := range extra.Episodes {
+			if len(ep.PageURLs) > 0 {
+				episodes[taskComicID][ep.Order] = ep
+			}
+		}
+	}
+	return episodes
+}
+
+// repairTarget 重新下载任务要修复的漫画
+type repairTarget struct {
+	ComicID   string `json:"comic_id"`
+	Directory string `json:"directory"`
+}
+
+// repairPage 重新下载任务中的一页
+type repairPage struct {
+	Ep    int `json:"ep"`
+	Index int `json:"index"` // 在章节的页面地址中的位置（从0开始）
+}
+
+// requeuePages 为可以重新下载的页面创建下载任务，返回任务ID和页面数
+func (dm *DownloadManager) requeuePages(comic *models.ComicDetail, issues []IntegrityIssue, episodes map[int]directEpisode, report *IntegrityReport) (string, int) {
+	var pages []repairPage
+	queued := make(map[int]bool)
+	used := make(map[int]directEpisode)
+	for i, issue := range issues {
+		if !issue.Requeueable {
+			continue
+		}
+		ep := episodes[issue.Ep]
+		for index := range ep.PageURLs {
+			if issue.Type == IssueMissingEpisode || directPageName(index) == issue.File {
+				pages = append(pages, repairPage{Ep: issue.Ep, Index: index})
+			}
+		}
+		used[issue.Ep] = ep
+		queued[i] = true
+	}
+	if len(pages) == 0 {
+		return "", 0
+	}
+
+	usedEps := make([]directEpisode, 0, len(used))
+	for _, ep := range used {
+		usedEps = append(usedEps, ep)
+	}
+	sort.Slice(usedEps, func(i, j int) bool { return usedEps[i].Order < usedEps[j].Order })
+	extraJSON, _ := json.Marshal(map[string]interface{}{
+		"direct_mode": true,
+		"repair":      repairTarget{ComicID: comic.ID, Directory: comic.Directory},
+		"episodes":    usedEps,
+		"pages":       pages,
+	})
+
+	task := &models.DownloadTask{
+		ID:         fmt.Sprintf("repair_%d", time.Now().UnixNano()),
+		ComicID:    comic.ID,
+		Type:       comic.Type,
+		Title:      comic.Title,
+		Author:     comic.Author,
+		Status:     "pending",
+		TotalPages: len(pages),
+		Extra:      string(extraJSON),
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if _, err := dm.db.Exec(`
+		INSERT INTO download_tasks
+		(id, comic_id, type, title, status, cover, description, tags, author, extra, downloaded_pages, total_pages, current_ep, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, task.ID, task.ComicID, task.Type, task.Title, task.Status,
+		task.Cover, task.Description, task.Tags, task.Author,
+		task.Extra, task.DownloadedPages, task.TotalPages, task.CurrentEp,
+		task.CreatedAt.Unix(), task.UpdatedAt.Unix()); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: 创建下载任务失败: %v", comic.Title, err))
+		return "", 0
+	}
+	dm.queue = append(dm.queue, task)
+
+	for i := range issues {
+		if queued[i] {
+			issues[i].Repair = "requeued"
+		}
+	}
+	return task.ID, len(pages)
+}
+
+// downloadRepairPages 执行重新下载任务：下载到暂存目录，检查无误后替换漫画中的页面
+func (dm *DownloadManager) downloadRepairPages(task *models.DownloadTask) error {
+	var extra struct {
+		Repair   repairTarget    `json:"repair"`
+		Episodes []directEpisode `json:"episodes"`
+		Pages    []repairPage    `json:"pages"`
+	}
+	if err := json.Unmarshal([]byte(task.Extra), &extra); err != nil {
+		return fmt.Errorf("解析任务数据失败: %w", err)
+	}
+	comic, err := dm.GetComic(extra.Repair.ComicID)
+	if err != nil {
+		return fmt.Errorf("漫画不存在: %w", err)
+	}
+	episodes := make(map[int]directEpisode, len(extra.Episodes))
+	for _, ep := range extra.Episodes {
+		episodes[ep.Order] = ep
+	}
+
+	if err := os.MkdirAll(dm.importStagingDir(), 0755); err != nil {
+		return err
+	}
+	stagingDir, err := os.MkdirTemp(dm.importStagingDir(), "repair-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	remote := dm.isStoredRemotely(comic.Directory)
+	store := dm.storage
+	if !remote {
+		store = NewLocalStorage(dm.downloadPath)
+	}
+	imageHeaders := dm.getImageHeaders(task.Type, "")
+
+	failed := 0
+	for i, p := range extra.Pages {
+		ep, ok := episodes[p.Ep]
+		if !ok || p.Index >= len(ep.PageURLs) {
+			failed++
+			continue
+		}
+		name := directPageName(p.Index)
+		fmt.Printf("[重新下载] %s 第 %d 章 %s (%d/%d)\n", comic.Title, p.Ep, name, i+1, len(extra.Pages))
+
+		headers := imageHeaders
+		if len(ep.Headers) > 0 {
+			headers = ep.Headers
+		}
+		tmp := filepath.Join(stagingDir, fmt.Sprintf("%d-%s", p.Ep, name))
+		if err := dm.downloadFileWithHeaders(ep.PageURLs[p.Index], tmp, headers); err != nil {
+			fmt.Printf("[错误] 重新下载失败: %v\n", err)
+			failed++
+			continue
+		}
+		ep.descramble(p.Index, tmp)
+		if typ, detail := checkPage(PageRef{Path: tmp}); typ != "" {
+			fmt.Printf("[错误] 重新下载的页面仍然有问题: %s\n", detail)
+			failed++
+			continue
+		}
+		if err := store.Import(tmp, joinStorageKey(comic.Directory, fmt.Sprintf("%d", p.Ep), name)); err != nil {
+			fmt.Printf("[错误] 保存页面失败: %v\n", err)
+			failed++
+			continue
+		}
+
+		task.DownloadedPages = i + 1
+		task.CurrentEp = p.Ep
+		dm.updateTaskStatus(task)
+	}
+
+	if remote {
+		pageIndex.forget(dm.storage, comic.Directory)
+	} else {
+		dm.dedupComic(filepath.Join(dm.downloadPath, comic.Directory))
+	}
+	dm.removeCoverThumbnails(comic.ID)
+	if inDB, err := dm.isDBComic(comic.ID); err == nil && inDB {
+		if err := dm.recountComic(comic); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d 页重新下载失败", failed)
+	}
+	return nil
+}
+
+// recountComic 按实际内容更新漫画的 downloaded_eps、pages_count 和大小，缺少名称的章节使用"第 N 话"
+func (dm *DownloadManager) recountComic(comic *models.ComicDetail) error {
+	var downloaded []int
+	pagesCount := 0
+	for _, src := range dm.comicSources(comic.Directory) {
+		downloaded = append(downloaded, src.ep)
+		pagesCount += src.pageCount()
+	}
+	if downloaded == nil {
+		downloaded = []int{}
+	}
+
+	eps := append([]string(nil), comic.Eps...)
+	for _, ep := range downloaded {
+		for len(eps) < ep {
+			eps = append(eps, fmt.Sprintf("第 %d 话", len(eps)+1))
+		}
+	}
+	epsCount := comic.EpsCount
+	if len(downloaded) > epsCount {
+		epsCount = len(downloaded)
+	}
+
+	epsJSON, _ := json.Marshal(eps)
+	downloadedJSON, _ := json.Marshal(downloaded)
+	if _, err := dm.db.Exec(`
+		UPDATE comics SET eps = ?, eps_count = ?, downloaded_eps = ?, pages_count = ?, size = ? WHERE id = ?
+	`, string(epsJSON), epsCount, string(downloadedJSON), pagesCount, dm.comicSize(comic.Directory), comic.ID); err != nil {
+		return fmt.Errorf("更新漫画信息失败: %w", err)
+	}
+	if updated, err := dm.GetComic(comic.ID); err == nil {
+		syncComicFTS(dm.db, updated)
+	}
+	return nil
+}
+
+// checkOrphanFolders 查找不属于任何漫画的目录（没有漫画记录，也不包含可识别的页面），可选移入回收站
+func (dm *DownloadManager) checkOrphanFolders(trash bool, report *IntegrityReport) {
+	known := make(map[string]bool)
+	rows, err := dm.db.Query(`
+		SELECT directory FROM comics WHERE directory IS NOT NULL AND deleted_at IS NULL
+		UNION SELECT directory FROM scanned_comics WHERE pages_count > 0
+	`)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+	for rows.Next() {
+		var dir string
+		if rows.Scan(&dir) == nil {
+			known[dir] = true
+		}
+	}
+	rows.Close()
+
+	sizes := make(map[string]int64)
+	var orphans []string
+	if entries, err := os.ReadDir(dm.downloadPath); err == nil {
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() || strings.HasPrefix(name, ".") || known[name] {
+				continue
+			}
+			orphans = append(orphans, name)
+			sizes[name] = calculateFolderSize(filepath.Join(dm.downloadPath, name))
+		}
+	}
+	remote := make(map[string]bool)
+	if dm.remoteStorage() {
+		objects, err := dm.storage.List("")
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("列出存储中的文件失败: %v", err))
+		}
+		for _, obj := range objects {
+			name, _, nested := strings.Cut(obj.Key, "/")
+			if !nested || strings.HasPrefix(name, ".") || known[name] {
+				continue
+			}
+			if _, seen := sizes[name]; !seen {
+				orphans = append(orphans, name)
+				remote[name] = true
+			}
+			sizes[name] += obj.Size
+		}
+	}
+
+	dm.mu.RLock()
+	downloading := dm.isDownloading
+	dm.mu.RUnlock()
+
+	sort.Strings(orphans)
+	for _, name := range orphans {
+		issue := IntegrityIssue{Type: IssueOrphanFolder, Directory: name, Size: sizes[name], Detail: "没有对应的漫画记录，且不包含可识别的页面"}
+		if remote[name] {
+			issue.Detail = "只存在于远程存储中，没有对应的漫画记录"
+		}
+		if trash {
+			if downloading {
+				// 正在下载的漫画目录在完成前没有记录
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: 下载进行中，跳过移入回收站", name))
+			} else if err := dm.trashOrphanFolder(name, sizes[name], remote[name]); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", name, err))
+			} else {
+				issue.Repair = "trashed"
+			}
+		}
+		report.add(issue)
+	}
+}
+
+// trashOrphanFolder 把孤立目录移入回收站（作为扫描文件夹记录，恢复后重新扫描）
+func (dm *DownloadManager) trashOrphanFolder(name string, size int64, remote bool) error {
+	entry := &TrashEntry{
+		ID:        uuid.New().String(),
+		ComicID:   generateComicID(name),
+		Title:     name,
+		Directory: name,
+		Size:      size,
+		Scanned:   true,
+		DeletedAt: time.Now(),
+	}
+	if remote {
+		if err := dm.renameStorage(name, trashKey(entry.ID)); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(dm.trashDir(), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(dm.downloadPath, name), filepath.Join(dm.trashDir(), entry.ID)); err != nil {
+			return err
+		}
+	}
+	if err := dm.markTrashed(entry, false); err != nil {
+		if remote {
+			dm.renameStorage(trashKey(entry.ID), name)
+		} else {
+			os.Rename(filepath.Join(dm.trashDir(), entry.ID), filepath.Join(dm.downloadPath, name))
+		}
+		return err
+	}
+	return nil
+}
+
+// containsString 切片中是否包含 v
+func containsString(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -7,8 +7,6 @@ import (
 	"fmt"
 	"image"
 	_ "image/gif" // 支持 GIF 格式
-	"image/jpeg"
-	"image/png"
 	"os"
 	"strconv"
 )
@@ -126,26 +124,10 @@ func DescrambleJmImage(inputPath, epsId, scrambleId, bookId string) error {
 		}
 	}
 
-	// 保存处理后的图片，根据原始格式选择编码器
-	outFile, err := os.Create(inputPath)
-	if err != nil {
-		return fmt.Errorf("创建输出文件失败: %w", err)
-	}
-	defer outFile.Close()
-
-	// 根据检测到的格式保存
-	switch format {
-	case "jpeg", "jpg":
-		err = jpeg.Encode(outFile, newImg, &jpeg.Options{Quality: 95})
-	case "png":
-		err = png.Encode(outFile, newImg)
-	default:
-		// 默认使用 JPEG
-		err = jpeg.Encode(outFile, newImg, &jpeg.Options{Quality: 95})
-	}
-
-	if err != nil {
-		return fmt.Errorf("编码图片失败: %w", err)
+	// 保存处理后的图片：PNG 保持原格式，其它格式编码为 JPEG
+	// 写入临时文件后替换原文件，不修改可能被去重共享的硬链接
+	if err := writeImageFile(inputPath, newImg, format, 95); err != nil {
+		return fmt.Errorf("保存图片失败: %w", err)
 	}
 
 	fmt.Printf("[JM反混淆] 图片已保存 (格式: %s)\n", format)
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"pica-comic-server/models"
+)
+
+// libraryScanVersion 扫描规则的版本，规则变化（如开始读取元数据文件）时递增，已索引的文件夹会全部重新扫描
+const libraryScanVersion = 3
+
+// LibraryScanResult 一次库索引对账的结果
+type LibraryScanResult struct {
+	Scanned   int       `json:"scanned"`   // 重新扫描的文件夹数
+	Unchanged int       `json:"unchanged"` // 签名未变化而跳过的文件夹数
+	Removed   int       `json:"removed"`   // 已不存在而移除的索引数
+	Duration  string    `json:"duration"`
+	Time      time.Time `json:"time"`
+}
+
+// libraryIndexer 后台库索引器：周期性或按需对账下载目录与 scanned_comics 表
+type libraryIndexer struct {
+	mu      sync.Mutex // 同一时间只允许一次对账
+	trigger chan struct{}
+	last    *LibraryScanResult
+}
+
+// StartLibraryIndexer 启动后台库索引器，interval 为周期性对账间隔（<=0 表示只在启动和手动触发时扫描）
+func (dm *DownloadManager) StartLibraryIndexer(interval time.Duration) {
+	go func() {
+		dm.runLibraryScan("启动")
+
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-tick:
+				dm.runLibraryScan("定时")
+			case <-dm.indexer.trigger:
+				dm.runLibraryScan("触发")
+			}
+		}
+	}()
+}
+
+// TriggerLibraryScan 请求后台索引器尽快执行一次对账（不等待结果）
+func (dm *DownloadManager) TriggerLibraryScan() {
+	select {
+	case dm.indexer.trigger <- struct{}{}:
+	default:
+		// 已有待处理的触发请求
+	}
+}
+
+// LastLibraryScan 返回最近一次对账的结果
+func (dm *DownloadManager) LastLibraryScan() *LibraryScanResult {
+	dm.indexer.mu.Lock()
+	defer dm.indexer.mu.Unlock()
+	return dm.indexer.last
+}
+
+func (dm *DownloadManager) runLibraryScan(reason string) {
+	result, err := dm.ReconcileLibrary()
+	if err != nil {
+		log.Printf("[库索引] %s扫描失败: %v", reason, err)
+		return
+	}
+	if result.Scanned > 0 || result.Removed > 0 {
+		log.Printf("[库索引] %s扫描完成: 更新 %d, 未变化 %d, 移除 %d, 耗时 %s",
+			reason, result.Scanned, result.Unchanged, result.Removed, result.Duration)
+	}
+	dm.backfillPageFingerprints()
+}
+
+// ReconcileLibrary 同步执行一次对账：
+// 扫描下载目录中不属于 comics 表的文件夹和压缩包，签名变化时重新扫描，并移除已消失的索引
+func (dm *DownloadManager) ReconcileLibrary() (*LibraryScanResult, error) {
+	dm.indexer.mu.Lock()
+	defer dm.indexer.mu.Unlock()
+
+	start := time.Now()
+	result := &LibraryScanResult{Time: start}
+
+	entries, err := os.ReadDir(dm.downloadPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取下载目录失败: %w", err)
+	}
+
+	// 已由 comics 表管理的目录
+	managed := make(map[string]bool)
+	rows, err := dm.db.Query("SELECT directory FROM comics WHERE directory IS NOT NULL AND deleted_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var dir string
+		if rows.Scan(&dir) == nil {
+			managed[dir] = true
+		}
+	}
+	rows.Close()
+
+	// 已索引目录及其签名
+	type indexedFolder struct {
+		id      string
+		sig     int64
+		version int
+	}
+	indexed := make(map[string]indexedFolder)
+	rows, err = dm.db.Query("SELECT directory, id, COALESCE(signature, 0), COALESCE(scan_version, 0) FROM scanned_comics")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var dir string
+		var item indexedFolder
+		if rows.Scan(&dir, &item.id, &item.sig, &item.version) == nil {
+			indexed[dir] = item
+		}
+	}
+	rows.Close()
+
+	present := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		// 跳过隐藏目录（如 .cache 缓存目录）和压缩包以外的普通文件
+		if strings.HasPrefix(name, ".") || managed[name] {
+			continue
+		}
+		if !entry.IsDir() && !isArchiveFile(name) {
+			continue
+		}
+		present[name] = true
+
+		folderPath := filepath.Join(dm.downloadPath, name)
+		sig := folderSignature(folderPath)
+		if old, ok := indexed[name]; ok && old.sig == sig && old.version == libraryScanVersion {
+			result.Unchanged++
+			continue
+		}
+
+		if err := dm.indexScannedFolder(name, sig); err != nil {
+			log.Printf("[库索引] 索引文件夹失败: %s, 错误: %v", name, err)
+			continue
+		}
+		result.Scanned++
+	}
+
+	// 移除已删除或已转为数据库记录的目录
+	for dir, item := range indexed {
+		if present[dir] {
+			continue
+		}
+		if _, err := dm.db.Exec("DELETE FROM scanned_comics WHERE directory = ?", dir); err != nil {
+			log.Printf("[库索引] 移除索引失败: %s, 错误: %v", dir, err)
+			continue
+		}
+		removeComicFTS(dm.db, item.id)
+		result.Removed++
+	}
+
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	dm.indexer.last = result
+	return result, nil
+}
+
+// folderSignature 计算文件夹签名：文件夹及其直接子目录、压缩包、元数据文件（包括章节目录中的）的最大修改时间
+// 章节内增删页面会改变子目录的修改时间，因此无需遍历全部文件；元数据文件可能被原地修改，需单独计入
+func folderSignature(folderPath string) int64 {
+	info, err := os.Stat(folderPath)
+	if err != nil {
+		return 0
+	}
+	sig := info.ModTime().UnixNano()
+
+	entries, err := os.ReadDir(folderPath)
+	if err != nil {
+		return sig
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && !isArchiveFile(entry.Name()) && !isSidecarFile(entry.Name()) {
+			continue
+		}
+		if sub, err := entry.Info(); err == nil && sub.ModTime().UnixNano() > sig {
+			sig = sub.ModTime().UnixNano()
+		}
+		if entry.IsDir() {
+			// 章节目录中的 ComicInfo.xml 提供章节名，原地修改不会改变目录的修改时间
+			if t := sidecarModTime(filepath.Join(folderPath, entry.Name())); t > sig {
+				sig = t
+			}
+		}
+	}
+	return sig
+}
+
+// sidecarModTime 目录中元数据文件的最大修改时间，没有时返回 0
+func sidecarModTime(dir string) int64 {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var latest int64
+	for _, entry := range entries {
+		if entry.IsDir() || !isSidecarFile(entry.Name()) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().UnixNano() > latest {
+			latest = info.ModTime().UnixNano()
+		}
+	}
+	return latest
+}
+
+// scanFolderDetail 扫描文件夹（或压缩包），生成漫画的基本记录
+func (dm *DownloadManager) scanFolderDetail(dirName string) *models.ComicDetail {
+	folderPath := filepath.Join(dm.downloadPath, dirName)
+
+	modTime := time.Now()
+	if info, err := os.Stat(folderPath); err == nil {
+		modTime = info.ModTime()
+	}
+
+	// 扫描章节并统计页数
+	sources := dm.comicSources(dirName)
+	eps, downloadedEps := dm.scanComicFolder(sources)
+	pagesCount := 0
+	for _, src := range sources {
+		pagesCount += src.pageCount()
+	}
+
+	// 下载目录中的压缩包作为只有一个章节的漫画，标题为文件名
+	title := dirName
+	if isArchiveFile(dirName) {
+		title = archiveStem(dirName)
+	}
+
+	comic := &models.ComicDetail{
+		Comic: models.Comic{
+			ID:          generateComicID(dirName), // 使用哈希生成的稳定ID
+			Title:       title,
+			Author:      "未知",
+			Description: "从文件夹扫描的漫画",
+			Tags:        []string{},
+			Categories:  []string{},
+			TagGroups:   models.TagGroups{},
+			Type:        "server", // 服务器漫画
+			EpsCount:    len(eps),
+			PagesCount:  pagesCount,
+			Size:        calculateFolderSize(folderPath), // 计算的实际大小（字节）
+			Time:        modTime,
+		},
+		Directory:     dirName,
+		Eps:           eps,
+		DownloadedEps: downloadedEps,
+	}
+
+	// ComicInfo.xml、info.json 等元数据文件覆盖占位信息
+	if meta := readComicMetadata(folderPath, sources); meta != nil {
+		meta.apply(comic)
+	}
+	return comic
+}
+
+// indexScannedFolder 扫描单个文件夹并写入索引
+func (dm *DownloadManager) indexScannedFolder(dirName string, signature int64) error {
+	comic := dm.scanFolderDetail(dirName)
+
+	tagsJSON, _ := json.Marshal(comic.Tags)
+	categoriesJSON, _ := json.Marshal(comic.Categories)
+	epsJSON, _ := json.Marshal(comic.Eps)
+	downloadedEpsJSON, _ := json.Marshal(comic.DownloadedEps)
+	tagGroupsJSON, _ := json.Marshal(comic.TagGroups)
+
+	_, err := dm.db.Exec(`
+		INSERT INTO scanned_comics (
+			directory, id, title, author, description, cover, tags, categories,
+			eps_count, pages_count, type, time, size, eps, downloaded_eps, detail_url,
+			tag_groups, signature, scan_version, scanned_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(directory) DO UPDATE SET
+			id = excluded.id,
+			title = excluded.title,
+			author = excluded.author,
+			description = excluded.description,
+			cover = excluded.cover,
+			tags = excluded.tags,
+			categories = excluded.categories,
+			eps_count = excluded.eps_count,
+			pages_count = excluded.pages_count,
+			type = excluded.type,
+			time = excluded.time,
+			size = excluded.size,
+			eps = excluded.eps,
+			downloaded_eps = excluded.downloaded_eps,
+			detail_url = excluded.detail_url,
+			tag_groups = excluded.tag_groups,
+			signature = excluded.signature,
+			scan_version = excluded.scan_version,
+			scanned_at = excluded.scanned_at
+	`,
+		comic.Directory,
+		comic.ID,
+		comic.Title,
+		comic.Author,
+		comic.Description,
+		comic.Cover,
+		string(tagsJSON),
+		string(categoriesJSON),
+		comic.EpsCount,
+		comic.PagesCount,
+		comic.Type,
+		comic.Time.Unix(),
+		comic.Size,
+		string(epsJSON),
+		string(downloadedEpsJSON),
+		comic.DetailURL,
+		string(tagGroupsJSON),
+		signature,
+		libraryScanVersion,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	syncComicFTS(dm.db, comic)
+	dm.updatePageFingerprints(comic.ID, dirName)
+	return nil
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReconcileRefreshesEpisodeSidecar(t *testing.T) {
+	dm := newTestManager(t)
+	comicDir := filepath.Join(dm.downloadPath, "Comic")
+	for _, ep := range []string{"1", "2"} {
+		os.MkdirAll(filepath.Join(comicDir, ep), 0755)
+		os.WriteFile(filepath.Join(comicDir, ep, "001.jpg"), []byte("x"), 0644)
+	}
+	sidecar := filepath.Join(comicDir, "1", "ComicInfo.xml")
+	writeSidecar := func(title string, modTime time.Time) {
+		data := "<ComicInfo><Series>Comic</Series><Title>" + title + "</Title></ComicInfo>"
+		if err := os.WriteFile(sidecar, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(sidecar, modTime, modTime)
+	}
+	reconcile := func() (int, []string) {
+		t.Helper()
+		result, err := dm.ReconcileLibrary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		comic, err := dm.GetComic(generateComicID("Comic"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Scanned, comic.Eps
+	}
+
+	start := time.Now()
+	writeSidecar("旧章节名", start)
+	if scanned, eps := reconcile(); scanned != 1 || !reflect.DeepEqual(eps, []string{"旧章节名", "第 2 话"}) {
+		t.Fatalf("first scan: scanned %d, eps %v", scanned, eps)
+	}
+	if scanned, _ := reconcile(); scanned != 0 {
+		t.Fatalf("unchanged folder was rescanned")
+	}
+
+	// 原地修改章节目录中的 ComicInfo.xml，章节目录和漫画目录的修改时间都不变
+	dirTimes := func() []time.Time {
+		var times []time.Time
+		for _, dir := range []string{comicDir, filepath.Join(comicDir, "1")} {
+			info, _ := os.Stat(dir)
+			times = append(times, info.ModTime())
+		}
+		return times
+	}
+	before := dirTimes()
+	writeSidecar("新章节名", start.Add(time.Second))
+	if !reflect.DeepEqual(before, dirTimes()) {
+		t.Fatal("rewriting the sidecar changed a directory modification time")
+	}
+	if scanned, eps := reconcile(); scanned != 1 || !reflect.DeepEqual(eps, []string{"新章节名", "第 2 话"}) {
+		t.Fatalf("after editing sidecar: scanned %d, eps %v", scanned, eps)
+	}
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"pica-comic-server/models"
+)
+
+// libraryCTE 合并 comics 表与扫描文件夹索引的公共表表达式
+// 已由 comics 表管理的目录不会重复出现，已移入回收站的漫画不包含在内
+const libraryCTE = `WITH library AS (
+		SELECT ` + comicColumns + ` FROM comics WHERE deleted_at IS NULL
+		UNION ALL
+		SELECT ` + comicColumns + ` FROM scanned_comics
+		WHERE directory NOT IN (SELECT directory FROM comics WHERE directory IS NOT NULL AND deleted_at IS NULL)
+	)`
+
+// libraryColumns 带 library. 前缀的漫画列，用于与其它表联合查询时避免列名冲突
+var libraryColumns = prefixColumns("library", comicColumns)
+
+// prefixColumns 为逗号分隔的列名加上表名前缀
+func prefixColumns(table, columns string) string {
+	parts := strings.Split(columns, ",")
+	for i, col := range parts {
+		parts[i] = table + "." + strings.TrimSpace(col)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// LibraryQuery 漫画库查询条件
+type LibraryQuery struct {
+	Keyword     string   `json:"q,omitempty"`            // 标题或作者包含的文本
+	Author      string   `json:"author,omitempty"`       // 作者（完全匹配，不区分大小写）
+	Tags        []string `json:"tags,omitempty"`         // 必须同时包含的标签，支持 "namespace:tag"
+	Categories  []string `json:"categories,omitempty"`   // 必须同时包含的分类
+	Type        string   `json:"type,omitempty"`         // 漫画来源类型
+	MinSize     int64    `json:"min_size,omitempty"`     // 最小文件大小（字节）
+	MaxSize     int64    `json:"max_size,omitempty"`     // 最大文件大小（字节）
+	AddedAfter  int64    `json:"added_after,omitempty"`  // 添加时间下限（Unix时间戳）
+	AddedBefore int64    `json:"added_before,omitempty"` // 添加时间上限（Unix时间戳）
+	Unread      bool     `json:"unread,omitempty"`       // 只包含从未阅读过的漫画
+	Sort        string   `json:"sort,omitempty"`         // time, title, size, last_read
+	Order       string   `json:"order,omitempty"`        // asc, desc
+	Page        int      `json:"-"`                      // 页码（从1开始）
+	PageSize    int      `json:"-"`                      // 每页数量，0 表示不分页
+}
+
+// LibraryPage 分页查询结果
+type LibraryPage struct {
+	Comics   []models.ComicDetail `json:"comics"`
+	Total    int                  `json:"total"`
+	Page     int                  `json:"page"`
+	PageSize int                  `json:"page_size"`
+	HasMore  bool                 `json:"has_more"`
+}
+
+// librarySortColumns 允许的排序字段
+var librarySortColumns = map[string]string{
+	"time":      "library.time",
+	"title":     "library.title COLLATE NOCASE",
+	"size":      "library.size",
+	"last_read": "COALESCE(read_activity.last_read, 0)",
+}
+
+// IsValidLibrarySort 判断排序字段是否有效
+func IsValidLibrarySort(sort string) bool {
+	_, ok := librarySortColumns[sort]
+	return ok
+}
+
+// escapeLike 转义 LIKE 模式中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// jsonArrayContains 生成判断 JSON 数组列包含指定值的条件（容忍空值和无效 JSON）
+func jsonArrayContains(column string) string {
+	return fmt.Sprintf(
+		"EXISTS (SELECT 1 FROM json_each(CASE WHEN json_valid(%s) THEN %s ELSE '[]' END) WHERE value = ?)",
+		column, column)
+}
+
+// buildWhere 根据查询条件生成 WHERE 子句和参数
+func (q *LibraryQuery) buildWhere() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	if kw := strings.TrimSpace(q.Keyword); kw != "" {
+		pattern := "%" + escapeLike(kw) + "%"
+		conds = append(conds, `(library.title LIKE ? ESCAPE '\' OR library.author LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	if author := strings.TrimSpace(q.Author); author != "" {
+		conds = append(conds, "library.author = ? COLLATE NOCASE")
+		args = append(args, author)
+	}
+	for _, tag := range q.Tags {
+		cond, condArgs := tagCondition(tag)
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	for _, category := range q.Categories {
+		conds = append(conds, jsonArrayContains("library.categories"))
+		args = append(args, category)
+	}
+	if q.Type != "" {
+		conds = append(conds, "library.type = ?")
+		args = append(args, q.Type)
+	}
+	if q.MinSize > 0 {
+		conds = append(conds, "library.size >= ?")
+		args = append(args, q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		conds = append(conds, "library.size <= ?")
+		args = append(args, q.MaxSize)
+	}
+	if q.AddedAfter > 0 {
+		conds = append(conds, "library.time >= ?")
+		args = append(args, q.AddedAfter)
+	}
+	if q.AddedBefore > 0 {
+		conds = append(conds, "library.time <= ?")
+		args = append(args, q.AddedBefore)
+	}
+
+	if q.Unread {
+		conds = append(conds, "read_activity.last_read IS NULL")
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// orderBy 生成 ORDER BY 子句
+func (q *LibraryQuery) orderBy() string {
+	column, ok := librarySortColumns[q.Sort]
+	if !ok {
+		column = librarySortColumns["time"]
+	}
+	direction := "DESC"
+	if strings.EqualFold(q.Order, "asc") {
+		direction = "ASC"
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, library.id", column, direction)
+}
+
+// libraryFrom 漫画库查询的 FROM 子句（关联最近阅读时间，用于排序和未读过滤）
+const libraryFrom = " FROM library LEFT JOIN read_activity ON read_activity.comic_id = library.id"
+
+// CountLibrary 统计符合条件的漫画数量
+func (dm *DownloadManager) CountLibrary(q LibraryQuery) (int, error) {
+	where, args := q.buildWhere()
+	var total int
+	if err := dm.db.QueryRow(libraryCTE+" SELECT COUNT(*)"+libraryFrom+where, args...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("统计漫画数量失败: %w", err)
+	}
+	return total, nil
+}
+
+// QueryLibrary 按条件搜索、过滤、排序并分页查询漫画库
+func (dm *DownloadManager) QueryLibrary(q LibraryQuery) (*LibraryPage, error) {
+	total, err := dm.CountLibrary(q)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := q.buildWhere()
+	query := libraryCTE + " SELECT " + libraryColumns + libraryFrom + where + q.orderBy()
+
+	page := q.Page
+	if page < 1 {
+		page = 1
+	}
+	if q.PageSize > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, q.PageSize, (page-1)*q.PageSize)
+	}
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询漫画失败: %w", err)
+	}
+	defer rows.Close()
+
+	comics := make([]models.ComicDetail, 0)
+	for rows.Next() {
+		comic, err := scanComicDetail(rows)
+		if err != nil {
+			continue
+		}
+		comics = append(comics, *comic)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &LibraryPage{
+		Comics:   comics,
+		Total:    total,
+		Page:     page,
+		PageSize: q.PageSize,
+	}
+	if q.PageSize > 0 {
+		result.HasMore = page*q.PageSize < total
+	}
+	return result, nil
+}
+
+// readActivityInterval 同一漫画两次写入最近阅读时间的最小间隔，避免每翻一页都写数据库
+const readActivityInterval = time.Minute
+
+// readActivityTracker 记录最近写入时间，用于节流
+type readActivityTracker struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// TouchReadActivity 记录漫画的最近阅读时间（由页面请求触发）
+func (dm *DownloadManager) TouchReadActivity(id string) {
+	now := time.Now()
+
+	dm.readActivity.mu.Lock()
+	if last, ok := dm.readActivity.last[id]; ok && now.Sub(last) < readActivityInterval {
+		dm.readActivity.mu.Unlock()
+		return
+	}
+	dm.readActivity.last[id] = now
+	dm.readActivity.mu.Unlock()
+
+	if _, err := dm.db.Exec(`
+		INSERT INTO read_activity (comic_id, last_read) VALUES (?, ?)
+		ON CONFLICT(comic_id) DO UPDATE SET last_read = excluded.last_read
+	`, id, now.Unix()); err != nil {
+		fmt.Printf("[警告] 记录阅读时间失败: %v\n", err)
+	}
+}
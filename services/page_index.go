@@ -0,0 +1,189 @@
+package services
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// naturalLess 按自然顺序比较文件名：数字部分按数值比较（P2 < P10，img_0009 < img_10），
+// 其它字符不区分大小写；等价时按原始字符串比较，保证顺序稳定
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			si, sj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			na := strings.TrimLeft(a[si:i], "0")
+			nb := strings.TrimLeft(b[sj:j], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		ca, cb := lowerASCII(a[i]), lowerASCII(b[j])
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// sortNatural 按自然顺序排序文件名
+func sortNatural(names []string) {
+	sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+}
+
+// listDirPages 列出目录中的页面图片文件名（按自然顺序排序）
+func listDirPages(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isPageImage(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sortNatural(names)
+	return names, nil
+}
+
+// maxPageIndexEntries 页面索引缓存最多保存的章节数
+const maxPageIndexEntries = 4096
+
+// remotePageIndexTTL 远程存储中章节页面列表的缓存时间（无法廉价地检查是否变化）
+const remotePageIndexTTL = time.Minute
+
+// pageIndexEntry 一个章节目录（或压缩包）的页面列表
+type pageIndexEntry struct {
+	modTime time.Time
+	size    int64
+	names   []string
+	expires time.Time // 远程存储的列表过期时间
+}
+
+// pageIndexCache 章节页面索引：第 N 页即排序后的第 N 张图片，统计页数和读取页面使用同一份列表
+// 按章节路径缓存，目录（或压缩包）的修改时间、大小变化时重新读取
+type pageIndexCache struct {
+	mu      sync.Mutex
+	entries map[string]pageIndexEntry
+}
+
+var pageIndex = &pageIndexCache{entries: make(map[string]pageIndexEntry)}
+
+// names 返回章节的页面文件名（压缩包中为条目名）
+func (pc *pageIndexCache) names(path string, archive bool) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pc.mu.Lock()
+	entry, ok := pc.entries[path]
+	pc.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.names, nil
+	}
+
+	var names []string
+	if archive {
+		names, err = listArchivePages(path)
+	} else {
+		names, err = listDirPages(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pc.put(path, pageIndexEntry{modTime: info.ModTime(), size: info.Size(), names: names})
+	return names, nil
+}
+
+// put 写入缓存，缓存已满时随机淘汰一项
+func (pc *pageIndexCache) put(key string, entry pageIndexEntry) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if _, ok := pc.entries[key]; !ok && len(pc.entries) >= maxPageIndexEntries {
+		for k := range pc.entries {
+			delete(pc.entries, k)
+			break
+		}
+	}
+	pc.entries[key] = entry
+}
+
+// remoteKey 远程存储中章节的缓存键
+func remoteKey(store Storage, prefix string) string {
+	return store.Name() + "://" + prefix
+}
+
+// remoteNames 返回远程存储中章节的页面文件名（前缀下直接存放的页面图片）
+func (pc *pageIndexCache) remoteNames(store Storage, prefix string) ([]string, error) {
+	key := remoteKey(store, prefix)
+	pc.mu.Lock()
+	entry, ok := pc.entries[key]
+	pc.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.names, nil
+	}
+
+	objects, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, obj := range objects {
+		name := strings.TrimPrefix(obj.Key, prefix+"/")
+		if !strings.Contains(name, "/") && isPageImage(name) {
+			names = append(names, name)
+		}
+	}
+	sortNatural(names)
+	pc.putRemote(store, prefix, names)
+	return names, nil
+}
+
+// putRemote 缓存远程存储中章节的页面列表
+func (pc *pageIndexCache) putRemote(store Storage, prefix string, names []string) {
+	pc.put(remoteKey(store, prefix), pageIndexEntry{names: names, expires: time.Now().Add(remotePageIndexTTL)})
+}
+
+// forget 移除远程存储中前缀下所有章节的缓存（写入、删除文件后调用）
+func (pc *pageIndexCache) forget(store Storage, prefix string) {
+	key := remoteKey(store, prefix)
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for k := range pc.entries {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			delete(pc.entries, k)
+		}
+	}
+}
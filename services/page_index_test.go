@@ -0,0 +1,68 @@
+package services
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"P2.jpg", "P10.jpg", true},
+		{"P10.jpg", "P2.jpg", false},
+		{"img_0009.png", "img_10.png", true},
+		{"001.jpg", "1.jpg", true}, // 数值相同时按原始字符串比较，"0" < "1"
+		{"1.jpg", "001.jpg", false},
+		{"a.jpg", "B.jpg", true}, // 不区分大小写
+		{"B.jpg", "a.jpg", false},
+		{"Page.jpg", "page.jpg", true}, // 等价时按原始字符串，保证顺序稳定
+		{"page.jpg", "Page.jpg", false},
+		{"ch1", "ch1-2", true}, // 前缀在前
+		{"ch1-2", "ch1", false},
+		{"Vol.2 Ch.10", "Vol.2 Ch.9", false},
+		{"Vol.2 Ch.9", "Vol.10 Ch.1", true},
+		{"99999999999999999999.jpg", "100000000000000000000.jpg", true}, // 超出 int64 也能比较
+		{"第2话", "第10话", true},
+		{"same.jpg", "same.jpg", false},
+	}
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSortNaturalIsDeterministic(t *testing.T) {
+	want := []string{"001.jpg", "1.jpg", "a2.jpg", "A10.jpg", "B.jpg", "b.jpg", "img_0009.png", "img_10.png", "P1.jpg", "p2.jpg", "P10.jpg"}
+	// 同一组文件名无论原始顺序如何，排序结果都相同
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		names := append([]string(nil), want...)
+		r.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+		sortNatural(names)
+		if !reflect.DeepEqual(names, want) {
+			t.Fatalf("sortNatural = %v, want %v", names, want)
+		}
+	}
+}
+
+func TestListDirPages(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"P10.jpg", "P2.JPG", "p1.webp", "cover.jpg", ".hidden.jpg", "notes.txt", "ComicInfo.xml"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "sub.jpg"), 0755)
+
+	got, err := listDirPages(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"p1.webp", "P2.JPG", "P10.jpg"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listDirPages = %v, want %v", got, want)
+	}
+}
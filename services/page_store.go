@@ -0,0 +1,357 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pageObjectsDir 内容寻址页面存储的目录（位于下载目录中）
+// 每个不同内容的页面只保存一份，按 SHA-256 命名为 .objects/ab/abcdef…，章节目录中的页面是指向它的硬链接
+const pageObjectsDir = ".objects"
+
+// ErrPageStoreUnsupported 当前配置不支持内容寻址存储
+var ErrPageStoreUnsupported = errors.New("内容寻址存储只支持本地存储，且需要文件系统支持硬链接")
+
+// fileID 文件在文件系统中的唯一标识，硬链接指向同一个 fileID
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// pageStore 内容寻址页面存储的状态
+type pageStore struct {
+	mu      sync.Mutex // 同一时间只允许一次去重
+	enabled bool       // 下载、导入完成后自动去重
+}
+
+// DedupResult 一次去重的结果
+type DedupResult struct {
+	DryRun        bool      `json:"dry_run"`        // 只统计，不修改文件
+	Comics        int       `json:"comics"`         // 处理的漫画目录数
+	Files         int       `json:"files"`          // 检查的页面数
+	Linked        int       `json:"linked"`         // 与已保存的内容相同、改为硬链接的页面数
+	AlreadyLinked int       `json:"already_linked"` // 之前已经去重的页面数
+	NewObjects    int       `json:"new_objects"`    // 首次保存到存储中的页面数
+	SavedBytes    int64     `json:"saved_bytes"`    // 本次节省的空间（字节）
+	PrunedObjects int       `json:"pruned_objects"` // 清除的无引用内容数
+	PrunedBytes   int64     `json:"pruned_bytes"`   // 清除的无引用内容大小（字节）
+	Errors        []string  `json:"errors,omitempty"`
+	Duration      string    `json:"duration"`
+	Time          time.Time `json:"time"`
+}
+
+// DedupStats 内容寻址存储的空间统计
+type DedupStats struct {
+	Enabled           bool  `json:"enabled"`            // 是否在下载、导入完成后自动去重
+	Objects           int   `json:"objects"`            // 存储中不同内容的页面数
+	ObjectBytes       int64 `json:"object_bytes"`       // 实际占用的空间（字节）
+	References        int   `json:"references"`         // 引用这些内容的页面文件数（含回收站）
+	LogicalBytes      int64 `json:"logical_bytes"`      // 不去重时这些页面占用的空间（字节）
+	SavedBytes        int64 `json:"saved_bytes"`        // 节省的空间（字节）
+	Unreferenced      int   `json:"unreferenced"`       // 已无页面引用、等待清除的内容数
+	UnreferencedBytes int64 `json:"unreferenced_bytes"` // 等待清除的内容大小（字节）
+}
+
+// SetPageDedup 设置下载、导入完成后是否自动把页面存入内容寻址存储
+func (dm *DownloadManager) SetPageDedup(enabled bool) error {
+	if enabled {
+		if err := dm.checkPageStore(); err != nil {
+			return err
+		}
+	}
+	dm.pageStore.mu.Lock()
+	dm.pageStore.enabled = enabled
+	dm.pageStore.mu.Unlock()
+	return nil
+}
+
+// checkPageStore 检查是否可以使用内容寻址存储：需要本地存储，且文件系统支持硬链接
+func (dm *DownloadManager) checkPageStore() error {
+	if dm.remoteStorage() {
+		return ErrPageStoreUnsupported
+	}
+	info, err := os.Stat(dm.downloadPath)
+	if err != nil {
+		return err
+	}
+	if _, _, ok := fileLinkInfo(info); !ok {
+		return ErrPageStoreUnsupported
+	}
+	return nil
+}
+
+// pageObjectPath 内容哈希对应的存储路径
+func (dm *DownloadManager) pageObjectPath(hash string) string {
+	return filepath.Join(dm.downloadPath, pageObjectsDir, hash[:2], hash)
+}
+
+// fileSHA256 计算文件内容的 SHA-256
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// walkPageObjects 遍历存储中的全部内容
+func (dm *DownloadManager) walkPageObjects(fn func(path, hash string, info os.FileInfo) error) error {
+	root := filepath.Join(dm.downloadPath, pageObjectsDir)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(p, d.Name(), info)
+	})
+	return err
+}
+
+// DedupLibrary 对整个库执行一次去重：把内容相同的页面替换为指向同一份内容的硬链接，并清除已无引用的内容
+// dryRun 为 true 时只统计可以节省的空间，不修改文件
+func (dm *DownloadManager) DedupLibrary(dryRun bool) (*DedupResult, error) {
+	if err := dm.checkPageStore(); err != nil {
+		return nil, err
+	}
+	dm.pageStore.mu.Lock()
+	defer dm.pageStore.mu.Unlock()
+
+	start := time.Now()
+	result := &DedupResult{DryRun: dryRun, Time: start}
+
+	// 已保存的内容：用于识别已经去重的页面，免去重新计算哈希
+	stored := make(map[fileID]bool)
+	known := make(map[string]bool)
+	err := dm.walkPageObjects(func(p, hash string, info os.FileInfo) error {
+		if id, _, ok := fileLinkInfo(info); ok {
+			stored[id] = true
+		}
+		known[hash] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("读取页面存储失败: %w", err)
+	}
+
+	entries, err := os.ReadDir(dm.downloadPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取下载目录失败: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		result.Comics++
+		dm.dedupTree(filepath.Join(dm.downloadPath, entry.Name()), result, stored, known)
+	}
+
+	if !dryRun {
+		result.PrunedObjects, result.PrunedBytes = dm.prunePageObjects()
+	}
+
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	log.Printf("[页面去重] 完成: 检查 %d 页, 新链接 %d, 新内容 %d, 节省 %.2f MB, 清除 %d, 耗时 %s",
+		result.Files, result.Linked, result.NewObjects, float64(result.SavedBytes)/(1024*1024), result.PrunedObjects, result.Duration)
+	return result, nil
+}
+
+// dedupTree 对目录中的页面图片去重
+// stored 为已保存内容的文件标识，为 nil 时把有多个硬链接的文件视为已去重；known 记录已保存的内容哈希
+func (dm *DownloadManager) dedupTree(root string, result *DedupResult, stored map[fileID]bool, known map[string]bool) {
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !isPageImage(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		result.Files++
+
+		id, nlink, _ := fileLinkInfo(info)
+		if (stored != nil && stored[id]) || (stored == nil && nlink > 1) {
+			result.AlreadyLinked++
+			return nil
+		}
+
+		hash, err := fileSHA256(p)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", p, err))
+			return nil
+		}
+		if result.DryRun {
+			if known[hash] {
+				result.Linked++
+				result.SavedBytes += info.Size()
+			} else {
+				known[hash] = true
+				result.NewObjects++
+			}
+			return nil
+		}
+
+		linked, err := dm.storePageObject(p, hash, info.Size())
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", p, err))
+			return nil
+		}
+		if linked {
+			result.Linked++
+			result.SavedBytes += info.Size()
+		} else {
+			// 页面本身成为了存储文件
+			result.NewObjects++
+			if stored != nil {
+				stored[id] = true
+			}
+		}
+		known[hash] = true
+		return nil
+	})
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", root, err))
+	}
+}
+
+// storePageObject 把页面存入内容寻址存储：内容已存在时把页面替换为指向它的硬链接（返回 true），
+// 否则把页面本身链接为该内容的存储文件
+func (dm *DownloadManager) storePageObject(path, hash string, size int64) (bool, error) {
+	objectPath := dm.pageObjectPath(hash)
+	info, err := os.Stat(objectPath)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+			return false, err
+		}
+		return false, os.Link(path, objectPath)
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != size {
+		return false, fmt.Errorf("内容与已保存的文件 %s 大小不一致", hash)
+	}
+
+	// 先在同一目录中创建硬链接再替换，页面在任何时刻都是完整的
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".dedup")
+	os.Remove(tmp)
+	if err := os.Link(objectPath, tmp); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// dedupComic 开启自动去重时，对刚下载、导入或合并的页面去重（失败只记录日志）
+func (dm *DownloadManager) dedupComic(path string) {
+	dm.pageStore.mu.Lock()
+	defer dm.pageStore.mu.Unlock()
+	if !dm.pageStore.enabled || dm.remoteStorage() {
+		return
+	}
+
+	result := &DedupResult{}
+	dm.dedupTree(path, result, nil, make(map[string]bool))
+	for _, e := range result.Errors {
+		log.Printf("[页面去重] 警告: %s", e)
+	}
+	if result.Linked > 0 {
+		log.Printf("[页面去重] %s: %d 页与已有页面相同，节省 %.2f MB", filepath.Base(path), result.Linked, float64(result.SavedBytes)/(1024*1024))
+	}
+}
+
+// prunePageObjects 清除已无页面引用的内容（只剩存储中的一个链接），返回清除的数量和大小
+func (dm *DownloadManager) prunePageObjects() (int, int64) {
+	var count int
+	var size int64
+	err := dm.walkPageObjects(func(p, hash string, info os.FileInfo) error {
+		if _, nlink, ok := fileLinkInfo(info); ok && nlink == 1 {
+			if err := os.Remove(p); err == nil {
+				count++
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[页面去重] 清除无引用内容失败: %v", err)
+	}
+	return count, size
+}
+
+// collectPageObjects 开启自动去重时，删除漫画后清除已无引用的内容
+func (dm *DownloadManager) collectPageObjects() {
+	dm.pageStore.mu.Lock()
+	defer dm.pageStore.mu.Unlock()
+	if !dm.pageStore.enabled {
+		return
+	}
+	if n, size := dm.prunePageObjects(); n > 0 {
+		log.Printf("[页面去重] 已清除 %d 个无引用的页面（%.2f MB）", n, float64(size)/(1024*1024))
+	}
+}
+
+// GetDedupStats 统计内容寻址存储节省的空间
+func (dm *DownloadManager) GetDedupStats() (*DedupStats, error) {
+	dm.pageStore.mu.Lock()
+	stats := &DedupStats{Enabled: dm.pageStore.enabled}
+	dm.pageStore.mu.Unlock()
+
+	err := dm.walkPageObjects(func(p, hash string, info os.FileInfo) error {
+		_, nlink, ok := fileLinkInfo(info)
+		if !ok {
+			return nil
+		}
+		if nlink <= 1 {
+			stats.Unreferenced++
+			stats.UnreferencedBytes += info.Size()
+			return nil
+		}
+		refs := int64(nlink - 1)
+		stats.Objects++
+		stats.ObjectBytes += info.Size()
+		stats.References += int(refs)
+		stats.LogicalBytes += info.Size() * refs
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("读取页面存储失败: %w", err)
+	}
+	stats.SavedBytes = stats.LogicalBytes - stats.ObjectBytes
+	return stats, nil
+}
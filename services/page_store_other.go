@@ -0,0 +1,10 @@
+//go:build !unix
+
+package services
+
+import "os"
+
+// fileLinkInfo 当前系统无法读取硬链接数，不支持内容寻址存储
+func fileLinkInfo(info os.FileInfo) (fileID, uint64, bool) {
+	return fileID{}, 0, false
+}
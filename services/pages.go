@@ -0,0 +1,422 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pica-comic-server/models"
+)
+
+// PageRef 一张页面图片：普通图片文件、压缩包中的一个条目，或远程存储中的文件
+type PageRef struct {
+	Path  string // 图片文件路径，或压缩包路径；位于远程存储中时为存储键
+	Entry string // 压缩包内的文件名，为空表示普通文件
+
+	store Storage // 页面所在的远程存储，为空表示本地文件
+}
+
+// InArchive 是否位于压缩包中
+func (p PageRef) InArchive() bool {
+	return p.Entry != ""
+}
+
+// InStorage 是否位于远程存储中
+func (p PageRef) InStorage() bool {
+	return p.store != nil
+}
+
+// Name 页面文件名（用于判断图片格式）
+func (p PageRef) Name() string {
+	if p.InArchive() {
+		return path.Base(p.Entry)
+	}
+	if p.InStorage() {
+		return path.Base(p.Path)
+	}
+	return filepath.Base(p.Path)
+}
+
+// String 唯一标识该页面，用于日志和缓存键
+func (p PageRef) String() string {
+	if p.InArchive() {
+		return p.Path + "!" + p.Entry
+	}
+	if p.InStorage() {
+		return p.store.Name() + "://" + p.Path
+	}
+	return p.Path
+}
+
+// Open 打开页面数据，返回数据和大小
+func (p PageRef) Open() (io.ReadCloser, int64, error) {
+	if p.InArchive() {
+		return openArchiveEntry(p.Path, p.Entry)
+	}
+	if p.InStorage() {
+		return p.store.Open(p.Path)
+	}
+	f, err := os.Open(p.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// ReadAll 读取整张页面
+func (p PageRef) ReadAll() ([]byte, error) {
+	rc, _, err := p.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// Stat 返回页面大小和修改时间（压缩包中的页面使用压缩包的修改时间）
+func (p PageRef) Stat() (int64, time.Time, error) {
+	if p.InStorage() {
+		obj, err := p.store.Stat(p.Path)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		return obj.Size, obj.ModTime, nil
+	}
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if !p.InArchive() {
+		return info.Size(), info.ModTime(), nil
+	}
+	rc, size, err := openArchiveEntry(p.Path, p.Entry)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	rc.Close()
+	return size, info.ModTime(), nil
+}
+
+// Decode 解码页面图片
+func (p PageRef) Decode() (image.Image, error) {
+	if !p.InArchive() && !p.InStorage() {
+		img, _, err := decodeImageFile(p.Path)
+		return img, err
+	}
+	data, err := p.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// remove 删除页面文件
+func (p PageRef) remove() error {
+	if p.InStorage() {
+		return p.store.Delete(p.Path)
+	}
+	return os.Remove(p.Path)
+}
+
+// episodeSource 章节的来源：章节目录，或作为章节的压缩包
+type episodeSource struct {
+	ep      int
+	title   string
+	path    string // 本地路径；位于远程存储中时为存储键
+	archive bool
+	root    bool    // 漫画根目录中直接存放的页面，或下载目录根部的压缩包
+	store   Storage // 章节所在的远程存储，为空表示本地文件
+}
+
+// episodeEntry 漫画目录中可能作为章节的一项：子目录或压缩包
+type episodeEntry struct {
+	name    string
+	archive bool
+}
+
+// rootEpisodeName 章节号表中表示漫画根目录中页面的名称
+const rootEpisodeName = ""
+
+// listEpisodeSources 列出漫画中的章节：
+// 数字命名的子目录和压缩包（如 1/、2.cbz）使用该数字作为章节号，同号时目录优先；
+// 其它含有页面的子目录（如 Vol.1、Chapter 02）和压缩包按名称自然排序，名称作为章节名；
+// 直接放在漫画目录中的页面作为最后一个章节，下载目录根部的压缩包作为唯一的章节。
+// 非数字命名的章节和根目录中的页面使用 numbers 中记录的章节号（名称 -> 章节号），
+// 没有记录的按顺序接在已用过的最大章节号之后并写入 numbers，返回值 changed 表示 numbers 有新增；
+// 这样增删其它章节后已有章节的编号不变，numbers 为空时不记录
+func listEpisodeSources(comicPath string, numbers map[string]int) (sources []episodeSource, changed bool) {
+	if info, err := os.Stat(comicPath); err == nil && !info.IsDir() {
+		if !isArchiveFile(comicPath) {
+			return nil, false
+		}
+		return []episodeSource{{ep: 1, title: "第 1 话", path: comicPath, archive: true, root: true}}, false
+	}
+
+	entries, err := os.ReadDir(comicPath)
+	if err != nil {
+		return nil, false
+	}
+
+	var items []episodeEntry
+	rootPages := false
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		archive := !entry.IsDir() && isArchiveFile(name)
+		if !entry.IsDir() && !archive {
+			if isPageImage(name) {
+				rootPages = true
+			}
+			continue
+		}
+		items = append(items, episodeEntry{name: name, archive: archive})
+	}
+
+	hasPages := func(name string) bool {
+		pages, err := pageIndex.names(filepath.Join(comicPath, name), false)
+		return err == nil && len(pages) > 0
+	}
+	join := func(name string) string {
+		return filepath.Join(comicPath, name)
+	}
+	return buildEpisodeSources(items, hasPages, rootPages, join, comicPath, numbers)
+}
+
+// listStorageEpisodeSources 列出远程存储中漫画的章节，规则与 listEpisodeSources 相同
+// 只支持图片文件，远程存储中的压缩包不作为章节；列出的页面同时写入页面索引，避免逐章节再次列出
+func listStorageEpisodeSources(store Storage, directory string, numbers map[string]int) (sources []episodeSource, changed bool) {
+	if isArchiveFile(directory) {
+		return nil, false
+	}
+	objects, err := store.List(directory)
+	if err != nil {
+		return nil, false
+	}
+
+	dirPages := make(map[string][]string)
+	var rootNames []string
+	for _, obj := range objects {
+		rel := strings.TrimPrefix(obj.Key, directory+"/")
+		first, rest, nested := strings.Cut(rel, "/")
+		if strings.HasPrefix(first, ".") {
+			continue
+		}
+		if !nested {
+			if isPageImage(first) {
+				rootNames = append(rootNames, first)
+			}
+			continue
+		}
+		if !strings.Contains(rest, "/") && isPageImage(rest) {
+			dirPages[first] = append(dirPages[first], rest)
+		}
+	}
+
+	var items []episodeEntry
+	for name, pages := range dirPages {
+		sortNatural(pages)
+		pageIndex.putRemote(store, joinStorageKey(directory, name), pages)
+		items = append(items, episodeEntry{name: name})
+	}
+	sortNatural(rootNames)
+	pageIndex.putRemote(store, directory, rootNames)
+
+	hasPages := func(name string) bool {
+		return len(dirPages[name]) > 0
+	}
+	join := func(name string) string {
+		return joinStorageKey(directory, name)
+	}
+	sources, changed = buildEpisodeSources(items, hasPages, len(rootNames) > 0, join, directory, numbers)
+	for i := range sources {
+		sources[i].store = store
+	}
+	return sources, changed
+}
+
+// buildEpisodeSources 按 listEpisodeSources 的规则为子目录、压缩包和根目录中的页面分配章节号
+// hasPages 判断非数字命名的子目录中是否有页面，join 返回子项的路径，numbers 为已分配的章节号
+func buildEpisodeSources(items []episodeEntry, hasPages func(name string) bool, rootPages bool, join func(name string) string, rootPath string, numbers map[string]int) ([]episodeSource, bool) {
+	if numbers == nil {
+		numbers = make(map[string]int)
+	}
+	byEp := make(map[int]episodeSource)
+	var named []string
+	namedArchive := make(map[string]bool)
+	maxEp := 0
+	for _, item := range items {
+		name, archive := item.name, item.archive
+		epNum, err := strconv.Atoi(archiveStem(name))
+		if err != nil || epNum <= 0 {
+			if archive {
+				named = append(named, name)
+				namedArchive[name] = true
+			} else if hasPages(name) {
+				named = append(named, name)
+			}
+			continue
+		}
+		if existing, ok := byEp[epNum]; ok && !existing.archive {
+			continue
+		}
+		byEp[epNum] = episodeSource{
+			ep:      epNum,
+			title:   fmt.Sprintf("第 %d 话", epNum),
+			path:    join(name),
+			archive: archive,
+		}
+		if epNum > maxEp {
+			maxEp = epNum
+		}
+	}
+
+	// 新章节接在用过的最大章节号之后（包括已删除章节记录的编号，避免被新章节复用）
+	nextEp := maxEp
+	for _, ep := range numbers {
+		if ep > nextEp {
+			nextEp = ep
+		}
+	}
+	changed := false
+	numberOf := func(name string) int {
+		// 记录的编号被同号的数字章节占用时重新分配
+		if ep, ok := numbers[name]; ok && ep > 0 {
+			if _, taken := byEp[ep]; !taken {
+				return ep
+			}
+		}
+		nextEp++
+		numbers[name] = nextEp
+		changed = true
+		return nextEp
+	}
+
+	sortNatural(named)
+	for _, name := range named {
+		ep := numberOf(name)
+		byEp[ep] = episodeSource{
+			ep:      ep,
+			title:   archiveStem(name),
+			path:    join(name),
+			archive: namedArchive[name],
+		}
+	}
+
+	if rootPages {
+		others := len(byEp) > 0
+		ep := numberOf(rootEpisodeName)
+		title := fmt.Sprintf("第 %d 话", ep)
+		if others {
+			title = "其它页面"
+		}
+		byEp[ep] = episodeSource{ep: ep, title: title, path: rootPath, root: true}
+	}
+
+	sources := make([]episodeSource, 0, len(byEp))
+	for _, src := range byEp {
+		sources = append(sources, src)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].ep < sources[j].ep })
+	return sources, changed
+}
+
+// episodeSourceOf 获取漫画指定章节的来源，ep 为 0 表示漫画根目录（或作为整部漫画的压缩包）
+func (dm *DownloadManager) episodeSourceOf(comic *models.ComicDetail, ep int) (episodeSource, error) {
+	if ep == 0 {
+		if dm.isStoredRemotely(comic.Directory) {
+			return episodeSource{path: comic.Directory, root: true, store: dm.storage}, nil
+		}
+		comicPath := filepath.Join(dm.downloadPath, comic.Directory)
+		return episodeSource{path: comicPath, archive: isArchiveFile(comicPath), root: true}, nil
+	}
+	for _, src := range dm.comicSources(comic.Directory) {
+		if src.ep == ep {
+			return src, nil
+		}
+	}
+	return episodeSource{}, ErrEpisodeNotFound
+}
+
+// names 列出章节中的页面文件名
+func (src episodeSource) names() ([]string, error) {
+	if src.store != nil {
+		return pageIndex.remoteNames(src.store, src.path)
+	}
+	return pageIndex.names(src.path, src.archive)
+}
+
+// pages 列出章节中的所有页面（按文件名自然排序）
+func (src episodeSource) pages() ([]PageRef, error) {
+	names, err := src.names()
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]PageRef, len(names))
+	for i, name := range names {
+		if src.store != nil {
+			refs[i] = PageRef{Path: joinStorageKey(src.path, name), store: src.store}
+		} else if src.archive {
+			refs[i] = PageRef{Path: src.path, Entry: name}
+		} else {
+			refs[i] = PageRef{Path: filepath.Join(src.path, name)}
+		}
+	}
+	return refs, nil
+}
+
+// pageCount 统计章节的页面数量
+func (src episodeSource) pageCount() int {
+	names, err := src.names()
+	if err != nil {
+		return 0
+	}
+	return len(names)
+}
+
+// removeAll 删除整个章节（目录或压缩包）
+func (src episodeSource) removeAll() error {
+	if src.store != nil {
+		err := src.store.DeleteAll(src.path)
+		pageIndex.forget(src.store, src.path)
+		return err
+	}
+	return os.RemoveAll(src.path)
+}
+
+// GetPage 获取页面（page 从1开始）
+// 第 N 页为章节中按文件名自然排序后的第 N 张图片，与页数统计一致，不要求特定的命名格式
+func (dm *DownloadManager) GetPage(id string, ep int, page int) (PageRef, error) {
+	comic, err := dm.GetComic(id)
+	if err != nil {
+		return PageRef{}, err
+	}
+	src, err := dm.episodeSourceOf(comic, ep)
+	if err != nil {
+		return PageRef{}, err
+	}
+
+	pages, err := src.pages()
+	if err != nil {
+		return PageRef{}, err
+	}
+	if page < 1 || page > len(pages) {
+		return PageRef{}, fmt.Errorf("%w: page=%d", ErrPageNotFound, page)
+	}
+	return pages[page-1], nil
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListEpisodeSourcesKeepsNumbers(t *testing.T) {
+	dir := t.TempDir()
+	addPage := func(rel string) {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte("x"), 0644)
+	}
+	removeDir := func(name string) {
+		os.RemoveAll(filepath.Join(dir, name))
+	}
+
+	// 每一步修改目录后重新列出章节，numbers 模拟数据库中记录的章节号
+	steps := []struct {
+		name   string
+		change func()
+		want   map[int]string // 章节号 -> 章节名
+	}{
+		{
+			name:   "initial numbering follows numeric episodes and natural order",
+			change: func() { addPage("Vol.2/a.jpg"); addPage("Vol.10/a.jpg"); addPage("Vol.1/a.jpg"); addPage("001.jpg") },
+			want:   map[int]string{1: "Vol.1", 2: "Vol.2", 3: "Vol.10", 4: "其它页面"},
+		},
+		{
+			name:   "removing a lower-numbered folder keeps the others",
+			change: func() { removeDir("Vol.1") },
+			want:   map[int]string{2: "Vol.2", 3: "Vol.10", 4: "其它页面"},
+		},
+		{
+			name:   "adding a numeric folder below keeps the others",
+			change: func() { addPage("1/a.jpg") },
+			want:   map[int]string{1: "第 1 话", 2: "Vol.2", 3: "Vol.10", 4: "其它页面"},
+		},
+		{
+			name:   "new named folder gets the next unused number",
+			change: func() { addPage("Extra/a.jpg") },
+			want:   map[int]string{1: "第 1 话", 2: "Vol.2", 3: "Vol.10", 4: "其它页面", 5: "Extra"},
+		},
+		{
+			name:   "restored folder whose number is taken by a numeric folder is renumbered",
+			change: func() { addPage("Vol.1/a.jpg") },
+			want:   map[int]string{1: "第 1 话", 2: "Vol.2", 3: "Vol.10", 4: "其它页面", 5: "Extra", 6: "Vol.1"},
+		},
+		{
+			name:   "numeric folder takes over a recorded number",
+			change: func() { addPage("5/a.jpg") },
+			want:   map[int]string{1: "第 1 话", 2: "Vol.2", 3: "Vol.10", 4: "其它页面", 5: "第 5 话", 6: "Vol.1", 7: "Extra"},
+		},
+		{
+			name: "removing every named folder keeps root pages in place",
+			change: func() {
+				removeDir("Vol.1")
+				removeDir("Vol.2")
+				removeDir("Vol.10")
+				removeDir("Extra")
+				removeDir("5")
+			},
+			want: map[int]string{1: "第 1 话", 4: "其它页面"},
+		},
+		{
+			name:   "deleted numbers are not reused",
+			change: func() { addPage("Bonus/a.jpg") },
+			want:   map[int]string{1: "第 1 话", 4: "其它页面", 8: "Bonus"},
+		},
+	}
+
+	numbers := make(map[string]int)
+	for _, step := range steps {
+		step.change()
+		sources, _ := listEpisodeSources(dir, numbers)
+		got := make(map[int]string, len(sources))
+		for _, src := range sources {
+			got[src.ep] = src.title
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s: episodes = %v, want %v", step.name, got, step.want)
+		}
+		if _, changed := listEpisodeSources(dir, numbers); changed {
+			t.Fatalf("%s: listing again assigned new numbers", step.name)
+		}
+	}
+}
+
+func TestListEpisodeSourcesWithoutNumbers(t *testing.T) {
+	dir := t.TempDir()
+	for _, rel := range []string{"2/a.jpg", "Vol.2/a.jpg", "Vol.1/a.jpg", "empty/notes.txt"} {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte("x"), 0644)
+	}
+
+	sources, changed := listEpisodeSources(dir, nil)
+	got := make(map[int]string, len(sources))
+	for _, src := range sources {
+		got[src.ep] = src.title
+	}
+	if want := map[int]string{2: "第 2 话", 3: "Vol.1", 4: "Vol.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("episodes = %v, want %v", got, want)
+	}
+	if !changed {
+		t.Error("new named folders should be reported as changed")
+	}
+}
@@ -0,0 +1,456 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidSession 阅读记录参数无效
+var ErrInvalidSession = errors.New("无效的阅读记录")
+
+// sessionIdleTimeout 同一会话两次翻页间隔超过该时间时视为新的阅读记录
+const sessionIdleTimeout = 30 * time.Minute
+
+// sessionFlushInterval 推断的阅读记录写入数据库的间隔
+const sessionFlushInterval = 10 * time.Second
+
+// ReadingSession 一次阅读记录
+type ReadingSession struct {
+	ID          string    `json:"id"`
+	ComicID     string    `json:"comic_id"`
+	ComicTitle  string    `json:"comic_title"` // 漫画已删除时为空
+	Ep          int       `json:"ep"`
+	PagesViewed int       `json:"pages_viewed"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	Duration    int64     `json:"duration"` // 阅读时长（秒）
+	Source      string    `json:"source"`   // client: 客户端上报, inferred: 由页面请求推断
+	Device      string    `json:"device"`
+	SessionID   string    `json:"session_id,omitempty"` // 推断记录对应的客户端会话ID
+}
+
+// SessionInput 客户端上报阅读记录的参数
+type SessionInput struct {
+	ComicID     string    `json:"comic_id" binding:"required"`
+	Ep          int       `json:"ep"`
+	PagesViewed int       `json:"pages_viewed"`
+	StartTime   time.Time `json:"start_time" binding:"required"`
+	EndTime     time.Time `json:"end_time" binding:"required"`
+	Device      string    `json:"device"`
+}
+
+// HistoryQuery 阅读记录查询条件
+type HistoryQuery struct {
+	ComicID  string
+	From     int64 // 开始时间下限（Unix时间戳）
+	To       int64 // 开始时间上限（Unix时间戳）
+	Page     int
+	PageSize int
+}
+
+// HistoryPage 阅读记录分页结果
+type HistoryPage struct {
+	Sessions []ReadingSession `json:"sessions"`
+	Total    int              `json:"total"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+	HasMore  bool             `json:"has_more"`
+}
+
+// DailyReading 每日阅读统计
+type DailyReading struct {
+	Date     string `json:"date"` // 本地日期 2006-01-02
+	Sessions int    `json:"sessions"`
+	Pages    int    `json:"pages"`
+	Seconds  int64  `json:"seconds"`
+}
+
+// RankedItem 作者或标签的阅读排行
+type RankedItem struct {
+	Name   string `json:"name"`
+	Comics int    `json:"comics"` // 读过的漫画数
+	Pages  int    `json:"pages"`  // 阅读页数
+}
+
+// ReadingStats 阅读统计
+type ReadingStats struct {
+	Days        int            `json:"days"` // 统计的天数（含今天）
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Sessions    int            `json:"sessions"`
+	Comics      int            `json:"comics"`
+	Pages       int            `json:"pages"`
+	Seconds     int64          `json:"seconds"`
+	ActiveDays  int            `json:"active_days"`
+	PagesPerDay float64        `json:"pages_per_day"`
+	Daily       []DailyReading `json:"daily"`
+	TopAuthors  []RankedItem   `json:"top_authors"`
+	TopTags     []RankedItem   `json:"top_tags"`
+}
+
+// inferredSession 由页面请求推断的进行中的阅读会话
+type inferredSession struct {
+	id        string
+	sessionID string
+	comicID   string
+	ep        int
+	pages     map[int]bool
+	start     time.Time
+	last      time.Time
+	dirty     bool // 有尚未写入数据库的翻页
+}
+
+// sessionTracker 记录进行中的推断会话，键为 "会话ID|漫画ID|章节"
+type sessionTracker struct {
+	mu       sync.Mutex
+	sessions map[string]*inferredSession
+	flushMu  sync.Mutex // 保证写入按顺序进行
+}
+
+// RecordPageView 记录带会话ID的页面请求，合并为阅读记录
+// 同一会话在同一章节内连续翻页（间隔不超过 sessionIdleTimeout）记为一条
+// 只更新内存中的会话，由后台每隔 sessionFlushInterval 批量写入数据库，不阻塞图片请求
+func (dm *DownloadManager) RecordPageView(sessionID, comicID string, ep, page int) {
+	now := time.Now()
+	key := fmt.Sprintf("%s|%s|%d", sessionID, comicID, ep)
+
+	dm.sessions.mu.Lock()
+	defer dm.sessions.mu.Unlock()
+	s, ok := dm.sessions.sessions[key]
+	if !ok || now.Sub(s.last) > sessionIdleTimeout {
+		if ok && s.dirty {
+			// 旧会话还没有写入，换成新的键保留到下次写入
+			dm.sessions.sessions[key+"|"+s.id] = s
+		}
+		s = &inferredSession{id: uuid.New().String(), sessionID: sessionID, comicID: comicID, ep: ep, pages: make(map[int]bool), start: now}
+		dm.sessions.sessions[key] = s
+	}
+	s.pages[page] = true
+	s.last = now
+	s.dirty = true
+}
+
+// StartSessionFlusher 启动后台任务，定期把推断的阅读记录写入数据库
+func (dm *DownloadManager) StartSessionFlusher() {
+	go func() {
+		ticker := time.NewTicker(sessionFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			dm.flushPageViews()
+		}
+	}()
+}
+
+// flushPageViews 将有新翻页的推断会话写入数据库（一个事务），并清理已过期的会话
+// 写入成功后才清除会话的未写入标记和移除过期会话，失败时保留到下次写入
+func (dm *DownloadManager) flushPageViews() error {
+	dm.sessions.flushMu.Lock()
+	defer dm.sessions.flushMu.Unlock()
+
+	type pendingView struct {
+		key                    string
+		session                *inferredSession
+		id, sessionID, comicID string
+		ep, pages              int
+		start, end             time.Time
+	}
+	now := time.Now()
+	var pending []pendingView
+	dm.sessions.mu.Lock()
+	for k, s := range dm.sessions.sessions {
+		if s.dirty {
+			pending = append(pending, pendingView{k, s, s.id, s.sessionID, s.comicID, s.ep, len(s.pages), s.start, s.last})
+		} else if now.Sub(s.last) > sessionIdleTimeout {
+			delete(dm.sessions.sessions, k)
+		}
+	}
+	dm.sessions.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := func() error {
+		tx, err := dm.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		stmt, err := tx.Prepare(`
+			INSERT INTO reading_sessions (id, comic_id, ep, pages_viewed, start_time, end_time, source, session_id)
+			VALUES (?, ?, ?, ?, ?, ?, 'inferred', ?)
+			ON CONFLICT(id) DO UPDATE SET pages_viewed = excluded.pages_viewed, end_time = excluded.end_time
+		`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, v := range pending {
+			if _, err := stmt.Exec(v.id, v.comicID, v.ep, v.pages, v.start.Unix(), v.end.Unix(), v.sessionID); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		fmt.Printf("[警告] 记录阅读会话失败: %v\n", err)
+		return err
+	}
+
+	dm.sessions.mu.Lock()
+	defer dm.sessions.mu.Unlock()
+	for _, v := range pending {
+		s := v.session
+		// 写入期间又有新的翻页时保留标记，下次再写入
+		if s.last.Equal(v.end) && len(s.pages) == v.pages {
+			s.dirty = false
+		}
+		if !s.dirty && now.Sub(s.last) > sessionIdleTimeout && dm.sessions.sessions[v.key] == s {
+			delete(dm.sessions.sessions, v.key)
+		}
+	}
+	return nil
+}
+
+// AddReadingSession 保存客户端上报的阅读记录
+func (dm *DownloadManager) AddReadingSession(input SessionInput) (*ReadingSession, error) {
+	comic, err := dm.GetComic(input.ComicID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: 漫画不存在 %s", ErrInvalidSession, input.ComicID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if input.EndTime.Before(input.StartTime) {
+		return nil, fmt.Errorf("%w: 结束时间早于开始时间", ErrInvalidSession)
+	}
+	if input.PagesViewed < 0 {
+		return nil, fmt.Errorf("%w: 页数不能为负数", ErrInvalidSession)
+	}
+
+	session := &ReadingSession{
+		ID:          uuid.New().String(),
+		ComicID:     input.ComicID,
+		ComicTitle:  comic.Title,
+		Ep:          input.Ep,
+		PagesViewed: input.PagesViewed,
+		StartTime:   input.StartTime,
+		EndTime:     input.EndTime,
+		Duration:    input.EndTime.Unix() - input.StartTime.Unix(),
+		Source:      "client",
+		Device:      strings.TrimSpace(input.Device),
+	}
+	_, err = dm.db.Exec(`
+		INSERT INTO reading_sessions (id, comic_id, ep, pages_viewed, start_time, end_time, source, device)
+		VALUES (?, ?, ?, ?, ?, ?, 'client', ?)
+	`, session.ID, session.ComicID, session.Ep, session.PagesViewed,
+		session.StartTime.Unix(), session.EndTime.Unix(), session.Device)
+	if err != nil {
+		return nil, fmt.Errorf("保存阅读记录失败: %w", err)
+	}
+
+	// 同步最近阅读时间
+	if _, err := dm.db.Exec(`
+		INSERT INTO read_activity (comic_id, last_read) VALUES (?, ?)
+		ON CONFLICT(comic_id) DO UPDATE SET last_read = MAX(last_read, excluded.last_read)
+	`, input.ComicID, input.EndTime.Unix()); err != nil {
+		fmt.Printf("[警告] 记录阅读时间失败: %v\n", err)
+	}
+
+	return session, nil
+}
+
+// GetReadingHistory 按开始时间倒序查询阅读记录
+func (dm *DownloadManager) GetReadingHistory(q HistoryQuery) (*HistoryPage, error) {
+	dm.flushPageViews()
+	var conds []string
+	var args []interface{}
+	if q.ComicID != "" {
+		conds = append(conds, "s.comic_id = ?")
+		args = append(args, q.ComicID)
+	}
+	if q.From > 0 {
+		conds = append(conds, "s.start_time >= ?")
+		args = append(args, q.From)
+	}
+	if q.To > 0 {
+		conds = append(conds, "s.start_time <= ?")
+		args = append(args, q.To)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	result := &HistoryPage{Sessions: make([]ReadingSession, 0), Page: q.Page, PageSize: q.PageSize}
+	if result.Page < 1 {
+		result.Page = 1
+	}
+	if err := dm.db.QueryRow("SELECT COUNT(*) FROM reading_sessions s"+where, args...).Scan(&result.Total); err != nil {
+		return nil, fmt.Errorf("统计阅读记录失败: %w", err)
+	}
+
+	query := libraryCTE + `
+		SELECT s.id, s.comic_id, COALESCE(library.title, ''), s.ep, s.pages_viewed,
+		       s.start_time, s.end_time, s.source, COALESCE(s.device, ''), COALESCE(s.session_id, '')
+		FROM reading_sessions s
+		LEFT JOIN library ON library.id = s.comic_id` + where + `
+		ORDER BY s.start_time DESC, s.id`
+	if q.PageSize > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, q.PageSize, (result.Page-1)*q.PageSize)
+	}
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询阅读记录失败: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s ReadingSession
+		var start, end int64
+		if err := rows.Scan(&s.ID, &s.ComicID, &s.ComicTitle, &s.Ep, &s.PagesViewed,
+			&start, &end, &s.Source, &s.Device, &s.SessionID); err != nil {
+			continue
+		}
+		s.StartTime = time.Unix(start, 0)
+		s.EndTime = time.Unix(end, 0)
+		s.Duration = end - start
+		result.Sessions = append(result.Sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if q.PageSize > 0 {
+		result.HasMore = result.Page*q.PageSize < result.Total
+	}
+	return result, nil
+}
+
+// DeleteReadingHistory 删除阅读记录，comicID 为空时清空全部
+func (dm *DownloadManager) DeleteReadingHistory(comicID string) (int64, error) {
+	dm.flushPageViews()
+	var result sql.Result
+	var err error
+	if comicID == "" {
+		result, err = dm.db.Exec("DELETE FROM reading_sessions")
+	} else {
+		result, err = dm.db.Exec("DELETE FROM reading_sessions WHERE comic_id = ?", comicID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// 进行中的会话之后的翻页记为新的阅读记录
+	dm.sessions.mu.Lock()
+	for k, s := range dm.sessions.sessions {
+		if comicID == "" || s.comicID == comicID {
+			delete(dm.sessions.sessions, k)
+		}
+	}
+	dm.sessions.mu.Unlock()
+	return result.RowsAffected()
+}
+
+// GetReadingStats 统计最近 days 天的阅读情况：每日页数和时长、最常读的作者和标签
+func (dm *DownloadManager) GetReadingStats(days, top int) (*ReadingStats, error) {
+	dm.flushPageViews()
+	now := time.Now()
+	y, m, d := now.Date()
+	from := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).AddDate(0, 0, -(days - 1))
+
+	stats := &ReadingStats{
+		From:       from,
+		To:         now,
+		Days:       days,
+		Daily:      make([]DailyReading, 0),
+		TopAuthors: make([]RankedItem, 0),
+		TopTags:    make([]RankedItem, 0),
+	}
+	since := from.Unix()
+
+	if err := dm.db.QueryRow(`
+		SELECT COUNT(*), COUNT(DISTINCT comic_id), COALESCE(SUM(pages_viewed), 0), COALESCE(SUM(end_time - start_time), 0)
+		FROM reading_sessions WHERE start_time >= ?
+	`, since).Scan(&stats.Sessions, &stats.Comics, &stats.Pages, &stats.Seconds); err != nil {
+		return nil, fmt.Errorf("统计阅读记录失败: %w", err)
+	}
+
+	// 每日统计（按服务器本地日期）
+	rows, err := dm.db.Query(`
+		SELECT date(start_time, 'unixepoch', 'localtime') AS day,
+		       COUNT(*), SUM(pages_viewed), SUM(end_time - start_time)
+		FROM reading_sessions WHERE start_time >= ?
+		GROUP BY day ORDER BY day
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("统计每日阅读失败: %w", err)
+	}
+	for rows.Next() {
+		var daily DailyReading
+		if rows.Scan(&daily.Date, &daily.Sessions, &daily.Pages, &daily.Seconds) == nil {
+			stats.Daily = append(stats.Daily, daily)
+		}
+	}
+	rows.Close()
+	stats.ActiveDays = len(stats.Daily)
+	if days > 0 {
+		stats.PagesPerDay = math.Round(float64(stats.Pages)/float64(days)*100) / 100
+	}
+
+	// 每部漫画的阅读页数，用于作者和标签排行
+	readCTE := `, read AS (
+		SELECT comic_id, SUM(pages_viewed) AS pages FROM reading_sessions
+		WHERE start_time >= ? GROUP BY comic_id
+	)`
+
+	stats.TopAuthors, err = dm.rankReading(libraryCTE+readCTE+`
+		SELECT library.author, COUNT(*), SUM(read.pages)
+		FROM read JOIN library ON library.id = read.comic_id
+		WHERE library.author IS NOT NULL AND library.author NOT IN ('', '未知')
+		GROUP BY library.author
+		ORDER BY SUM(read.pages) DESC, COUNT(*) DESC, library.author
+		LIMIT ?`, since, top)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.TopTags, err = dm.rankReading(libraryCTE+tagRowsCTE+readCTE+`
+		SELECT CASE WHEN tag_rows.namespace = '' THEN tag_rows.tag ELSE tag_rows.namespace || ':' || tag_rows.tag END AS name,
+		       COUNT(DISTINCT read.comic_id), SUM(read.pages)
+		FROM read JOIN tag_rows ON tag_rows.comic_id = read.comic_id
+		GROUP BY name
+		ORDER BY SUM(read.pages) DESC, COUNT(DISTINCT read.comic_id) DESC, name
+		LIMIT ?`, since, top)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// rankReading 执行排行查询，结果列为 (名称, 漫画数, 页数)
+func (dm *DownloadManager) rankReading(query string, args ...interface{}) ([]RankedItem, error) {
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("统计阅读排行失败: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]RankedItem, 0)
+	for rows.Next() {
+		var item RankedItem
+		if rows.Scan(&item.Name, &item.Comics, &item.Pages) == nil {
+			items = append(items, item)
+		}
+	}
+	return items, rows.Err()
+}
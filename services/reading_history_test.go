@@ -0,0 +1,65 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlushPageViewsKeepsViewsOnFailure(t *testing.T) {
+	dm := newTestManager(t)
+	dm.sessions = &sessionTracker{sessions: make(map[string]*inferredSession)}
+	count := func() int {
+		var n int
+		if err := dm.db.QueryRow("SELECT COALESCE(SUM(pages_viewed), 0) FROM reading_sessions").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	dm.RecordPageView("s1", "comic", 1, 1)
+	dm.RecordPageView("s1", "comic", 1, 2)
+	// 过期但尚未写入的会话在写入成功前不能被移除
+	dm.RecordPageView("s2", "comic", 2, 1)
+	dm.sessions.sessions["s2|comic|2"].last = time.Now().Add(-2 * sessionIdleTimeout)
+
+	// 写入失败（表被改名）时保留未写入的翻页
+	if _, err := dm.db.Exec("ALTER TABLE reading_sessions RENAME TO reading_sessions_busy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.flushPageViews(); err == nil {
+		t.Fatal("flush succeeded without the reading_sessions table")
+	}
+	if len(dm.sessions.sessions) != 2 {
+		t.Fatalf("sessions after failed flush = %d, want 2", len(dm.sessions.sessions))
+	}
+	for key, s := range dm.sessions.sessions {
+		if !s.dirty {
+			t.Errorf("session %s lost its pending page views", key)
+		}
+	}
+
+	if _, err := dm.db.Exec("ALTER TABLE reading_sessions_busy RENAME TO reading_sessions"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.flushPageViews(); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != 3 {
+		t.Errorf("pages written = %d, want 3", got)
+	}
+	if _, ok := dm.sessions.sessions["s2|comic|2"]; ok {
+		t.Error("expired session was not removed after it was written")
+	}
+	if s := dm.sessions.sessions["s1|comic|1"]; s == nil || s.dirty {
+		t.Error("active session should stay in memory without pending views")
+	}
+
+	// 再次翻页只更新同一条记录
+	dm.RecordPageView("s1", "comic", 1, 3)
+	if err := dm.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != 4 {
+		t.Errorf("pages written after shutdown = %d, want 4", got)
+	}
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"pica-comic-server/models"
+)
+
+// ErrInvalidProgress 阅读进度参数无效
+var ErrInvalidProgress = errors.New("无效的阅读进度")
+
+// ReadingProgress 一部漫画的阅读进度（多设备同步，以最后更新的为准）
+type ReadingProgress struct {
+	ComicID   string    `json:"comic_id"`
+	Ep        int       `json:"ep"`      // 章节号（无章节的漫画为0）
+	Page      int       `json:"page"`    // 页码（从1开始，与获取页面接口一致）
+	Percent   float64   `json:"percent"` // 整部漫画的阅读百分比（0-100）
+	Device    string    `json:"device"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProgressUpdate 上报阅读进度的参数
+type ProgressUpdate struct {
+	Ep        int        `json:"ep"`
+	Page      int        `json:"page"`
+	Percent   *float64   `json:"percent"`    // 不提供时按章节和页码计算
+	Device    string     `json:"device"`     // 设备名称，仅用于展示
+	UpdatedAt *time.Time `json:"updated_at"` // 设备上的阅读时间，不提供时使用服务器时间
+}
+
+// ContinueReadingItem 继续阅读列表中的一项
+type ContinueReadingItem struct {
+	Comic    models.ComicDetail `json:"comic"`
+	Progress ReadingProgress    `json:"progress"`
+}
+
+// computePercent 按章节在已下载章节中的位置和页码估算阅读百分比
+func computePercent(comic *models.ComicDetail, ep, page, pageCount int) float64 {
+	eps := append([]int{}, comic.DownloadedEps...)
+	sort.Ints(eps)
+
+	index, total := 0, len(eps)
+	if ep == 0 || total == 0 {
+		total = 1
+	} else {
+		for i, e := range eps {
+			if e == ep {
+				index = i
+				break
+			}
+		}
+	}
+
+	percent := (float64(index) + float64(page)/float64(pageCount)) / float64(total) * 100
+	return math.Round(percent*100) / 100
+}
+
+// GetReadingProgress 获取漫画的阅读进度，没有记录时返回 nil
+func (dm *DownloadManager) GetReadingProgress(id string) (*ReadingProgress, error) {
+	var p ReadingProgress
+	var updatedAt int64
+	err := dm.db.QueryRow(`
+		SELECT comic_id, ep, page, percent, COALESCE(device, ''), updated_at
+		FROM reading_progress WHERE comic_id = ?
+	`, id).Scan(&p.ComicID, &p.Ep, &p.Page, &p.Percent, &p.Device, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.UpdatedAt = time.UnixMilli(updatedAt)
+	return &p, nil
+}
+
+// SaveReadingProgress 保存阅读进度
+// 多个设备同时上报时以更新时间最新的为准：较旧的进度不会覆盖已有记录，
+// 返回值为保存后服务器上的进度，applied 表示本次上报是否生效
+func (dm *DownloadManager) SaveReadingProgress(id string, update ProgressUpdate) (progress *ReadingProgress, applied bool, err error) {
+	comic, err := dm.GetComic(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pageCount, err := dm.GetEpisodePageCount(id, update.Ep)
+	if err != nil || pageCount == 0 {
+		return nil, false, fmt.Errorf("%w: 章节不存在 %d", ErrInvalidProgress, update.Ep)
+	}
+	if update.Page < 1 || update.Page > pageCount {
+		return nil, false, fmt.Errorf("%w: 页码超出范围 %d（共 %d 页）", ErrInvalidProgress, update.Page, pageCount)
+	}
+
+	var percent float64
+	if update.Percent != nil {
+		percent = *update.Percent
+		if percent < 0 || percent > 100 {
+			return nil, false, fmt.Errorf("%w: 百分比应在 0-100 之间", ErrInvalidProgress)
+		}
+	} else {
+		percent = computePercent(comic, update.Ep, update.Page, pageCount)
+	}
+
+	// 设备时钟可能偏快，未来的时间按当前时间处理，避免该进度永远无法被覆盖
+	now := time.Now()
+	updatedAt := now
+	if update.UpdatedAt != nil && update.UpdatedAt.Before(now) {
+		updatedAt = *update.UpdatedAt
+	}
+
+	result, err := dm.db.Exec(`
+		INSERT INTO reading_progress (comic_id, ep, page, percent, device, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(comic_id) DO UPDATE SET
+			ep = excluded.ep,
+			page = excluded.page,
+			percent = excluded.percent,
+			device = excluded.device,
+			updated_at = excluded.updated_at
+		WHERE excluded.updated_at >= reading_progress.updated_at
+	`, id, update.Ep, update.Page, percent, strings.TrimSpace(update.Device), updatedAt.UnixMilli())
+	if err != nil {
+		return nil, false, fmt.Errorf("保存阅读进度失败: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	applied = n > 0
+
+	if applied {
+		// 同步最近阅读时间，使列表按 last_read 排序时与进度一致
+		if _, err := dm.db.Exec(`
+			INSERT INTO read_activity (comic_id, last_read) VALUES (?, ?)
+			ON CONFLICT(comic_id) DO UPDATE SET last_read = MAX(last_read, excluded.last_read)
+		`, id, updatedAt.Unix()); err != nil {
+			fmt.Printf("[警告] 记录阅读时间失败: %v\n", err)
+		}
+	}
+
+	progress, err = dm.GetReadingProgress(id)
+	if err != nil {
+		return nil, false, err
+	}
+	return progress, applied, nil
+}
+
+// DeleteReadingProgress 清除漫画的阅读进度
+func (dm *DownloadManager) DeleteReadingProgress(id string) error {
+	_, err := dm.db.Exec("DELETE FROM reading_progress WHERE comic_id = ?", id)
+	return err
+}
+
+// ContinueReading 按最近阅读时间列出有阅读进度的漫画
+// includeFinished 为 false 时不包含已读完（100%）的漫画，limit 为 0 时返回全部
+func (dm *DownloadManager) ContinueReading(limit int, includeFinished bool) ([]ContinueReadingItem, error) {
+	query := libraryCTE + " SELECT " + libraryColumns + `,
+		reading_progress.ep, reading_progress.page, reading_progress.percent,
+		COALESCE(reading_progress.device, ''), reading_progress.updated_at
+		FROM reading_progress
+		JOIN library ON library.id = reading_progress.comic_id`
+	if !includeFinished {
+		query += " WHERE reading_progress.percent < 100"
+	}
+	query += " ORDER BY reading_progress.updated_at DESC"
+
+	var args []interface{}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询阅读进度失败: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]ContinueReadingItem, 0)
+	for rows.Next() {
+		var p ReadingProgress
+		var updatedAt int64
+		comic, err := scanComicDetail(extraScanner{row: rows, extra: []interface{}{
+			&p.Ep, &p.Page, &p.Percent, &p.Device, &updatedAt,
+		}})
+		if err != nil {
+			continue
+		}
+		p.ComicID = comic.ID
+		p.UpdatedAt = time.UnixMilli(updatedAt)
+		items = append(items, ContinueReadingItem{Comic: *comic, Progress: p})
+	}
+	return items, rows.Err()
+}
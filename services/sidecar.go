@@ -0,0 +1,367 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pica-comic-server/models"
+)
+
+// sidecarNames 扫描文件夹时读取的元数据文件（按优先级排列，文件名不区分大小写）
+// ComicInfo.xml 为 ComicRack 格式，info.json 来自 gallery-dl、E-Hentai 下载脚本等，details.json 来自 Tachiyomi/Mihon
+var sidecarNames = []string{"comicinfo.xml", "info.json", "details.json"}
+
+// isSidecarFile 判断文件名是否为元数据文件
+func isSidecarFile(name string) bool {
+	lower := strings.ToLower(name)
+	for _, s := range sidecarNames {
+		if lower == s {
+			return true
+		}
+	}
+	return false
+}
+
+// comicMetadata 从元数据文件中读取的漫画信息，空字段表示文件中没有提供
+type comicMetadata struct {
+	Title         string
+	Author        string
+	Description   string
+	DetailURL     string
+	TagGroups     models.TagGroups
+	EpisodeTitles []string // 按章节顺序排列的章节名
+
+	episodeTitle string // ComicInfo.xml 同时有 Series 和 Title 时，Title 是章节名
+}
+
+// apply 用元数据覆盖扫描生成的占位信息
+func (m *comicMetadata) apply(comic *models.ComicDetail) {
+	if m.Title != "" {
+		comic.Title = m.Title
+	}
+	if m.Author != "" {
+		comic.Author = m.Author
+	}
+	if m.Description != "" {
+		comic.Description = m.Description
+	}
+	if m.DetailURL != "" {
+		comic.DetailURL = m.DetailURL
+	}
+	if len(m.TagGroups) > 0 {
+		comic.TagGroups = m.TagGroups
+		comic.Tags = flattenTagGroups(m.TagGroups)
+		comic.Categories = append([]string{}, m.TagGroups["categories"]...)
+	}
+	for i, title := range m.EpisodeTitles {
+		if i < len(comic.Eps) && title != "" {
+			comic.Eps[i] = title
+		}
+	}
+}
+
+// addTag 按 "namespace:tag" 形式加入标签，没有命名空间的归入 defaultNS
+func (m *comicMetadata) addTag(defaultNS, raw string) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return
+	}
+	ns, tag := defaultNS, raw
+	if n, t, ok := splitNamespacedTag(raw); ok {
+		ns, tag = n, t
+	}
+	if m.TagGroups == nil {
+		m.TagGroups = make(models.TagGroups)
+	}
+	for _, existing := range m.TagGroups[ns] {
+		if existing == tag {
+			return
+		}
+	}
+	m.TagGroups[ns] = append(m.TagGroups[ns], tag)
+}
+
+// comicInfoFile ComicInfo.xml 中用到的字段
+type comicInfoFile struct {
+	Title     string `xml:"Title"`
+	Series    string `xml:"Series"`
+	Summary   string `xml:"Summary"`
+	Writer    string `xml:"Writer"`
+	Penciller string `xml:"Penciller"`
+	Genre     string `xml:"Genre"`
+	Tags      string `xml:"Tags"`
+	Web       string `xml:"Web"`
+	Pages     []struct {
+		Bookmark string `xml:"Bookmark,attr"`
+	} `xml:"Pages>Page"`
+}
+
+// parseComicInfoXML 解析 ComicInfo.xml：Series 作为漫画标题（没有时使用 Title），页面书签作为章节名
+func parseComicInfoXML(data []byte) (*comicMetadata, error) {
+	var info comicInfoFile
+	if err := xml.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("解析 ComicInfo.xml 失败: %w", err)
+	}
+
+	m := &comicMetadata{
+		Title:       strings.TrimSpace(info.Series),
+		Author:      strings.TrimSpace(info.Writer),
+		Description: strings.TrimSpace(info.Summary),
+	}
+	if m.Title == "" {
+		m.Title = strings.TrimSpace(info.Title)
+	} else {
+		m.episodeTitle = strings.TrimSpace(info.Title)
+	}
+	if m.Author == "" {
+		m.Author = strings.TrimSpace(info.Penciller)
+	}
+	// Web 可以包含多个以空格分隔的链接，取第一个
+	if fields := strings.Fields(info.Web); len(fields) > 0 {
+		m.DetailURL = fields[0]
+	}
+	for _, tag := range strings.Split(info.Tags, ",") {
+		m.addTag("", tag)
+	}
+	for _, genre := range strings.Split(info.Genre, ",") {
+		m.addTag("categories", genre)
+	}
+	for _, page := range info.Pages {
+		if title := strings.TrimSpace(page.Bookmark); title != "" {
+			m.EpisodeTitles = append(m.EpisodeTitles, title)
+		}
+	}
+	return m, nil
+}
+
+// jsonString 读取字符串字段，数组取第一个非空元素
+func jsonString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return strings.TrimSpace(val)
+	case float64:
+		return fmt.Sprintf("%.0f", val)
+	case []interface{}:
+		for _, item := range val {
+			if s := jsonString(item); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// jsonStrings 读取字符串或字符串数组字段
+func jsonStrings(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		return cleanStrings(strings.Split(val, ","))
+	case []interface{}:
+		list := make([]string, 0, len(val))
+		for _, item := range val {
+			if s := jsonString(item); s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// firstJSONString 按顺序取第一个非空的字符串字段
+func firstJSONString(obj map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if s := jsonString(obj[key]); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// parseInfoJSON 解析 info.json / details.json，兼容常见下载器的字段写法：
+// 标签可以是 "namespace:tag" 数组或 {namespace: [tags]} 对象；
+// E-Hentai 画廊（gid、token）会生成画廊链接，没有作者时使用 artist 标签
+func parseInfoJSON(data []byte) (*comicMetadata, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("解析 info.json 失败: %w", err)
+	}
+	// E-Hentai 下载脚本将信息放在 gallery_info 中
+	if inner, ok := obj["gallery_info"].(map[string]interface{}); ok {
+		obj = inner
+	}
+
+	m := &comicMetadata{
+		Title:       firstJSONString(obj, "title", "name", "title_original", "title_jpn"),
+		Author:      firstJSONString(obj, "author", "authors", "artist", "artists", "writer"),
+		Description: firstJSONString(obj, "description", "summary"),
+		DetailURL:   firstJSONString(obj, "url", "detail_url", "gallery_url", "web", "source"),
+	}
+
+	switch tags := obj["tags"].(type) {
+	case map[string]interface{}:
+		for ns, list := range tags {
+			for _, tag := range jsonStrings(list) {
+				m.addTag(normalizeTagNamespace(ns), tag)
+			}
+		}
+	default:
+		for _, tag := range jsonStrings(tags) {
+			m.addTag("", tag)
+		}
+	}
+
+	// E-Hentai 画廊：下载脚本在 source 中记录站点和画廊；gallery-dl 直接记录 gid、token，
+	// 其 category 是站点名，分类在 eh_category 中
+	categoryKeys := []string{"categories", "genre", "genres", "category"}
+	if source, ok := obj["source"].(map[string]interface{}); ok {
+		site, gid, token := jsonString(source["site"]), jsonString(source["gid"]), jsonString(source["token"])
+		if m.DetailURL == "" && site != "" && gid != "" && token != "" {
+			m.DetailURL = fmt.Sprintf("https://%s/g/%s/%s/", site, gid, token)
+		}
+	} else if gid, token := jsonString(obj["gid"]), jsonString(obj["token"]); gid != "" && token != "" {
+		if m.DetailURL == "" {
+			host := "e-hentai.org"
+			if jsonString(obj["category"]) == "exhentai" {
+				host = "exhentai.org"
+			}
+			m.DetailURL = fmt.Sprintf("https://%s/g/%s/%s/", host, gid, token)
+		}
+		categoryKeys = []string{"eh_category"}
+	}
+	for _, key := range categoryKeys {
+		for _, category := range jsonStrings(obj[key]) {
+			m.addTag("categories", category)
+		}
+	}
+
+	if m.Author == "" && m.TagGroups != nil {
+		if artists := m.TagGroups["artist"]; len(artists) > 0 {
+			m.Author = artists[0]
+		}
+	}
+
+	for _, key := range []string{"eps", "episodes", "chapters"} {
+		list, ok := obj[key].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, item := range list {
+			title := jsonString(item)
+			if ep, ok := item.(map[string]interface{}); ok {
+				title = firstJSONString(ep, "title", "name")
+			}
+			m.EpisodeTitles = append(m.EpisodeTitles, title)
+		}
+		break
+	}
+	return m, nil
+}
+
+// parseSidecar 按文件名选择解析方式
+func parseSidecar(name string, data []byte) (*comicMetadata, error) {
+	if strings.HasSuffix(strings.ToLower(name), ".xml") {
+		return parseComicInfoXML(data)
+	}
+	return parseInfoJSON(data)
+}
+
+// readDirSidecar 读取目录中优先级最高的元数据文件
+func readDirSidecar(dir string) (*comicMetadata, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, want := range sidecarNames {
+		for _, entry := range entries {
+			if entry.IsDir() || strings.ToLower(entry.Name()) != want {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			return parseSidecar(entry.Name(), data)
+		}
+	}
+	return nil, nil
+}
+
+// readArchiveSidecar 读取压缩包根部的 ComicInfo.xml
+func readArchiveSidecar(archivePath string) (*comicMetadata, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if strings.ToLower(f.Name) != "comicinfo.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		return parseComicInfoXML(data)
+	}
+	return nil, nil
+}
+
+// readSidecar 读取漫画目录（或压缩包）中的元数据，没有元数据文件时返回 nil
+func readSidecar(path string, archive bool) (*comicMetadata, error) {
+	if archive {
+		return readArchiveSidecar(path)
+	}
+	return readDirSidecar(path)
+}
+
+// readComicMetadata 读取漫画的元数据：漫画级的元数据文件，以及各章节目录或压缩包中 ComicInfo.xml 的章节名
+// 漫画本身没有元数据文件时，使用第一个带有 Series 的章节元数据（如按章节打包的 CBZ）
+func readComicMetadata(comicPath string, sources []episodeSource) *comicMetadata {
+	meta, err := readSidecar(comicPath, isArchiveFile(comicPath))
+	if err != nil {
+		log.Printf("[库索引] 读取元数据失败: %s, 错误: %v", comicPath, err)
+	}
+
+	for i, src := range sources {
+		if src.root {
+			// 根目录中的页面与漫画共用元数据文件
+			continue
+		}
+		epMeta, err := readSidecar(src.path, src.archive)
+		if err != nil || epMeta == nil {
+			continue
+		}
+		title := epMeta.episodeTitle
+		if title == "" {
+			title = epMeta.Title
+		}
+		if meta == nil {
+			meta = &comicMetadata{}
+			if epMeta.episodeTitle != "" {
+				*meta = *epMeta
+				meta.EpisodeTitles = nil
+			}
+		}
+		if title == "" {
+			continue
+		}
+		for len(meta.EpisodeTitles) <= i {
+			meta.EpisodeTitles = append(meta.EpisodeTitles, "")
+		}
+		meta.EpisodeTitles[i] = title
+	}
+	return meta
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"pica-comic-server/models"
+)
+
+func TestParseInfoJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want comicMetadata
+	}{
+		{
+			name: "gallery-dl exhentai gallery",
+			data: `{"gid": 2345678, "token": "a1b2c3d4e5", "category": "exhentai", "eh_category": "Doujinshi",
+				"title": "[Circle] Title", "title_jpn": "タイトル",
+				"tags": ["artist:foo", "female:glasses", "language:chinese", "female:glasses"]}`,
+			want: comicMetadata{
+				Title:     "[Circle] Title",
+				Author:    "foo", // 没有作者字段时使用 artist 标签
+				DetailURL: "https://exhentai.org/g/2345678/a1b2c3d4e5/",
+				TagGroups: models.TagGroups{
+					"artist":     {"foo"},
+					"female":     {"glasses"},
+					"language":   {"chinese"},
+					"categories": {"Doujinshi"}, // category 是站点名，不作为分类
+				},
+			},
+		},
+		{
+			name: "gallery-dl e-hentai gallery falls back to title_jpn",
+			data: `{"gid": 1, "token": "t", "category": "e-hentai", "title": "", "title_jpn": "タイトル"}`,
+			want: comicMetadata{
+				Title:     "タイトル",
+				DetailURL: "https://e-hentai.org/g/1/t/",
+			},
+		},
+		{
+			name: "E-Hentai script wraps gallery_info with a source object",
+			data: `{"gallery_info": {"title": "Title", "category": "Manga",
+				"tags": {"Artist": ["bar"], "tag": ["full color"], "category": ["Non-H"]},
+				"source": {"site": "e-hentai.org", "gid": 42, "token": "abc"}}}`,
+			want: comicMetadata{
+				Title:     "Title",
+				Author:    "bar",
+				DetailURL: "https://e-hentai.org/g/42/abc/",
+				TagGroups: models.TagGroups{
+					"artist":     {"bar"},
+					"":           {"full color"},
+					"categories": {"Non-H", "Manga"},
+				},
+			},
+		},
+		{
+			name: "explicit url wins over gallery link",
+			data: `{"title": "T", "url": "https://example.com/c/1", "gid": 1, "token": "t"}`,
+			want: comicMetadata{Title: "T", DetailURL: "https://example.com/c/1"},
+		},
+		{
+			name: "Tachiyomi details.json",
+			data: `{"title": "Manga", "author": "A", "artist": "B", "description": " 简介 ",
+				"genre": ["Action", "Comedy"], "status": "0"}`,
+			want: comicMetadata{
+				Title:       "Manga",
+				Author:      "A",
+				Description: "简介",
+				TagGroups:   models.TagGroups{"categories": {"Action", "Comedy"}},
+			},
+		},
+		{
+			name: "comma separated tags and author array",
+			data: `{"name": "N", "authors": ["", "X", "Y"], "summary": "S", "tags": "a, b,,c", "genres": "g1,g2"}`,
+			want: comicMetadata{
+				Title:       "N",
+				Author:      "X",
+				Description: "S",
+				TagGroups: models.TagGroups{
+					"":           {"a", "b", "c"},
+					"categories": {"g1", "g2"},
+				},
+			},
+		},
+		{
+			name: "episode titles as strings and objects",
+			data: `{"title": "T", "eps": ["第1话", {"title": "第2话"}, {"name": "番外"}, {}]}`,
+			want: comicMetadata{Title: "T", EpisodeTitles: []string{"第1话", "第2话", "番外", ""}},
+		},
+		{
+			name: "chapters used when eps missing",
+			data: `{"title": "T", "chapters": [{"title": "Ch.1"}, "Ch.2"]}`,
+			want: comicMetadata{Title: "T", EpisodeTitles: []string{"Ch.1", "Ch.2"}},
+		},
+		{
+			name: "empty object",
+			data: `{}`,
+			want: comicMetadata{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInfoJSON([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("parseInfoJSON =\n%+v\nwant\n%+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInfoJSONInvalid(t *testing.T) {
+	for _, data := range []string{``, `{"title":`, `["not", "an", "object"]`, `"title"`} {
+		if _, err := parseInfoJSON([]byte(data)); err == nil {
+			t.Errorf("parseInfoJSON(%q) returned no error", data)
+		}
+	}
+}
+
+func TestParseComicInfoXML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want comicMetadata
+	}{
+		{
+			name: "series with chapter title and bookmarks",
+			data: `<?xml version="1.0" encoding="utf-8"?>
+<ComicInfo>
+  <Title>第1话</Title>
+  <Series>漫画名</Series>
+  <Summary> 简介 </Summary>
+  <Penciller>画师</Penciller>
+  <Genre>Action, Comedy</Genre>
+  <Tags>female:glasses, full color</Tags>
+  <Web>https://example.com/a https://example.com/b</Web>
+  <Pages>
+    <Page Image="0" Bookmark="第1话" />
+    <Page Image="1" />
+    <Page Image="2" Bookmark="第2话" />
+  </Pages>
+</ComicInfo>`,
+			want: comicMetadata{
+				Title:       "漫画名",
+				Author:      "画师", // 没有 Writer 时使用 Penciller
+				Description: "简介",
+				DetailURL:   "https://example.com/a",
+				TagGroups: models.TagGroups{
+					"female":     {"glasses"},
+					"":           {"full color"},
+					"categories": {"Action", "Comedy"},
+				},
+				EpisodeTitles: []string{"第1话", "第2话"},
+				episodeTitle:  "第1话",
+			},
+		},
+		{
+			name: "title without series",
+			data: `<ComicInfo><Title>单行本</Title><Writer>作者</Writer><Penciller>画师</Penciller></ComicInfo>`,
+			want: comicMetadata{Title: "单行本", Author: "作者"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseComicInfoXML([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("parseComicInfoXML =\n%+v\nwant\n%+v", *got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseComicInfoXML([]byte(`<ComicInfo><Title>`)); err == nil {
+		t.Error("parseComicInfoXML accepted truncated XML")
+	}
+}
+
+func TestIsSidecarFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"ComicInfo.xml", true},
+		{"comicinfo.XML", true},
+		{"info.json", true},
+		{"details.json", true},
+		{"metadata.json", false},
+		{"001.jpg", false},
+		{"ComicInfo.xml.bak", false},
+	}
+	for _, tt := range tests {
+		if got := isSidecarFile(tt.name); got != tt.want {
+			t.Errorf("isSidecarFile(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrObjectNotFound 存储中没有该文件
+	ErrObjectNotFound = errors.New("文件不存在")
+	// ErrStorageNoURL 存储后端不支持生成直接访问的地址
+	ErrStorageNoURL = errors.New("存储后端不支持直接访问地址")
+	// ErrRenameIncomplete 新位置的文件已完整，但原位置仍残留部分文件
+	ErrRenameIncomplete = errors.New("移动未完成，部分原文件未能删除")
+)
+
+// StorageObject 存储中的一个文件
+type StorageObject struct {
+	Key     string    // 相对漫画库根部的路径，使用 / 分隔，如 "漫画名/1/001.jpg"
+	Size    int64     // 文件大小（字节）
+	ModTime time.Time // 修改时间
+}
+
+// Storage 漫画文件的存储后端
+// 键为相对漫画库根部的路径（使用 / 分隔），目录用键前缀表示：List、DeleteAll、Rename 作用于前缀下的全部文件
+type Storage interface {
+	// Name 存储后端的名称，用于日志和缓存键
+	Name() string
+	// Put 写入文件，size 为 -1 表示大小未知
+	Put(key string, r io.Reader, size int64) error
+	// Import 将本地文件或目录移入存储的指定位置，完成后本地文件不再存在
+	Import(localPath, key string) error
+	// Open 读取文件，返回数据和大小
+	Open(key string) (io.ReadCloser, int64, error)
+	// Stat 获取文件信息，不存在时返回 ErrObjectNotFound
+	Stat(key string) (StorageObject, error)
+	// List 递归列出前缀下的全部文件
+	List(prefix string) ([]StorageObject, error)
+	// Delete 删除单个文件，文件不存在时不报错
+	Delete(key string) error
+	// DeleteAll 删除前缀下的全部文件
+	DeleteAll(prefix string) error
+	// Rename 将前缀下的全部文件移动到新前缀下；失败时原位置保持完整，
+	// 新位置已完整但原文件未删净时返回包装 ErrRenameIncomplete 的错误
+	Rename(oldPrefix, newPrefix string) error
+	// URL 返回可以直接访问文件的限时地址，不支持时返回 ErrStorageNoURL
+	URL(key string, expiry time.Duration) (string, error)
+}
+
+// cleanStorageKey 规范化存储键，拒绝 .. 和绝对路径
+func cleanStorageKey(key string) (string, error) {
+	key = strings.ReplaceAll(key, "\\", "/")
+	cleaned := path.Clean("/" + key)[1:]
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("无效的存储路径: %s", key)
+		}
+	}
+	return cleaned, nil
+}
+
+// joinStorageKey 拼接存储键
+func joinStorageKey(parts ...string) string {
+	return strings.TrimPrefix(path.Join(parts...), "/")
+}
+
+// LocalStorage 本地磁盘存储（默认），文件直接保存在下载目录中
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage 创建以 root 为根目录的本地存储
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root: root}
+}
+
+// Name 存储后端名称
+func (s *LocalStorage) Name() string {
+	return "local"
+}
+
+// path 存储键对应的本地路径
+func (s *LocalStorage) path(key string) (string, error) {
+	key, err := cleanStorageKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put 写入文件：先写入临时文件再重命名，避免读到写了一半的文件
+func (s *LocalStorage) Put(key string, r io.Reader, size int64) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// 每次写入使用独立的临时文件，同一个键的并发写入互不干扰
+	out, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+	out.Chmod(0644)
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// Import 将本地文件或目录移动到存储位置（已在该位置时不做任何操作）
+func (s *LocalStorage) Import(localPath, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if filepath.Clean(localPath) == p {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.Rename(localPath, p)
+}
+
+// Open 打开文件
+func (s *LocalStorage) Open(key string) (io.ReadCloser, int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, 0, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// Stat 获取文件信息
+func (s *LocalStorage) Stat(key string) (StorageObject, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return StorageObject{}, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return StorageObject{}, ErrObjectNotFound
+	}
+	if err != nil {
+		return StorageObject{}, err
+	}
+	return StorageObject{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// List 递归列出目录下的全部文件（跳过隐藏文件）
+func (s *LocalStorage) List(prefix string) ([]StorageObject, error) {
+	root, err := s.path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var objects []StorageObject
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, StorageObject{Key: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+// Delete 删除文件
+func (s *LocalStorage) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// DeleteAll 删除目录
+func (s *LocalStorage) DeleteAll(prefix string) error {
+	p, err := s.path(prefix)
+	if err != nil {
+		return err
+	}
+	if p == filepath.Clean(s.root) {
+		return fmt.Errorf("不能删除存储根目录")
+	}
+	return os.RemoveAll(p)
+}
+
+// Rename 重命名目录
+func (s *LocalStorage) Rename(oldPrefix, newPrefix string) error {
+	oldPath, err := s.path(oldPrefix)
+	if err != nil {
+		return err
+	}
+	newPath, err := s.path(newPrefix)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// URL 本地存储的文件由服务器直接返回
+func (s *LocalStorage) URL(key string, expiry time.Duration) (string, error) {
+	return "", ErrStorageNoURL
+}
+
+// SetStorage 设置漫画文件的存储后端（默认为下载目录所在的本地磁盘）
+// 使用远程存储时，下载目录只保存数据库、缓存和下载、导入过程中的临时文件
+func (dm *DownloadManager) SetStorage(storage Storage) {
+	dm.storage = storage
+}
+
+// SetStorageRedirect 设置远程存储的页面请求是否重定向到存储的直接访问地址（否则由服务器转发）
+func (dm *DownloadManager) SetStorageRedirect(redirect bool, expiry time.Duration) {
+	dm.storageRedirect = redirect
+	dm.storageURLExpiry = expiry
+}
+
+// remoteStorage 漫画文件是否保存在远程存储中
+func (dm *DownloadManager) remoteStorage() bool {
+	_, local := dm.storage.(*LocalStorage)
+	return !local
+}
+
+// comicSources 列出漫画目录中的章节：下载目录中存在时从本地读取，否则从远程存储读取
+// 非数字命名的章节使用记录的章节号，出现新章节时记录分配的编号
+func (dm *DownloadManager) comicSources(directory string) []episodeSource {
+	sources, changed := dm.listComicSources(directory, dm.episodeNumbers(directory))
+	if !changed {
+		return sources
+	}
+
+	// 有新章节时加锁重新分配，避免并发请求记录不同的编号
+	dm.episodeNumbersMu.Lock()
+	defer dm.episodeNumbersMu.Unlock()
+	numbers := dm.episodeNumbers(directory)
+	sources, changed = dm.listComicSources(directory, numbers)
+	if changed {
+		if err := dm.saveEpisodeNumbers(directory, numbers); err != nil {
+			log.Printf("[章节] 记录章节号失败 (%s): %v", directory, err)
+		}
+	}
+	return sources
+}
+
+// listComicSources 按给定的章节号列出漫画目录中的章节
+func (dm *DownloadManager) listComicSources(directory string, numbers map[string]int) ([]episodeSource, bool) {
+	comicPath := filepath.Join(dm.downloadPath, directory)
+	if _, err := os.Stat(comicPath); err == nil || !dm.remoteStorage() {
+		return listEpisodeSources(comicPath, numbers)
+	}
+	return listStorageEpisodeSources(dm.storage, directory, numbers)
+}
+
+// isStoredRemotely 漫画是否只存在于远程存储中
+func (dm *DownloadManager) isStoredRemotely(directory string) bool {
+	if !dm.remoteStorage() {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dm.downloadPath, directory))
+	return os.IsNotExist(err)
+}
+
+// comicExists 下载目录或存储中是否已有该目录，无法列出存储中的文件时返回错误
+func (dm *DownloadManager) comicExists(directory string) (bool, error) {
+	if _, err := os.Stat(filepath.Join(dm.downloadPath, directory)); !os.IsNotExist(err) {
+		return true, nil
+	}
+	if !dm.remoteStorage() {
+		return false, nil
+	}
+	objects, err := dm.storage.List(directory)
+	if err != nil {
+		return false, fmt.Errorf("列出存储中的文件失败: %w", err)
+	}
+	return len(objects) > 0, nil
+}
+
+// comicSize 计算漫画的大小（字节）
+func (dm *DownloadManager) comicSize(directory string) int64 {
+	if !dm.isStoredRemotely(directory) {
+		return calculateFolderSize(filepath.Join(dm.downloadPath, directory))
+	}
+	objects, err := dm.storage.List(directory)
+	if err != nil {
+		fmt.Printf("[警告] 计算漫画大小失败: %v\n", err)
+		return 0
+	}
+	var size int64
+	for _, obj := range objects {
+		size += obj.Size
+	}
+	return size
+}
+
+// storeComic 下载、导入完成后将下载目录中的漫画移入存储（本地存储不需要移动，开启去重时把页面存入内容寻址存储）
+func (dm *DownloadManager) storeComic(directory string) error {
+	if !dm.remoteStorage() {
+		dm.dedupComic(filepath.Join(dm.downloadPath, directory))
+		return nil
+	}
+	if err := dm.storage.Import(filepath.Join(dm.downloadPath, directory), directory); err != nil {
+		return fmt.Errorf("上传到%s存储失败: %w", dm.storage.Name(), err)
+	}
+	pageIndex.forget(dm.storage, directory)
+	return nil
+}
+
+// moveIntoComic 将下载目录中的文件或目录移入已有漫画，name 为在漫画目录中的名称
+func (dm *DownloadManager) moveIntoComic(localPath, directory, name string) error {
+	if dm.isStoredRemotely(directory) {
+		err := dm.storage.Import(localPath, joinStorageKey(directory, name))
+		pageIndex.forget(dm.storage, directory)
+		return err
+	}
+	dst := filepath.Join(dm.downloadPath, directory, name)
+	if err := os.Rename(localPath, dst); err != nil {
+		return err
+	}
+	dm.dedupComic(dst)
+	return nil
+}
+
+// renameComicDir 重命名漫画目录（远程存储中的漫画逐个移动文件）
+func (dm *DownloadManager) renameComicDir(oldDirectory, newDirectory string) error {
+	var err error
+	if dm.isStoredRemotely(oldDirectory) {
+		err = dm.renameStorage(oldDirectory, newDirectory)
+		pageIndex.forget(dm.storage, oldDirectory)
+	} else {
+		err = os.Rename(filepath.Join(dm.downloadPath, oldDirectory), filepath.Join(dm.downloadPath, newDirectory))
+	}
+	if err != nil {
+		return err
+	}
+	return dm.moveEpisodeNumbers(oldDirectory, newDirectory)
+}
+
+// renameStorage 移动存储中的前缀；新位置已完整但原位置残留文件时再清理一次，仍失败只记录日志
+func (dm *DownloadManager) renameStorage(oldPrefix, newPrefix string) error {
+	err := dm.storage.Rename(oldPrefix, newPrefix)
+	if !errors.Is(err, ErrRenameIncomplete) {
+		return err
+	}
+	if err := dm.storage.DeleteAll(oldPrefix); err != nil {
+		log.Printf("[存储] 已移动到 %s，但原位置 %s 仍残留文件: %v", newPrefix, oldPrefix, err)
+	}
+	return nil
+}
+
+// PageURL 远程存储中的页面在开启重定向时，返回可以直接访问的限时地址
+func (dm *DownloadManager) PageURL(page PageRef) (string, bool) {
+	if !page.InStorage() || !dm.storageRedirect {
+		return "", false
+	}
+	u, err := page.store.URL(page.Path, dm.storageURLExpiry)
+	if err != nil {
+		return "", false
+	}
+	return u, true
+}
@@ -0,0 +1,532 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeS3Bucket    = "comics"
+	fakeS3AccessKey = "test-access"
+	fakeS3SecretKey = "test-secret"
+	fakeS3Region    = "us-east-1"
+	fakeS3PageSize  = 2 // 每页返回的对象数，便于测试翻页
+)
+
+// fakeS3 内存中的 S3 兼容服务（路径风格），校验每个请求的 SigV4 签名
+type fakeS3 struct {
+	mu         sync.Mutex
+	objects    map[string][]byte
+	listCalls  int
+	failCopy   string // 复制到该键时返回错误
+	failDelete string // 删除该键时返回错误
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	t.Helper()
+	f := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// awsEscape 按 SigV4 规则编码（与被测实现独立）
+func awsEscape(s string, keepSlash bool) string {
+	escaped := strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	if keepSlash {
+		escaped = strings.ReplaceAll(escaped, "%2F", "/")
+	}
+	return escaped
+}
+
+func fakeS3Sign(date, amzDate, canonicalRequest string) string {
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	scope := date + "/" + fakeS3Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := mac([]byte("AWS4"+fakeS3SecretKey), date)
+	key = mac(key, fakeS3Region)
+	key = mac(key, "s3")
+	key = mac(key, "aws4_request")
+	return hex.EncodeToString(mac(key, stringToSign))
+}
+
+// verify 校验请求头签名或预签名地址，返回错误说明
+func (f *fakeS3) verify(r *http.Request) error {
+	escapedPath, _, _ := strings.Cut(r.RequestURI, "?")
+	query := r.URL.Query()
+
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	if sig := query.Get("X-Amz-Signature"); sig != "" {
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		amzDate = query.Get("X-Amz-Date")
+		signature = sig
+		payloadHash = "UNSIGNED-PAYLOAD"
+		query.Del("X-Amz-Signature")
+		issued, err := time.Parse("20060102T150405Z", amzDate)
+		if err != nil {
+			return err
+		}
+		expires, _ := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if time.Since(issued) > time.Duration(expires)*time.Second {
+			return errors.New("presigned url expired")
+		}
+	} else {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+		for _, part := range strings.Split(auth, ", ") {
+			k, v, _ := strings.Cut(part, "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "SignedHeaders":
+				signedHeaders = v
+			case "Signature":
+				signature = v
+			}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return errors.New("missing x-amz-content-sha256")
+		}
+	}
+	if len(amzDate) < 8 {
+		return errors.New("missing date")
+	}
+	date := amzDate[:8]
+	if credential != fakeS3AccessKey+"/"+date+"/"+fakeS3Region+"/s3/aws4_request" {
+		return fmt.Errorf("bad credential %q", credential)
+	}
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+
+	var headers strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{r.Method, escapedPath, strings.Join(params, "&"), headers.String(), signedHeaders, payloadHash}, "\n")
+	if want := fakeS3Sign(date, amzDate, canonicalRequest); signature != want {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.verify(r); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>", err)
+		return
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/"+fakeS3Bucket)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(rest, "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r.URL.Query())
+	case r.Method == http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			if key == f.failCopy {
+				// 真实 S3 复制失败时也可能返回 200，错误在响应体中
+				io.WriteString(w, "<Error><Code>InternalError</Code><Message>copy failed</Message></Error>")
+				return
+			}
+			src, _ := url.PathUnescape(strings.TrimPrefix(source, "/"+fakeS3Bucket+"/"))
+			data, ok := f.objects[src]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			f.objects[key] = append([]byte(nil), data...)
+			io.WriteString(w, "<CopyObjectResult></CopyObjectResult>")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		if key == f.failDelete {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "<Error><Code>InternalError</Code><Message>delete failed</Message></Error>")
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// list ListObjectsV2，续传标记为下一个对象的位置
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	f.listCalls++
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, query.Get("prefix")) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	end := start + fakeS3PageSize
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}{IsTruncated: end < len(keys)}
+	if result.IsTruncated {
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+	for _, k := range keys[start:end] {
+		result.Contents = append(result.Contents, content{Key: k, Size: len(f.objects[k]), LastModified: time.Now().UTC().Format(time.RFC3339)})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newTestS3Storage(t *testing.T, endpoint, prefix string) *S3Storage {
+	t.Helper()
+	s, err := NewS3Storage(S3Config{
+		Endpoint:  endpoint,
+		Region:    fakeS3Region,
+		Bucket:    fakeS3Bucket,
+		Prefix:    prefix,
+		AccessKey: fakeS3AccessKey,
+		SecretKey: fakeS3SecretKey,
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Storage: %v", err)
+	}
+	return s
+}
+
+func readStorageObject(t *testing.T, s Storage, key string) string {
+	t.Helper()
+	rc, _, err := s.Open(key)
+	if err != nil {
+		t.Fatalf("Open(%q): %v", key, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read %q: %v", key, err)
+	}
+	return string(data)
+}
+
+func TestS3StorageRejectsWrongSecret(t *testing.T) {
+	_, srv := newFakeS3(t)
+	_, err := NewS3Storage(S3Config{
+		Endpoint:  srv.URL,
+		Bucket:    fakeS3Bucket,
+		AccessKey: fakeS3AccessKey,
+		SecretKey: "wrong-secret",
+		PathStyle: true,
+	})
+	if err == nil {
+		t.Fatal("expected signature error with wrong secret")
+	}
+}
+
+func TestS3StoragePutOpenStat(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	s := newTestS3Storage(t, srv.URL, "lib")
+
+	tests := []struct {
+		key  string
+		data string
+		size int64
+	}{
+		{"漫画/1/001.jpg", "page one", 8},
+		{"漫画/1/002 copy.jpg", "unknown size", -1},
+		{"漫画/1/empty.jpg", "", 0},
+	}
+	for _, tt := range tests {
+		if err := s.Put(tt.key, strings.NewReader(tt.data), tt.size); err != nil {
+			t.Fatalf("Put(%q): %v", tt.key, err)
+		}
+		if got := readStorageObject(t, s, tt.key); got != tt.data {
+			t.Errorf("Open(%q) = %q, want %q", tt.key, got, tt.data)
+		}
+		obj, err := s.Stat(tt.key)
+		if err != nil {
+			t.Fatalf("Stat(%q): %v", tt.key, err)
+		}
+		if obj.Key != tt.key || obj.Size != int64(len(tt.data)) {
+			t.Errorf("Stat(%q) = %+v", tt.key, obj)
+		}
+	}
+	if keys := fake.keys(); len(keys) != 3 || !strings.HasPrefix(keys[0], "lib/") {
+		t.Errorf("objects not stored under prefix: %v", keys)
+	}
+
+	if _, _, err := s.Open("漫画/1/missing.jpg"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Open(missing) error = %v, want ErrObjectNotFound", err)
+	}
+	if _, err := s.Stat("漫画/1/missing.jpg"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Stat(missing) error = %v, want ErrObjectNotFound", err)
+	}
+	if err := s.Put("../escape.jpg", strings.NewReader("x"), 1); err == nil {
+		t.Error("Put accepted a key containing ..")
+	}
+}
+
+func TestS3StorageListContinuation(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	s := newTestS3Storage(t, srv.URL, "lib")
+
+	var want []string
+	for i := 1; i <= 5; i++ {
+		key := fmt.Sprintf("comic/1/%03d.jpg", i)
+		want = append(want, key)
+		if err := s.Put(key, strings.NewReader("x"), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put("comic-other/1/001.jpg", strings.NewReader("x"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.listCalls = 0
+	objects, err := s.List("comic")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var got []string
+	for _, obj := range objects {
+		got = append(got, obj.Key)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("List(comic) = %v, want %v", got, want)
+	}
+	if fake.listCalls != 3 {
+		t.Errorf("List made %d requests, want 3 pages", fake.listCalls)
+	}
+
+	all, err := s.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 6 {
+		t.Errorf("List(\"\") returned %d objects, want 6", len(all))
+	}
+}
+
+func TestS3StorageRenameAndDeleteAll(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	s := newTestS3Storage(t, srv.URL, "")
+
+	for _, key := range []string{"old/1/001.jpg", "old/1/002.jpg", "old/cover.jpg", "keep/1/001.jpg"} {
+		if err := s.Put(key, strings.NewReader(key), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Rename("old", "新 名字"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	want := []string{"keep/1/001.jpg", "新 名字/1/001.jpg", "新 名字/1/002.jpg", "新 名字/cover.jpg"}
+	if got := fake.keys(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("after Rename keys = %v, want %v", got, want)
+	}
+	if got := readStorageObject(t, s, "新 名字/1/002.jpg"); got != "old/1/002.jpg" {
+		t.Errorf("renamed content = %q", got)
+	}
+	if err := s.Rename("old", "other"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Rename(missing) error = %v, want ErrObjectNotFound", err)
+	}
+
+	if err := s.DeleteAll("新 名字"); err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	if got := fake.keys(); len(got) != 1 || got[0] != "keep/1/001.jpg" {
+		t.Errorf("after DeleteAll keys = %v", got)
+	}
+	if err := s.DeleteAll("/"); err == nil {
+		t.Error("DeleteAll accepted the storage root")
+	}
+	if err := s.Delete("keep/1/missing.jpg"); err != nil {
+		t.Errorf("Delete(missing) error = %v, want nil", err)
+	}
+}
+
+func TestS3StorageRenameFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		failCopy   string
+		failDelete string
+		wantErr    error
+		wantKeys   string
+	}{
+		{
+			// 复制中途失败：撤销已复制的对象，原位置不变
+			name:     "copy fails",
+			failCopy: "new/1/002.jpg",
+			wantKeys: "old/1/001.jpg,old/1/002.jpg,old/cover.jpg",
+		},
+		{
+			// 删除原对象失败：新位置完整，报告残留
+			name:       "delete fails",
+			failDelete: "old/1/002.jpg",
+			wantErr:    ErrRenameIncomplete,
+			wantKeys:   "new/1/001.jpg,new/1/002.jpg,new/cover.jpg,old/1/002.jpg",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, srv := newFakeS3(t)
+			s := newTestS3Storage(t, srv.URL, "")
+			for _, key := range []string{"old/1/001.jpg", "old/1/002.jpg", "old/cover.jpg"} {
+				if err := s.Put(key, strings.NewReader(key), -1); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fake.failCopy, fake.failDelete = tt.failCopy, tt.failDelete
+
+			err := s.Rename("old", "new")
+			if err == nil {
+				t.Fatal("Rename succeeded, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Rename error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && errors.Is(err, ErrRenameIncomplete) {
+				t.Errorf("Rename error = %v, copy failure reported as incomplete rename", err)
+			}
+			if got := strings.Join(fake.keys(), ","); got != tt.wantKeys {
+				t.Errorf("after Rename keys = %s, want %s", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestS3StorageImport(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	s := newTestS3Storage(t, srv.URL, "")
+
+	dir := filepath.Join(t.TempDir(), "comic")
+	if err := os.MkdirAll(filepath.Join(dir, "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "1", "001.jpg"), []byte("page"), 0644)
+	os.WriteFile(filepath.Join(dir, "cover.jpg"), []byte("cover"), 0644)
+
+	if err := s.Import(dir, "imported"); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if got := fake.keys(); strings.Join(got, ",") != "imported/1/001.jpg,imported/cover.jpg" {
+		t.Errorf("imported keys = %v", got)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("local directory still exists after Import")
+	}
+}
+
+func TestS3StoragePresignedURL(t *testing.T) {
+	_, srv := newFakeS3(t)
+	s := newTestS3Storage(t, srv.URL, "lib")
+
+	key := "漫画 1/1/001.jpg"
+	if err := s.Put(key, bytes.NewReader([]byte("presigned")), 9); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := s.URL(key, time.Minute)
+	if err != nil {
+		t.Fatalf("URL: %v", err)
+	}
+
+	resp, err := http.Get(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "presigned" {
+		t.Fatalf("GET presigned url: HTTP %d %q", resp.StatusCode, data)
+	}
+
+	tampered := strings.Replace(signed, "X-Amz-Expires=60", "X-Amz-Expires=600", 1)
+	resp, err = http.Get(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("tampered url: HTTP %d, want 403", resp.StatusCode)
+	}
+}
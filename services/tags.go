@@ -0,0 +1,97 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tagRowsCTE 将漫画库展开为 (漫画, 命名空间, 标签) 行，需接在 libraryCTE 之后
+// 没有命名空间信息的旧记录使用扁平标签列表，归入空命名空间
+const tagRowsCTE = `, tag_rows AS (
+		SELECT library.id AS comic_id, g.key AS namespace, t.value AS tag
+		FROM library,
+		     json_each(CASE WHEN json_valid(library.tag_groups) THEN library.tag_groups ELSE '{}' END) g,
+		     json_each(g.value) t
+		WHERE g.type = 'array'
+		UNION
+		SELECT library.id, '', t.value
+		FROM library,
+		     json_each(CASE WHEN json_valid(library.tags) THEN library.tags ELSE '[]' END) t
+		WHERE library.tag_groups IS NULL OR library.tag_groups IN ('', 'null', '{}')
+	)`
+
+// TagCount 标签及使用该标签的漫画数量
+type TagCount struct {
+	Namespace string `json:"namespace"`
+	Tag       string `json:"tag"`
+	Count     int    `json:"count"`
+}
+
+// splitNamespacedTag 拆分 "namespace:tag" 形式的标签
+func splitNamespacedTag(s string) (namespace, tag string, ok bool) {
+	i := strings.Index(s, ":")
+	if i <= 0 || i == len(s)-1 {
+		return "", s, false
+	}
+	return normalizeTagNamespace(s[:i]), strings.TrimSpace(s[i+1:]), true
+}
+
+// tagCondition 生成标签过滤条件：
+// "namespace:tag" 匹配该命名空间下的标签，同时兼容扁平标签列表中的原始写法
+func tagCondition(tag string) (string, []interface{}) {
+	namespace, name, ok := splitNamespacedTag(tag)
+	if !ok {
+		return jsonArrayContains("library.tags"), []interface{}{tag}
+	}
+
+	path := `$."` + strings.ReplaceAll(namespace, `"`, `\"`) + `"`
+	cond := "(" + jsonArrayContains("library.tags") +
+		" OR EXISTS (SELECT 1 FROM json_each(CASE WHEN json_valid(library.tag_groups) THEN library.tag_groups ELSE '{}' END, ?) WHERE value = ?))"
+	return cond, []interface{}{tag, path, name}
+}
+
+// ListTags 列出所有标签及其漫画数量，namespace 非 nil 时只列出该命名空间
+func (dm *DownloadManager) ListTags(namespace *string) ([]TagCount, error) {
+	query := libraryCTE + tagRowsCTE + ` SELECT namespace, tag, COUNT(DISTINCT comic_id) FROM tag_rows`
+	var args []interface{}
+	if namespace != nil {
+		query += " WHERE namespace = ?"
+		args = append(args, normalizeTagNamespace(*namespace))
+	}
+	query += " GROUP BY namespace, tag ORDER BY COUNT(DISTINCT comic_id) DESC, namespace, tag"
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询标签失败: %w", err)
+	}
+	defer rows.Close()
+
+	tags := make([]TagCount, 0)
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Namespace, &tc.Tag, &tc.Count); err != nil {
+			continue
+		}
+		tags = append(tags, tc)
+	}
+	return tags, rows.Err()
+}
+
+// ListTagNamespaces 列出所有命名空间及其下的标签数量
+func (dm *DownloadManager) ListTagNamespaces() (map[string]int, error) {
+	rows, err := dm.db.Query(libraryCTE + tagRowsCTE + ` SELECT namespace, COUNT(DISTINCT tag) FROM tag_rows GROUP BY namespace`)
+	if err != nil {
+		return nil, fmt.Errorf("查询命名空间失败: %w", err)
+	}
+	defer rows.Close()
+
+	namespaces := make(map[string]int)
+	for rows.Next() {
+		var ns string
+		var count int
+		if rows.Scan(&ns, &count) == nil {
+			namespaces[ns] = count
+		}
+	}
+	return namespaces, rows.Err()
+}
@@ -0,0 +1,377 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pica-comic-server/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrTrashNotFound 回收站中没有该记录
+	ErrTrashNotFound = errors.New("回收站中没有该漫画")
+	// ErrTrashConflict 漫画在移入回收站后又被重新下载，无法恢复
+	ErrTrashConflict = errors.New("漫画已重新下载，无法恢复")
+)
+
+// trashPurgeInterval 后台检查过期回收站记录的间隔
+const trashPurgeInterval = time.Hour
+
+// TrashEntry 回收站中的漫画
+type TrashEntry struct {
+	ID        string              `json:"id"`
+	ComicID   string              `json:"comic_id"`
+	Title     string              `json:"title"`
+	Directory string              `json:"directory"` // 原目录名
+	Size      int64               `json:"size"`
+	Scanned   bool                `json:"scanned"` // 是否为扫描文件夹的漫画
+	DeletedAt time.Time           `json:"deleted_at"`
+	ExpiresAt *time.Time          `json:"expires_at,omitempty"` // 自动清除时间，未设置保留期时为空
+	Comic     *models.ComicDetail `json:"comic,omitempty"`      // 删除时的漫画信息
+}
+
+// trashDir 回收站目录（隐藏目录，不会被库索引扫描）
+func (dm *DownloadManager) trashDir() string {
+	return filepath.Join(dm.downloadPath, ".trash")
+}
+
+// trashKey 远程存储中回收站记录的位置
+func trashKey(entryID string) string {
+	return joinStorageKey(".trash", entryID)
+}
+
+// TrashComic 将漫画移入回收站：目录移动到 .trash 下，数据库记录标记为已删除
+func (dm *DownloadManager) TrashComic(id string) (*TrashEntry, error) {
+	comic, err := dm.GetComic(id)
+	if err != nil {
+		return nil, err
+	}
+	inDB, err := dm.isDBComic(id)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &TrashEntry{
+		ID:        uuid.New().String(),
+		ComicID:   id,
+		Title:     comic.Title,
+		Directory: comic.Directory,
+		Size:      comic.Size,
+		Scanned:   !inDB,
+		DeletedAt: time.Now(),
+		Comic:     comic,
+	}
+
+	if err := os.MkdirAll(dm.trashDir(), 0755); err != nil {
+		return nil, fmt.Errorf("创建回收站目录失败: %w", err)
+	}
+	comicPath := filepath.Join(dm.downloadPath, comic.Directory)
+	trashPath := filepath.Join(dm.trashDir(), entry.ID)
+	moved, movedRemote := false, false
+	if _, err := os.Stat(comicPath); err == nil {
+		if err := os.Rename(comicPath, trashPath); err != nil {
+			return nil, fmt.Errorf("移入回收站失败: %w", err)
+		}
+		moved = true
+	} else if dm.isStoredRemotely(comic.Directory) {
+		// 远程存储中的漫画移动到存储中的回收站位置
+		switch err := dm.renameStorage(comic.Directory, trashKey(entry.ID)); {
+		case err == nil:
+			movedRemote = true
+			pageIndex.forget(dm.storage, comic.Directory)
+		case !errors.Is(err, ErrObjectNotFound):
+			return nil, fmt.Errorf("移入回收站失败: %w", err)
+		}
+	}
+
+	if err := dm.markTrashed(entry, inDB); err != nil {
+		if moved {
+			os.Rename(trashPath, comicPath)
+		}
+		if movedRemote {
+			dm.renameStorage(trashKey(entry.ID), comic.Directory)
+		}
+		return nil, err
+	}
+
+	dm.removeCoverThumbnails(id)
+	removeComicFTS(dm.db, id)
+	log.Printf("[回收站] 已移入回收站: %s", comic.Title)
+	return entry, nil
+}
+
+// markTrashed 写入回收站记录，并标记或移除漫画库中的记录
+func (dm *DownloadManager) markTrashed(entry *TrashEntry, inDB bool) error {
+	detailJSON, _ := json.Marshal(entry.Comic)
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO trash (id, comic_id, title, directory, size, scanned, detail, deleted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.ID, entry.ComicID, entry.Title, entry.Directory, entry.Size, entry.Scanned,
+		string(detailJSON), entry.DeletedAt.Unix()); err != nil {
+		return fmt.Errorf("写入回收站记录失败: %w", err)
+	}
+
+	if inDB {
+		_, err = tx.Exec("UPDATE comics SET deleted_at = ? WHERE id = ?", entry.DeletedAt.Unix(), entry.ComicID)
+	} else {
+		_, err = tx.Exec("DELETE FROM scanned_comics WHERE directory = ?", entry.Directory)
+	}
+	if err != nil {
+		return err
+	}
+	// 章节号随漫画移入回收站，恢复后章节号不变
+	if _, err := tx.Exec("UPDATE episode_numbers SET directory = ? WHERE directory = ?", trashKey(entry.ID), entry.Directory); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// scanTrashEntry 读取回收站记录
+func scanTrashEntry(row rowScanner) (*TrashEntry, error) {
+	var e TrashEntry
+	var title, detail sql.NullString
+	var size sql.NullInt64
+	var deletedAt int64
+	if err := row.Scan(&e.ID, &e.ComicID, &title, &e.Directory, &size, &e.Scanned, &detail, &deletedAt); err != nil {
+		return nil, err
+	}
+	e.Title = title.String
+	e.Size = size.Int64
+	e.DeletedAt = time.Unix(deletedAt, 0)
+	if detail.Valid && detail.String != "" {
+		var comic models.ComicDetail
+		if json.Unmarshal([]byte(detail.String), &comic) == nil {
+			e.Comic = &comic
+		}
+	}
+	return &e, nil
+}
+
+const trashColumns = "id, comic_id, title, directory, size, scanned, detail, deleted_at"
+
+// getTrashEntry 获取回收站记录
+func (dm *DownloadManager) getTrashEntry(id string) (*TrashEntry, error) {
+	e, err := scanTrashEntry(dm.db.QueryRow("SELECT "+trashColumns+" FROM trash WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrTrashNotFound
+	}
+	return e, err
+}
+
+// ListTrash 列出回收站中的漫画（最近删除的在前）
+func (dm *DownloadManager) ListTrash() ([]TrashEntry, error) {
+	rows, err := dm.db.Query("SELECT " + trashColumns + " FROM trash ORDER BY deleted_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]TrashEntry, 0)
+	for rows.Next() {
+		e, err := scanTrashEntry(rows)
+		if err != nil {
+			continue
+		}
+		if dm.trashRetention > 0 {
+			expires := e.DeletedAt.Add(dm.trashRetention)
+			e.ExpiresAt = &expires
+		}
+		entries = append(entries, *e)
+	}
+	return entries, rows.Err()
+}
+
+// RestoreTrash 从回收站恢复漫画，原目录名已被占用时自动添加后缀
+func (dm *DownloadManager) RestoreTrash(id string) (*models.ComicDetail, error) {
+	entry, err := dm.getTrashEntry(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !entry.Scanned {
+		var deletedAt sql.NullInt64
+		err := dm.db.QueryRow("SELECT deleted_at FROM comics WHERE id = ?", entry.ComicID).Scan(&deletedAt)
+		if err == sql.ErrNoRows || (err == nil && !deletedAt.Valid) {
+			return nil, ErrTrashConflict
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	directory, err := dm.uniqueFolderName(entry.Directory)
+	if err != nil {
+		return nil, err
+	}
+	trashPath := filepath.Join(dm.trashDir(), entry.ID)
+	comicPath := filepath.Join(dm.downloadPath, directory)
+	if _, err := os.Stat(trashPath); err == nil {
+		if err := os.Rename(trashPath, comicPath); err != nil {
+			return nil, fmt.Errorf("恢复目录失败: %w", err)
+		}
+	} else if err := dm.restoreRemoteTrash(entry.ID, directory); err != nil {
+		if !errors.Is(err, ErrObjectNotFound) {
+			return nil, fmt.Errorf("恢复目录失败: %w", err)
+		}
+		if err := os.MkdirAll(comicPath, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := dm.moveEpisodeNumbers(trashKey(entry.ID), directory); err != nil {
+		return nil, fmt.Errorf("恢复章节号失败: %w", err)
+	}
+
+	var comic *models.ComicDetail
+	if entry.Scanned {
+		// 扫描的漫画重新索引，目录名变化时ID也会变化
+		if err := dm.indexScannedFolder(directory, folderSignature(comicPath)); err != nil {
+			return nil, err
+		}
+		comic, err = dm.GetComic(generateComicID(directory))
+	} else {
+		if _, err := dm.db.Exec("UPDATE comics SET deleted_at = NULL, directory = ? WHERE id = ?", directory, entry.ComicID); err != nil {
+			return nil, fmt.Errorf("恢复漫画记录失败: %w", err)
+		}
+		if comic, err = dm.GetComic(entry.ComicID); err == nil {
+			syncComicFTS(dm.db, comic)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dm.db.Exec("DELETE FROM trash WHERE id = ?", entry.ID); err != nil {
+		return nil, err
+	}
+	log.Printf("[回收站] 已恢复: %s -> %s", entry.Title, directory)
+	return comic, nil
+}
+
+// restoreRemoteTrash 将远程存储回收站中的漫画移回原位置，没有使用远程存储或不在其中时返回 ErrObjectNotFound
+func (dm *DownloadManager) restoreRemoteTrash(entryID, directory string) error {
+	if !dm.remoteStorage() {
+		return ErrObjectNotFound
+	}
+	return dm.renameStorage(trashKey(entryID), directory)
+}
+
+// PurgeTrash 彻底删除回收站中的漫画
+func (dm *DownloadManager) PurgeTrash(id string) error {
+	if err := dm.purgeTrash(id); err != nil {
+		return err
+	}
+	dm.collectPageObjects()
+	return nil
+}
+
+// purgeTrash 彻底删除回收站中的漫画（不清除内容寻址存储中已无引用的页面）
+func (dm *DownloadManager) purgeTrash(id string) error {
+	entry, err := dm.getTrashEntry(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(dm.trashDir(), entry.ID)); err != nil {
+		return fmt.Errorf("删除文件失败: %w", err)
+	}
+	if dm.remoteStorage() {
+		if err := dm.storage.DeleteAll(trashKey(entry.ID)); err != nil {
+			return fmt.Errorf("删除文件失败: %w", err)
+		}
+	}
+
+	if !entry.Scanned {
+		if _, err := dm.db.Exec("DELETE FROM comics WHERE id = ? AND deleted_at IS NOT NULL", entry.ComicID); err != nil {
+			return err
+		}
+	}
+	if _, err := dm.db.Exec("DELETE FROM episode_numbers WHERE directory = ?", trashKey(entry.ID)); err != nil {
+		return err
+	}
+	// 漫画没有重新出现在库中时，清理关联的收藏和阅读进度
+	if _, err := dm.GetComic(entry.ComicID); err == sql.ErrNoRows {
+		if _, err := dm.db.Exec("DELETE FROM collection_items WHERE comic_id = ?", entry.ComicID); err != nil {
+			return err
+		}
+		if err := dm.DeleteReadingProgress(entry.ComicID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dm.db.Exec("DELETE FROM trash WHERE id = ?", entry.ID); err != nil {
+		return err
+	}
+	log.Printf("[回收站] 已彻底删除: %s", entry.Title)
+	return nil
+}
+
+// purgeTrashBefore 彻底删除指定时间之前移入回收站的漫画，返回删除的数量
+func (dm *DownloadManager) purgeTrashBefore(before time.Time) (int, error) {
+	rows, err := dm.db.Query("SELECT id FROM trash WHERE deleted_at < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	purged := 0
+	for _, id := range ids {
+		if err := dm.purgeTrash(id); err != nil {
+			log.Printf("[回收站] 清除失败: %s, 错误: %v", id, err)
+			continue
+		}
+		purged++
+	}
+	if purged > 0 {
+		dm.collectPageObjects()
+	}
+	return purged, nil
+}
+
+// EmptyTrash 清空回收站
+func (dm *DownloadManager) EmptyTrash() (int, error) {
+	return dm.purgeTrashBefore(time.Now().Add(time.Second))
+}
+
+// StartTrashPurger 启动后台清理，超过保留期的漫画会被彻底删除（retention <= 0 表示不自动清理）
+func (dm *DownloadManager) StartTrashPurger(retention time.Duration) {
+	dm.trashRetention = retention
+	if retention <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := dm.purgeTrashBefore(time.Now().Add(-retention)); err != nil {
+				log.Printf("[回收站] 自动清理失败: %v", err)
+			} else if n > 0 {
+				log.Printf("[回收站] 已自动清除 %d 部过期漫画", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,413 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 可续传上传的错误
+var (
+	ErrUploadNotFound        = errors.New("上传会话不存在或已过期")
+	ErrUploadOffsetMismatch  = errors.New("上传偏移与已接收的数据不一致")
+	ErrUploadOutOfRange      = errors.New("分块超出文件大小")
+	ErrUploadIncomplete      = errors.New("文件尚未上传完成")
+	ErrUploadBusy            = errors.New("该上传正在写入或导入中")
+	ErrInvalidUploadLength   = errors.New("无效的文件大小")
+	ErrMissingImportMetadata = errors.New("缺少必要字段")
+)
+
+const (
+	// MaxUploadSize 单个上传会话允许的最大文件大小
+	MaxUploadSize int64 = 16 << 30
+	// DefaultUploadExpiry 上传会话在最后一次写入后保留的时长
+	DefaultUploadExpiry = 24 * time.Hour
+	// uploadCleanupInterval 检查过期上传会话的间隔
+	uploadCleanupInterval = time.Hour
+)
+
+// UploadRange 已接收的字节区间 [Start, End)
+type UploadRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// UploadSession 可续传上传会话：数据按偏移写入同一个文件，记录已接收的区间，
+// 全部接收后再作为压缩包导入
+type UploadSession struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`   // 从 0 开始连续接收到的字节数
+	Received  int64             `json:"received"` // 已接收的字节总数（可以不连续）
+	Complete  bool              `json:"complete"`
+	Ranges    []UploadRange     `json:"ranges"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// addRange 记录新接收的区间，并与已有区间合并
+func (s *UploadSession) addRange(start, end int64) {
+	if end <= start {
+		return
+	}
+	ranges := append(s.Ranges, UploadRange{Start: start, End: end})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End {
+			if r.End > last.End {
+				last.End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	s.Ranges = merged
+	s.refresh()
+}
+
+// refresh 根据已接收区间更新统计字段
+func (s *UploadSession) refresh() {
+	s.Offset, s.Received = 0, 0
+	for _, r := range s.Ranges {
+		if r.Start == 0 {
+			s.Offset = r.End
+		}
+		s.Received += r.End - r.Start
+	}
+	s.Complete = s.Offset == s.Length
+}
+
+// uploadEntry 内存中的上传会话，busy 保证同一会话同时只有一个写入或导入
+type uploadEntry struct {
+	session UploadSession
+	busy    sync.Mutex
+}
+
+// uploadStore 上传会话存储：数据文件 <id>.part 和状态文件 <id>.json 位于 .cache/uploads，
+// 服务重启后仍可继续上传
+type uploadStore struct {
+	mu       sync.Mutex
+	dir      string
+	expiry   time.Duration
+	sessions map[string]*uploadEntry
+}
+
+// newUploadStore 打开上传目录并加载未过期的会话，清理过期会话和残留文件
+func newUploadStore(dir string) (*uploadStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建上传目录失败: %w", err)
+	}
+	store := &uploadStore{dir: dir, expiry: DefaultUploadExpiry, sessions: make(map[string]*uploadEntry)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		name := entry.Name()
+		id := strings.TrimSuffix(strings.TrimSuffix(name, ".json"), ".part")
+		if strings.HasSuffix(name, ".json") {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			var session UploadSession
+			if err == nil && json.Unmarshal(data, &session) == nil && session.ID == id && now.Before(session.ExpiresAt) {
+				store.sessions[id] = &uploadEntry{session: session}
+				continue
+			}
+		} else if _, err := os.Stat(filepath.Join(dir, id+".json")); err == nil {
+			continue
+		}
+		os.Remove(filepath.Join(dir, name))
+	}
+	for id := range store.sessions {
+		if _, err := os.Stat(store.partPath(id)); err != nil {
+			store.remove(id)
+		}
+	}
+	return store, nil
+}
+
+func (s *uploadStore) partPath(id string) string {
+	return filepath.Join(s.dir, id+".part")
+}
+
+func (s *uploadStore) statePath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// save 写入会话状态（先写临时文件再重命名，避免中断时损坏）
+func (s *uploadStore) save(session *UploadSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	tmp := s.statePath(session.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.statePath(session.ID))
+}
+
+// get 获取未过期的会话
+func (s *uploadStore) get(id string) (*uploadEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.sessions[id]
+	if !ok || !time.Now().Before(entry.session.ExpiresAt) {
+		return nil, ErrUploadNotFound
+	}
+	return entry, nil
+}
+
+// snapshot 复制会话状态（区间切片单独复制）
+func (s *uploadStore) snapshot(entry *uploadEntry) *UploadSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := entry.session
+	session.Ranges = append([]UploadRange{}, entry.session.Ranges...)
+	return &session
+}
+
+// remove 删除会话及其文件
+func (s *uploadStore) remove(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	os.Remove(s.partPath(id))
+	os.Remove(s.statePath(id))
+}
+
+// CreateUpload 创建上传会话，length 为完整文件大小，metadata 为导入元数据（可在完成时再提供）
+func (dm *DownloadManager) CreateUpload(length int64, metadata map[string]string) (*UploadSession, error) {
+	if length <= 0 || length > MaxUploadSize {
+		return nil, ErrInvalidUploadLength
+	}
+	if err := dm.ensureDiskSpace(); err != nil {
+		return nil, err
+	}
+
+	store := dm.uploads
+	now := time.Now()
+	entry := &uploadEntry{session: UploadSession{
+		ID:        uuid.New().String(),
+		Length:    length,
+		Ranges:    []UploadRange{},
+		Metadata:  metadata,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(store.expiry),
+	}}
+
+	f, err := os.Create(store.partPath(entry.session.ID))
+	if err != nil {
+		return nil, fmt.Errorf("创建上传文件失败: %w", err)
+	}
+	f.Close()
+	if err := store.save(&entry.session); err != nil {
+		os.Remove(store.partPath(entry.session.ID))
+		return nil, fmt.Errorf("保存上传状态失败: %w", err)
+	}
+
+	store.mu.Lock()
+	store.sessions[entry.session.ID] = entry
+	store.mu.Unlock()
+
+	log.Printf("[上传] 创建上传会话: %s (%.2f MB)", entry.session.ID, float64(length)/(1024*1024))
+	return store.snapshot(entry), nil
+}
+
+// GetUpload 获取上传会话状态
+func (dm *DownloadManager) GetUpload(id string) (*UploadSession, error) {
+	entry, err := dm.uploads.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return dm.uploads.snapshot(entry), nil
+}
+
+// WriteUploadChunk 将分块写入 offset 处。sequential 为 true 时（tus 协议）offset 必须等于已连续接收的字节数；
+// 否则可以按任意顺序上传分块。连接中断时已收到的部分同样会被记录，客户端可据此续传
+func (dm *DownloadManager) WriteUploadChunk(id string, offset int64, r io.Reader, sequential bool) (*UploadSession, error) {
+	store := dm.uploads
+	entry, err := store.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.busy.TryLock() {
+		return nil, ErrUploadBusy
+	}
+	defer entry.busy.Unlock()
+
+	current := store.snapshot(entry)
+	if sequential && offset != current.Offset {
+		return current, ErrUploadOffsetMismatch
+	}
+	if offset < 0 || offset >= current.Length {
+		return current, ErrUploadOutOfRange
+	}
+
+	f, err := os.OpenFile(store.partPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开上传文件失败: %w", err)
+	}
+	remaining := current.Length - offset
+	written, copyErr := io.Copy(io.NewOffsetWriter(f, offset), io.LimitReader(r, remaining))
+	if closeErr := f.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr == nil && written == remaining {
+		// 分块比剩余长度更长
+		if n, _ := r.Read(make([]byte, 1)); n > 0 {
+			copyErr = ErrUploadOutOfRange
+		}
+	}
+
+	store.mu.Lock()
+	entry.session.addRange(offset, offset+written)
+	entry.session.UpdatedAt = time.Now()
+	entry.session.ExpiresAt = entry.session.UpdatedAt.Add(store.expiry)
+	store.mu.Unlock()
+
+	session := store.snapshot(entry)
+	if err := store.save(session); err != nil {
+		return session, fmt.Errorf("保存上传状态失败: %w", err)
+	}
+	return session, copyErr
+}
+
+// DeleteUpload 取消上传并删除已接收的数据
+func (dm *DownloadManager) DeleteUpload(id string) error {
+	entry, err := dm.uploads.get(id)
+	if err != nil {
+		return err
+	}
+	if !entry.busy.TryLock() {
+		return ErrUploadBusy
+	}
+	defer entry.busy.Unlock()
+	dm.uploads.remove(id)
+	log.Printf("[上传] 已取消上传会话: %s", id)
+	return nil
+}
+
+// uploadMetadata 用创建会话时提供的元数据补全导入元数据中的空字段
+func uploadMetadata(meta ImportMetadata, metadata map[string]string) ImportMetadata {
+	fields := []struct {
+		value *string
+		key   string
+	}{
+		{&meta.ComicID, "comic_id"},
+		{&meta.Title, "title"},
+		{&meta.Type, "type"},
+		{&meta.Author, "author"},
+		{&meta.Description, "description"},
+		{&meta.Cover, "cover"},
+		{&meta.Eps, "eps"},
+		{&meta.Tags, "tags"},
+		{&meta.DownloadTime, "download_time"},
+		{&meta.OnDuplicate, "on_duplicate"},
+	}
+	for _, f := range fields {
+		if *f.value == "" {
+			*f.value = metadata[f.key]
+		}
+	}
+	return meta
+}
+
+// FinalizeUpload 上传完成后将文件作为压缩包导入（与 ImportComicArchive 相同），成功后删除会话
+// meta 中为空的字段使用创建会话时提供的元数据；导入失败时会话保留，可修正元数据后重试
+func (dm *DownloadManager) FinalizeUpload(id string, meta ImportMetadata) (*ImportResult, error) {
+	store := dm.uploads
+	entry, err := store.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.busy.TryLock() {
+		return nil, ErrUploadBusy
+	}
+	defer entry.busy.Unlock()
+
+	session := store.snapshot(entry)
+	if !session.Complete {
+		return nil, ErrUploadIncomplete
+	}
+	meta = uploadMetadata(meta, session.Metadata)
+	if meta.ComicID == "" || meta.Title == "" || meta.Type == "" {
+		return nil, ErrMissingImportMetadata
+	}
+
+	f, err := os.Open(store.partPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("打开上传文件失败: %w", err)
+	}
+	result, err := dm.ImportComicArchive(f, session.Length, meta)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	store.remove(id)
+	log.Printf("[上传] 上传会话 %s 已导入为漫画 '%s'", id, meta.Title)
+	return result, nil
+}
+
+// purgeExpiredUploads 删除过期的上传会话，正在写入的会话跳过
+func (dm *DownloadManager) purgeExpiredUploads() int {
+	store := dm.uploads
+	now := time.Now()
+	var expired []*uploadEntry
+	store.mu.Lock()
+	for _, entry := range store.sessions {
+		if !now.Before(entry.session.ExpiresAt) {
+			expired = append(expired, entry)
+		}
+	}
+	store.mu.Unlock()
+
+	n := 0
+	for _, entry := range expired {
+		if !entry.busy.TryLock() {
+			continue
+		}
+		store.remove(entry.session.ID)
+		entry.busy.Unlock()
+		n++
+	}
+	return n
+}
+
+// StartUploadJanitor 设置上传会话的过期时间（最后一次写入后计算），并启动后台清理
+func (dm *DownloadManager) StartUploadJanitor(expiry time.Duration) {
+	if expiry <= 0 {
+		expiry = DefaultUploadExpiry
+	}
+	dm.uploads.mu.Lock()
+	dm.uploads.expiry = expiry
+	dm.uploads.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(uploadCleanupInterval)
+		defer ticker.Stop()
+		for {
+			if n := dm.purgeExpiredUploads(); n > 0 {
+				log.Printf("[上传] 已清理 %d 个过期的上传会话", n)
+			}
+			<-ticker.C
+		}
+	}()
+}